@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	// AlgorithmHS256 は共有鍵（HMAC-SHA256）で署名する
+	AlgorithmHS256 = "HS256"
+	// AlgorithmRS256 はRSA秘密鍵で署名し、公開鍵で検証する
+	AlgorithmRS256 = "RS256"
+
+	// DefaultTokenTTL はアクセストークンの有効期間のデフォルト値（期限切れ後はリフレッシュトークンで再発行する）
+	DefaultTokenTTL = 15 * time.Minute
+)
+
+var (
+	// ErrInvalidToken は署名・形式・発行者・対象が正しくないトークン
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrTokenExpired は有効期限が切れたトークン
+	ErrTokenExpired = errors.New("token expired")
+	// ErrSessionRevoked はログアウトなどで失効したセッションのトークン
+	ErrSessionRevoked = errors.New("session revoked")
+	// ErrAccountBanned は利用停止（BAN）中のユーザー
+	ErrAccountBanned = errors.New("account banned")
+)
+
+// Config はトークンの署名・検証の設定
+//
+// HS256 では Secret、RS256 では PrivateKey（検証のみの場合は PublicKey）が必要
+type Config struct {
+	Algorithm  string
+	Secret     []byte
+	PrivateKey *rsa.PrivateKey
+	PublicKey  *rsa.PublicKey
+	Issuer     string
+	Audience   string
+	// TTL はアクセストークンの有効期間（0以下は DefaultTokenTTL）
+	TTL time.Duration
+}
+
+// Claims はアクセストークンのクレーム（Subject はユーザーID）
+//
+// SessionID はトークンを発行したセッション（外部のIDプロバイダーのトークンでは空）
+type Claims struct {
+	jwt.RegisteredClaims
+	SessionID string `json:"sid,omitempty"`
+}
+
+// UserID はトークンの対象のユーザーIDを返す
+func (c *Claims) UserID() string {
+	return c.Subject
+}
+
+// TokenManager はアクセストークンを発行・検証する
+type TokenManager struct {
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+	issuer    string
+	audience  string
+	ttl       time.Duration
+	now       func() time.Time
+}
+
+// NewTokenManager は設定を検証してTokenManagerを作成
+func NewTokenManager(cfg Config) (*TokenManager, error) {
+	m := &TokenManager{
+		issuer:   cfg.Issuer,
+		audience: cfg.Audience,
+		ttl:      cfg.TTL,
+		now:      time.Now,
+	}
+	if m.ttl <= 0 {
+		m.ttl = DefaultTokenTTL
+	}
+
+	switch cfg.Algorithm {
+	case AlgorithmHS256, "":
+		if len(cfg.Secret) < 32 {
+			return nil, fmt.Errorf("HS256 secret must be at least 32 bytes")
+		}
+		m.method = jwt.SigningMethodHS256
+		m.signKey = cfg.Secret
+		m.verifyKey = cfg.Secret
+	case AlgorithmRS256:
+		publicKey := cfg.PublicKey
+		if publicKey == nil && cfg.PrivateKey != nil {
+			publicKey = &cfg.PrivateKey.PublicKey
+		}
+		if publicKey == nil {
+			return nil, fmt.Errorf("RS256 requires a private or public key")
+		}
+		m.method = jwt.SigningMethodRS256
+		if cfg.PrivateKey != nil {
+			m.signKey = cfg.PrivateKey
+		}
+		m.verifyKey = publicKey
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", cfg.Algorithm)
+	}
+	return m, nil
+}
+
+// Issue はセッションのアクセストークンを発行し、有効期限とともに返す
+func (m *TokenManager) Issue(userID, sessionID string) (string, time.Time, error) {
+	if m.signKey == nil {
+		return "", time.Time{}, fmt.Errorf("token manager has no signing key")
+	}
+
+	now := m.now()
+	expiresAt := now.Add(m.ttl).Truncate(time.Second)
+	claims := &Claims{RegisteredClaims: jwt.RegisteredClaims{
+		ID:        uuid.New().String(),
+		Subject:   userID,
+		Issuer:    m.issuer,
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+	}, SessionID: sessionID}
+	if m.audience != "" {
+		claims.Audience = jwt.ClaimStrings{m.audience}
+	}
+
+	token, err := jwt.NewWithClaims(m.method, claims).SignedString(m.signKey)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("sign token: %w", err)
+	}
+	return token, expiresAt, nil
+}
+
+// Verify は署名・有効期限・発行者・対象を検証してクレームを返す
+func (m *TokenManager) Verify(token string) (*Claims, error) {
+	opts := []jwt.ParserOption{
+		// 署名アルゴリズムを固定し、alg の差し替え（none や HS/RS の混同）を防ぐ
+		jwt.WithValidMethods([]string{m.method.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithTimeFunc(m.now),
+	}
+	if m.issuer != "" {
+		opts = append(opts, jwt.WithIssuer(m.issuer))
+	}
+	if m.audience != "" {
+		opts = append(opts, jwt.WithAudience(m.audience))
+	}
+
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return m.verifyKey, nil
+	}, opts...)
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return nil, ErrTokenExpired
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	}
+	return claims, nil
+}
+
+// LoadRSAPrivateKey はPEM形式（PKCS#1 / PKCS#8）のRSA秘密鍵を読み込む
+func LoadRSAPrivateKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read private key: %w", err)
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse private key: %w", err)
+	}
+	return key, nil
+}
+
+// LoadRSAPublicKey はPEM形式のRSA公開鍵（PKIX / 証明書）を読み込む
+func LoadRSAPublicKey(path string) (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read public key: %w", err)
+	}
+	key, err := jwt.ParseRSAPublicKeyFromPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse public key: %w", err)
+	}
+	return key, nil
+}
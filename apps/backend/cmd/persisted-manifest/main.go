@@ -0,0 +1,184 @@
+// persisted-manifest はフロントエンドの operations.graphql から登録済みの操作の一覧を生成する
+//
+// 生成したファイルをサーバーの -persisted-queries に渡すと、クライアントはクエリ文字列の代わりに
+// ハッシュだけを送れる（-trusted-documents では一覧にある操作だけが実行される）。
+//
+//	go run ./cmd/persisted-manifest -o ../frontend/src/graphql/persisted-query-manifest.json ../frontend/src/graphql/operations.graphql
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kajidog/graphql-sse-test/apps/backend/server"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/formatter"
+)
+
+var (
+	schemaGlob = flag.String("schema", "graph/*.graphqls", "schema files (glob)")
+	output     = flag.String("o", "", "output file (default stdout)")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: persisted-manifest [flags] operations.graphql...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	schema, err := loadSchema(*schemaGlob)
+	if err != nil {
+		log.Fatal(err)
+	}
+	manifest := server.PersistedQueryManifest{
+		Format:     server.PersistedQueryManifestFormat,
+		Version:    1,
+		Operations: []server.PersistedOperation{},
+	}
+	for _, path := range flag.Args() {
+		operations, err := loadOperations(schema, path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		manifest.Operations = append(manifest.Operations, operations...)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	data = append(data, '\n')
+	if *output == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(*output, data, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// loadSchema はスキーマを読み込む
+func loadSchema(pattern string) (*ast.Schema, error) {
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no schema files match %s", pattern)
+	}
+	var sources []*ast.Source
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, &ast.Source{Name: path, Input: string(data)})
+	}
+	return gqlparser.LoadSchema(sources...)
+}
+
+// loadOperations はスキーマで検証した操作を、使っているフラグメントと合わせて1件ずつの文書にする
+func loadOperations(schema *ast.Schema, path string) ([]server.PersistedOperation, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc, errs := gqlparser.LoadQuery(schema, string(data))
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%s: %w", path, errs)
+	}
+
+	// Apollo Client はキャッシュのために __typename を付けて送るため、登録する操作にも付ける
+	for _, op := range doc.Operations {
+		for _, selection := range op.SelectionSet {
+			addTypename(selection)
+		}
+	}
+	for _, fragment := range doc.Fragments {
+		fragment.SelectionSet = withTypename(fragment.SelectionSet)
+	}
+
+	operations := make([]server.PersistedOperation, 0, len(doc.Operations))
+	for _, op := range doc.Operations {
+		if op.Name == "" {
+			return nil, fmt.Errorf("%s: operations must be named", path)
+		}
+		body := &ast.QueryDocument{Operations: ast.OperationList{op}}
+		for _, name := range usedFragments(doc, op.SelectionSet, map[string]bool{}) {
+			body.Fragments = append(body.Fragments, doc.Fragments.ForName(name))
+		}
+
+		var buf bytes.Buffer
+		formatter.NewFormatter(&buf, formatter.WithIndent("  ")).FormatQueryDocument(body)
+		query := strings.TrimSpace(buf.String())
+		operations = append(operations, server.PersistedOperation{
+			ID:   server.PersistedQueryHash(query),
+			Name: op.Name,
+			Type: string(op.Operation),
+			Body: query,
+		})
+	}
+	return operations, nil
+}
+
+// addTypename はフィールド以下の選択セットに __typename を追加（ルートの操作には付けない）
+func addTypename(selection ast.Selection) {
+	switch s := selection.(type) {
+	case *ast.Field:
+		if len(s.SelectionSet) > 0 {
+			s.SelectionSet = withTypename(s.SelectionSet)
+		}
+	case *ast.InlineFragment:
+		for _, child := range s.SelectionSet {
+			addTypename(child)
+		}
+	}
+}
+
+// withTypename は選択セットに __typename が無ければ追加し、子の選択セットにも追加する
+func withTypename(selections ast.SelectionSet) ast.SelectionSet {
+	for _, selection := range selections {
+		addTypename(selection)
+	}
+	for _, selection := range selections {
+		if field, ok := selection.(*ast.Field); ok && field.Alias == "__typename" {
+			return selections
+		}
+	}
+	return append(selections, &ast.Field{Name: "__typename", Alias: "__typename"})
+}
+
+// usedFragments は選択セットが（間接的にも）使っているフラグメントの名前
+func usedFragments(doc *ast.QueryDocument, selections ast.SelectionSet, seen map[string]bool) []string {
+	var names []string
+	for _, selection := range selections {
+		switch s := selection.(type) {
+		case *ast.Field:
+			names = append(names, usedFragments(doc, s.SelectionSet, seen)...)
+		case *ast.InlineFragment:
+			names = append(names, usedFragments(doc, s.SelectionSet, seen)...)
+		case *ast.FragmentSpread:
+			if seen[s.Name] {
+				continue
+			}
+			seen[s.Name] = true
+			names = append(names, s.Name)
+			if fragment := doc.Fragments.ForName(s.Name); fragment != nil {
+				names = append(names, usedFragments(doc, fragment.SelectionSet, seen)...)
+			}
+		}
+	}
+	return names
+}
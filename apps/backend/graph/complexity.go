@@ -0,0 +1,57 @@
+package graph
+
+import "github.com/kajidog/graphql-sse-test/apps/backend/service"
+
+// listComplexityEstimate は件数を指定できない一覧フィールドの想定件数
+const listComplexityEstimate = 10
+
+// NewComplexity は一覧フィールドのコスト計算を作成
+//
+// 一覧は取得する件数（first / last / limit、指定できないものは想定件数）を子のコストに掛ける。
+// それ以外のフィールドは gqlgen の既定（子のコスト + 1）のまま。
+func NewComplexity() ComplexityRoot {
+	var c ComplexityRoot
+
+	c.Query.Messages = func(childComplexity int, roomID string) int {
+		// 全件を返すため、ページングの最大件数とみなす
+		return listComplexity(childComplexity, service.MaxPageSize)
+	}
+	c.Query.MessagesConnection = func(childComplexity int, roomID string, first *int, after *string, last *int, before *string) int {
+		count := service.DefaultPageSize
+		if first != nil {
+			count = *first
+		} else if last != nil {
+			count = *last
+		}
+		return listComplexity(childComplexity, min(max(count, 1), service.MaxPageSize))
+	}
+	c.Query.ModerationLog = func(childComplexity int, userID *string, limit *int) int {
+		count := service.MaxModerationLogLimit
+		if limit != nil {
+			count = min(max(*limit, 1), service.MaxModerationLogLimit)
+		}
+		return listComplexity(childComplexity, count)
+	}
+	c.Query.Rooms = func(childComplexity int, includeArchived *bool) int {
+		return listComplexity(childComplexity, listComplexityEstimate)
+	}
+	c.Query.DirectRooms = func(childComplexity int) int {
+		return listComplexity(childComplexity, listComplexityEstimate)
+	}
+	c.Query.SubscriberStats = func(childComplexity int) int {
+		return listComplexity(childComplexity, listComplexityEstimate)
+	}
+	c.Room.Members = func(childComplexity int) int {
+		return listComplexity(childComplexity, listComplexityEstimate)
+	}
+	c.Message.History = func(childComplexity int) int {
+		return listComplexity(childComplexity, listComplexityEstimate)
+	}
+
+	return c
+}
+
+// listComplexity は count 件の一覧のコスト
+func listComplexity(childComplexity, count int) int {
+	return 1 + count*childComplexity
+}
@@ -0,0 +1,71 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/kajidog/graphql-sse-test/apps/backend/graph/model"
+	"github.com/kajidog/graphql-sse-test/apps/backend/middleware"
+	"github.com/kajidog/graphql-sse-test/apps/backend/service"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// NewDirectives はスキーマのディレクティブ（@auth / @hasRole）の実装を作成
+//
+// フィールドの解決前に実行されるため、クエリ・ミューテーション・サブスクリプションのどれでも、
+// POSTとSSEのどちらのトランスポートでも同じように認可される。
+// ロールと利用停止はトークンに含めず毎回ストアから読むため、変更はすぐに反映される。
+func NewDirectives(users service.UserService, moderation service.ModerationService) DirectiveRoot {
+	return DirectiveRoot{
+		Auth: func(ctx context.Context, obj interface{}, next graphql.Resolver) (interface{}, error) {
+			if _, err := authenticatedUser(ctx, users, moderation); err != nil {
+				return nil, err
+			}
+			return next(ctx)
+		},
+		HasRole: func(ctx context.Context, obj interface{}, next graphql.Resolver, role model.Role) (interface{}, error) {
+			user, err := authenticatedUser(ctx, users, moderation)
+			if err != nil {
+				return nil, err
+			}
+			if !user.HasRole(role) {
+				return nil, forbidden(fmt.Sprintf("forbidden: requires the %s role", role))
+			}
+			return next(ctx)
+		},
+		Stream: stream,
+	}
+}
+
+// authenticatedUser はログイン中のユーザーを取得
+//
+// 未ログイン・存在しないユーザーは UNAUTHENTICATED、利用停止中のユーザーは FORBIDDEN
+func authenticatedUser(ctx context.Context, users service.UserService, moderation service.ModerationService) (*model.User, error) {
+	userID, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		return nil, unauthenticated("unauthorized: user not logged in")
+	}
+	user, ok := users.GetUser(userID)
+	if !ok {
+		return nil, unauthenticated("unauthorized: user not found")
+	}
+	if moderation.IsBanned(user.ID) {
+		return nil, forbidden("forbidden: account banned")
+	}
+	return user, nil
+}
+
+func unauthenticated(message string) *gqlerror.Error {
+	return &gqlerror.Error{
+		Message:    message,
+		Extensions: map[string]interface{}{"code": "UNAUTHENTICATED"},
+	}
+}
+
+func forbidden(message string) *gqlerror.Error {
+	return &gqlerror.Error{
+		Message:    message,
+		Extensions: map[string]interface{}{"code": "FORBIDDEN"},
+	}
+}
@@ -0,0 +1,38 @@
+package graph_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/kajidog/graphql-sse-test/apps/backend/server/servertest"
+)
+
+func TestRoomQueriesRequireLogin(t *testing.T) {
+	srv := servertest.New(t, servertest.Options{})
+	token := srv.Register(t, "alice")
+
+	for _, query := range []string{
+		`{ rooms { id members { username } } }`,
+		`{ room(id: "general") { id members { username } } }`,
+	} {
+		// 未ログインではルームも参加者も返さない
+		res := srv.Do(t, "", query, nil)
+		if len(res.Errors) != 1 {
+			t.Fatalf("%s: errors = %s, want 1 error", query, res.Errors)
+		}
+		var gqlErr struct {
+			Extensions struct{ Code string } `json:"extensions"`
+		}
+		if err := json.Unmarshal(res.Errors[0], &gqlErr); err != nil {
+			t.Fatal(err)
+		}
+		if gqlErr.Extensions.Code != "UNAUTHENTICATED" {
+			t.Errorf("%s: error = %s, want UNAUTHENTICATED", query, res.Errors[0])
+		}
+		if string(res.Data) != "null" && string(res.Data) != `{"room":null}` {
+			t.Errorf("%s: data = %s, want no rooms", query, res.Data)
+		}
+
+		srv.MustDo(t, token, query, nil, nil)
+	}
+}
@@ -0,0 +1,143 @@
+package graph
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// NewIncrementalExecutableSchema は NewExecutableSchema に段階的な配信を加えたものを作成
+//
+// 生成されたスキーマが後続の結果を返すのは @defer を含むクエリだけのため、
+// クエリとミューテーションの実行をここで行い、@defer の遅延グループと @stream の残りの要素を順に返す。
+// サブスクリプションは生成されたスキーマのまま実行する。生成されたコードの非公開の状態を扱う部分は
+// incremental_adapter.go にまとめている。
+func NewIncrementalExecutableSchema(cfg Config) graphql.ExecutableSchema {
+	return &incrementalSchema{executableSchema: NewExecutableSchema(cfg).(*executableSchema)}
+}
+
+type incrementalSchema struct {
+	*executableSchema
+}
+
+func (e *incrementalSchema) Exec(ctx context.Context) graphql.ResponseHandler {
+	rc := graphql.GetOperationContext(ctx)
+	root, ok := rootMarshalerFor(rc.Operation.Operation)
+	if !ok {
+		return e.executableSchema.Exec(ctx)
+	}
+
+	ec := newIncrementalExecutionContext(rc, e.executableSchema)
+	inputUnmarshalMap := graphql.BuildUnmarshalerMap()
+	first := true
+
+	return func(ctx context.Context) *graphql.Response {
+		var response graphql.Response
+		var data graphql.Marshaler
+		if first {
+			first = false
+			ctx = graphql.WithUnmarshalerMap(ctx, inputUnmarshalMap)
+			ctx = context.WithValue(ctx, streamContextKey{}, ec)
+			data = root(ec, ctx, rc.Operation.SelectionSet)
+		} else {
+			if !ec.hasPendingDeferred() {
+				return nil
+			}
+			result := ec.nextDeferred()
+			data = result.Result
+			response.Path = result.Path
+			response.Label = result.Label
+			response.Errors = result.Errors
+		}
+		var buf bytes.Buffer
+		data.MarshalGQL(&buf)
+		response.Data = buf.Bytes()
+		if ec.hasDeferred() {
+			hasNext := ec.hasPendingDeferred()
+			response.HasNext = &hasNext
+		}
+		return &response
+	}
+}
+
+type streamContextKey struct{}
+
+// stream は @stream の実装
+//
+// 段階的に配信できる実行（NewIncrementalExecutableSchema）では先頭 initialCount 件だけを返し、
+// 残りの要素は1件ずつ解決して後続の結果（data が1件の配列）として返す。それ以外では一覧をそのまま返す。
+func stream(ctx context.Context, obj interface{}, next graphql.Resolver, ifArg bool, label *string, initialCount int) (interface{}, error) {
+	if initialCount < 0 {
+		return nil, fmt.Errorf("@stream initialCount must not be negative")
+	}
+	res, err := next(ctx)
+	if err != nil || !ifArg {
+		return res, err
+	}
+	ec, ok := ctx.Value(streamContextKey{}).(*executionContext)
+	if !ok {
+		return res, nil
+	}
+
+	list := reflect.ValueOf(res)
+	if list.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("@stream can only be used on list fields")
+	}
+	if list.Len() <= initialCount {
+		return res, nil
+	}
+
+	fc := graphql.GetFieldContext(ctx)
+	rest := make([]interface{}, 0, list.Len()-initialCount)
+	for i := initialCount; i < list.Len(); i++ {
+		rest = append(rest, list.Index(i).Interface())
+	}
+	var streamLabel string
+	if label != nil {
+		streamLabel = *label
+	}
+	ec.processStream(ctx, fc.Path(), streamLabel, fc.Field.Definition.Type.Elem.Name(), fc.Field.Selections, initialCount, rest)
+	return list.Slice(0, initialCount).Interface(), nil
+}
+
+// processStream は一覧の残りの要素（GraphQLの型 typeName）を順に解決し、1件ずつ後続の結果として送る
+func (ec *executionContext) processStream(ctx context.Context, path ast.Path, label, typeName string, sel ast.SelectionSet, offset int, items []interface{}) {
+	ec.addStream(len(items))
+	go func() {
+		for i, item := range items {
+			index := offset + i
+			itemCtx := graphql.WithFreshResponseContext(graphql.WithFieldContext(ctx, &graphql.FieldContext{
+				Index:  &index,
+				Result: item,
+			}))
+			result := ec.marshalStreamItem(itemCtx, typeName, sel, item)
+			if !ec.sendDeferred(ctx, graphql.DeferredResult{
+				Path:   append(append(ast.Path{}, path...), ast.PathIndex(index)),
+				Label:  label,
+				Result: graphql.Array{result},
+				Errors: graphql.GetErrors(itemCtx),
+			}) {
+				// 受け取る側が終了した
+				return
+			}
+		}
+	}()
+}
+
+// marshalStreamItem は @stream で送る一覧の要素を選択セットに従って解決する
+func (ec *executionContext) marshalStreamItem(ctx context.Context, typeName string, sel ast.SelectionSet, item interface{}) graphql.Marshaler {
+	if v := reflect.ValueOf(item); !v.IsValid() || (v.Kind() == reflect.Ptr && v.IsNil()) {
+		ec.Errorf(ctx, "must not be null")
+		return graphql.Null
+	}
+	marshal, ok := streamItemMarshalers[typeName]
+	if !ok {
+		ec.Errorf(ctx, "@stream is not supported for %s", typeName)
+		return graphql.Null
+	}
+	return marshal(ec, ctx, sel, item)
+}
@@ -0,0 +1,106 @@
+package graph
+
+// このファイルは gqlgen が生成した generated.go の非公開の実装（executionContext のフィールドと
+// _Query / _Mutation / _<型> のメソッド）に依存する部分だけをまとめたもの。
+//
+// 生成される Exec には後続の結果を返す入口が無いため、クエリとミューテーションの Exec を
+// incrementalSchema.Exec で置き換えており、その中で生成されたコードの状態を直接扱う。
+// gqlgen の更新やスキーマの変更で generated.go を再生成したら go test ./graph を実行する。
+// incremental_adapter_test.go が、生成された Exec・executionContext の変化と
+// streamItemMarshalers に無い一覧の要素の型を検出する。
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/kajidog/graphql-sse-test/apps/backend/graph/model"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// rootMarshaler はクエリ・ミューテーションのルートの選択セットを解決する生成されたメソッド
+type rootMarshaler func(ec *executionContext, ctx context.Context, sel ast.SelectionSet) graphql.Marshaler
+
+// rootMarshalerFor は操作の種類に応じたルートのメソッドを返す（サブスクリプションは対象外）
+func rootMarshalerFor(op ast.Operation) (rootMarshaler, bool) {
+	switch op {
+	case ast.Query:
+		return (*executionContext)._Query, true
+	case ast.Mutation:
+		return (*executionContext)._Mutation, true
+	default:
+		return nil, false
+	}
+}
+
+// newIncrementalExecutionContext は生成された Exec と同じ初期状態の executionContext を作成
+func newIncrementalExecutionContext(rc *graphql.OperationContext, e *executableSchema) *executionContext {
+	return &executionContext{
+		OperationContext: rc,
+		executableSchema: e,
+		deferredResults:  make(chan graphql.DeferredResult),
+	}
+}
+
+// hasDeferred は @defer / @stream の後続の結果が1つでも登録されたかを返す
+func (ec *executionContext) hasDeferred() bool {
+	return atomic.LoadInt32(&ec.deferred) > 0
+}
+
+// hasPendingDeferred は送っていない後続の結果が残っているかを返す
+func (ec *executionContext) hasPendingDeferred() bool {
+	return atomic.LoadInt32(&ec.pendingDeferred) > 0
+}
+
+// nextDeferred は次の後続の結果を待って受け取る
+func (ec *executionContext) nextDeferred() graphql.DeferredResult {
+	result := <-ec.deferredResults
+	atomic.AddInt32(&ec.pendingDeferred, -1)
+	return result
+}
+
+// addStream は @stream の n 件の要素を後続の結果として登録する（送り終えるまで hasNext が true になる）
+func (ec *executionContext) addStream(n int) {
+	atomic.AddInt32(&ec.deferred, 1)
+	atomic.AddInt32(&ec.pendingDeferred, int32(n))
+}
+
+// sendDeferred は後続の結果を送る（受け取る側が終了した場合は false）
+func (ec *executionContext) sendDeferred(ctx context.Context, result graphql.DeferredResult) bool {
+	select {
+	case ec.deferredResults <- result:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// streamItemMarshaler は @stream で送る一覧の要素1件を選択セットに従って解決する
+type streamItemMarshaler func(ec *executionContext, ctx context.Context, sel ast.SelectionSet, item interface{}) graphql.Marshaler
+
+// streamItemMarshalers は一覧の要素の型（GraphQLの型名）ごとの解決方法
+//
+// スキーマでオブジェクトの一覧を返すフィールドの要素の型は全てここに必要（テストで確認する）
+var streamItemMarshalers = map[string]streamItemMarshaler{
+	"Message": func(ec *executionContext, ctx context.Context, sel ast.SelectionSet, item interface{}) graphql.Marshaler {
+		return ec._Message(ctx, sel, item.(*model.Message))
+	},
+	"MessageEdge": func(ec *executionContext, ctx context.Context, sel ast.SelectionSet, item interface{}) graphql.Marshaler {
+		return ec._MessageEdge(ctx, sel, item.(*model.MessageEdge))
+	},
+	"MessageEdit": func(ec *executionContext, ctx context.Context, sel ast.SelectionSet, item interface{}) graphql.Marshaler {
+		return ec._MessageEdit(ctx, sel, item.(*model.MessageEdit))
+	},
+	"ModerationAction": func(ec *executionContext, ctx context.Context, sel ast.SelectionSet, item interface{}) graphql.Marshaler {
+		return ec._ModerationAction(ctx, sel, item.(*model.ModerationAction))
+	},
+	"Room": func(ec *executionContext, ctx context.Context, sel ast.SelectionSet, item interface{}) graphql.Marshaler {
+		return ec._Room(ctx, sel, item.(*model.Room))
+	},
+	"SubscriberStats": func(ec *executionContext, ctx context.Context, sel ast.SelectionSet, item interface{}) graphql.Marshaler {
+		return ec._SubscriberStats(ctx, sel, item.(*model.SubscriberStats))
+	},
+	"User": func(ec *executionContext, ctx context.Context, sel ast.SelectionSet, item interface{}) graphql.Marshaler {
+		return ec._User(ctx, sel, item.(*model.User))
+	},
+}
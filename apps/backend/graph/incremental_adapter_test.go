@@ -0,0 +1,97 @@
+package graph
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"sort"
+	"strings"
+	"testing"
+
+	gqlast "github.com/vektah/gqlparser/v2/ast"
+)
+
+// generatedExecFingerprint は incremental_adapter.go が前提にしている generated.go の
+// Exec と executionContext の宣言のハッシュ
+//
+// 再生成で変わった場合は、生成された Exec のクエリ・ミューテーションの処理と incrementalSchema.Exec、
+// executionContext のフィールドの使い方を見比べてから更新する
+const generatedExecFingerprint = "e902950cc665679bd8e00897f2cee4581a2d238e6a3006ab2930a75db0356e04"
+
+func TestGeneratedExecFingerprint(t *testing.T) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "generated.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Name.Name != "Exec" || decl.Recv == nil {
+				continue
+			}
+		case *ast.GenDecl:
+			if decl.Tok != token.TYPE || len(decl.Specs) != 1 || decl.Specs[0].(*ast.TypeSpec).Name.Name != "executionContext" {
+				continue
+			}
+		default:
+			continue
+		}
+		if err := printer.Fprint(&buf, fset, decl); err != nil {
+			t.Fatal(err)
+		}
+		buf.WriteString("\n")
+	}
+	if buf.Len() == 0 {
+		t.Fatal("Exec and executionContext were not found in generated.go")
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	if got := hex.EncodeToString(sum[:]); got != generatedExecFingerprint {
+		t.Errorf("generated Exec or executionContext changed (fingerprint %s).\n"+
+			"Review incremental_adapter.go and incrementalSchema.Exec against the regenerated code, then update generatedExecFingerprint.\n%s",
+			got, buf.String())
+	}
+}
+
+func TestStreamItemMarshalersCoverSchema(t *testing.T) {
+	schema := parsedSchema
+	names := make([]string, 0, len(schema.Types))
+	for name := range schema.Types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// オブジェクトの一覧を返すフィールドは全て @stream できる
+	needed := map[string]bool{}
+	for _, name := range names {
+		def := schema.Types[name]
+		if def.Kind != gqlast.Object || strings.HasPrefix(name, "__") {
+			continue
+		}
+		for _, field := range def.Fields {
+			if field.Type.Elem == nil {
+				continue
+			}
+			elem := schema.Types[field.Type.Elem.Name()]
+			if elem == nil || elem.Kind != gqlast.Object {
+				continue
+			}
+			needed[elem.Name] = true
+			if _, ok := streamItemMarshalers[elem.Name]; !ok {
+				t.Errorf("%s.%s: streamItemMarshalers has no entry for %s", name, field.Name, elem.Name)
+			}
+		}
+	}
+	for name := range streamItemMarshalers {
+		if !needed[name] {
+			t.Errorf("streamItemMarshalers has an entry for %s, which is not a list element type", name)
+		}
+	}
+}
@@ -1 +1,163 @@
 package model
+
+import "time"
+
+// DefaultRoomID は全ユーザーがログイン時に参加する既定のルーム
+//
+// ルーム導入前のメッセージもこのルームに属するものとして扱う
+const DefaultRoomID = "general"
+
+// Message はチャットメッセージ
+//
+// user はリゾルバーで解決する（@defer で遅延できるように UserID のみ保持）。
+// Seq はストア内で単調増加する連番（全ルーム共通）で、SSEのイベントID（Last-Event-IDによる再開）に使う。
+// 削除されたメッセージは DeletedAt を設定し、本文を空にした墓標として残す
+type Message struct {
+	ID        string  `json:"id"`
+	RoomID    string  `json:"roomId"`
+	UserID    string  `json:"userId"`
+	Content   string  `json:"content"`
+	CreatedAt string  `json:"createdAt"`
+	EditedAt  *string `json:"editedAt,omitempty"`
+	DeletedAt *string `json:"deletedAt,omitempty"`
+	Seq       int64   `json:"-"`
+}
+
+// Deleted はメッセージが削除済みかどうか
+func (m *Message) Deleted() bool {
+	return m.DeletedAt != nil
+}
+
+// Room はチャットルーム
+//
+// Kind が RoomKindDirect のルームはダイレクトメッセージの会話で、参加者以外からは見えない。
+// CreatedBy は作成したユーザーのID（既定のルームでは空）。
+// ArchivedAt が設定されたルームは読み取り専用になる
+type Room struct {
+	ID         string   `json:"id"`
+	Kind       RoomKind `json:"kind"`
+	Name       string   `json:"name"`
+	CreatedBy  string   `json:"createdBy,omitempty"`
+	CreatedAt  string   `json:"createdAt"`
+	ArchivedAt *string  `json:"archivedAt,omitempty"`
+}
+
+// Archived はルームがアーカイブ済みかどうか
+func (r *Room) Archived() bool {
+	return r.ArchivedAt != nil
+}
+
+// IsDirect はダイレクトメッセージの会話かどうか
+func (r *Room) IsDirect() bool {
+	return r.Kind == RoomKindDirect
+}
+
+// Session はログインごとのセッション
+//
+// RefreshTokenHash は現在有効なリフレッシュトークンのハッシュで、リフレッシュのたびに置き換わる。
+// ExpiresAt はリフレッシュトークンの有効期限、RevokedAt はログアウトなどで失効した日時
+type Session struct {
+	ID               string  `json:"id"`
+	UserID           string  `json:"userId"`
+	RefreshTokenHash string  `json:"refreshTokenHash"`
+	CreatedAt        string  `json:"createdAt"`
+	ExpiresAt        string  `json:"expiresAt"`
+	RevokedAt        *string `json:"revokedAt,omitempty"`
+}
+
+// Revoked はセッションが失効済みかどうか
+func (s *Session) Revoked() bool {
+	return s.RevokedAt != nil
+}
+
+// User はユーザー
+//
+// Username はログインに使う一意の名前（変更できない）、Nickname は表示名（変更できる）
+type User struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Nickname string `json:"nickname"`
+	Role     Role   `json:"role"`
+}
+
+// HasRole はユーザーが required 以上のロールを持つかどうか
+func (u *User) HasRole(required Role) bool {
+	return u.Role.Includes(required)
+}
+
+// roleRanks はロールの順位（大きいほど上位）
+var roleRanks = map[Role]int{
+	RoleGuest:     1,
+	RoleMember:    2,
+	RoleModerator: 3,
+	RoleAdmin:     4,
+}
+
+// Includes はロールが required の権限を全て持つ（同じか上位のロール）かどうか
+func (r Role) Includes(required Role) bool {
+	rank, ok := roleRanks[r]
+	return ok && rank >= roleRanks[required]
+}
+
+// Outranks はロールが other より上位かどうか
+func (r Role) Outranks(other Role) bool {
+	rank, ok := roleRanks[r]
+	return ok && rank > roleRanks[other]
+}
+
+// Credential はユーザーのパスワード認証の情報
+//
+// PasswordHash はbcryptのハッシュ。FailedAttempts は連続したログインの失敗回数で、
+// 上限に達すると LockedUntil までログインできない
+type Credential struct {
+	UserID         string  `json:"userId"`
+	PasswordHash   string  `json:"passwordHash"`
+	FailedAttempts int     `json:"failedAttempts"`
+	LockedUntil    *string `json:"lockedUntil,omitempty"`
+	UpdatedAt      string  `json:"updatedAt"`
+}
+
+// SanctionKind はユーザーへの制裁の種類
+type SanctionKind string
+
+const (
+	// SanctionBan はログイン・閲覧を含む全ての操作を禁止する
+	SanctionBan SanctionKind = "BAN"
+	// SanctionMute はメッセージの送信・編集を禁止する
+	SanctionMute SanctionKind = "MUTE"
+)
+
+// Sanction はユーザーに科されている制裁（種類ごとに最大1つ）
+//
+// ExpiresAt が nil の場合は解除されるまで続く
+type Sanction struct {
+	UserID    string       `json:"userId"`
+	Kind      SanctionKind `json:"kind"`
+	Reason    string       `json:"reason,omitempty"`
+	CreatedBy string       `json:"createdBy"`
+	CreatedAt string       `json:"createdAt"`
+	ExpiresAt *string      `json:"expiresAt,omitempty"`
+}
+
+// ActiveAt は制裁が now の時点で有効かどうか
+func (s *Sanction) ActiveAt(now time.Time) bool {
+	if s.ExpiresAt == nil {
+		return true
+	}
+	expiresAt, err := time.Parse(time.RFC3339, *s.ExpiresAt)
+	return err != nil || now.Before(expiresAt)
+}
+
+// ModerationAction はモデレーション操作の監査ログの1件
+//
+// moderator / target / message はリゾルバーで解決する（IDのみ保持）
+type ModerationAction struct {
+	ID           string               `json:"id"`
+	Action       ModerationActionKind `json:"action"`
+	ModeratorID  string               `json:"moderatorId"`
+	TargetUserID string               `json:"targetUserId"`
+	MessageID    *string              `json:"messageId,omitempty"`
+	Reason       *string              `json:"reason,omitempty"`
+	ExpiresAt    *string              `json:"expiresAt,omitempty"`
+	CreatedAt    string               `json:"createdAt"`
+}
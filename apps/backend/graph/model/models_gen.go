@@ -2,23 +2,268 @@
 
 package model
 
-type Message struct {
-	ID        string `json:"id"`
-	User      *User  `json:"user"`
-	Content   string `json:"content"`
-	CreatedAt string `json:"createdAt"`
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+type ChatEvent interface {
+	IsChatEvent()
+}
+
+type AuthPayload struct {
+	Token        string `json:"token"`
+	ExpiresAt    string `json:"expiresAt"`
+	RefreshToken string `json:"refreshToken"`
+	User         *User  `json:"user"`
+}
+
+type MessageAdded struct {
+	Message *Message `json:"message"`
+}
+
+func (MessageAdded) IsChatEvent() {}
+
+type MessageConnection struct {
+	Edges      []*MessageEdge `json:"edges"`
+	PageInfo   *PageInfo      `json:"pageInfo"`
+	TotalCount int            `json:"totalCount"`
+}
+
+type MessageDeleted struct {
+	Message *Message `json:"message"`
 }
 
+func (MessageDeleted) IsChatEvent() {}
+
+type MessageEdge struct {
+	Cursor string   `json:"cursor"`
+	Node   *Message `json:"node"`
+}
+
+type MessageEdit struct {
+	Content  string `json:"content"`
+	EditedAt string `json:"editedAt"`
+}
+
+type MessageEdited struct {
+	Message *Message `json:"message"`
+}
+
+func (MessageEdited) IsChatEvent() {}
+
 type Mutation struct {
 }
 
+type PageInfo struct {
+	HasNextPage     bool    `json:"hasNextPage"`
+	HasPreviousPage bool    `json:"hasPreviousPage"`
+	StartCursor     *string `json:"startCursor,omitempty"`
+	EndCursor       *string `json:"endCursor,omitempty"`
+}
+
 type Query struct {
 }
 
+type SubscriberStats struct {
+	Topic   string         `json:"topic"`
+	Policy  OverflowPolicy `json:"policy"`
+	Pending int            `json:"pending"`
+	Dropped int            `json:"dropped"`
+}
+
 type Subscription struct {
 }
 
-type User struct {
-	ID       string `json:"id"`
-	Nickname string `json:"nickname"`
+type UserJoined struct {
+	Room *Room `json:"room"`
+	User *User `json:"user"`
+}
+
+func (UserJoined) IsChatEvent() {}
+
+type UserLeft struct {
+	Room *Room `json:"room"`
+	User *User `json:"user"`
+}
+
+func (UserLeft) IsChatEvent() {}
+
+type ModerationActionKind string
+
+const (
+	ModerationActionKindBan      ModerationActionKind = "BAN"
+	ModerationActionKindUnban    ModerationActionKind = "UNBAN"
+	ModerationActionKindMute     ModerationActionKind = "MUTE"
+	ModerationActionKindUnmute   ModerationActionKind = "UNMUTE"
+	ModerationActionKindTakedown ModerationActionKind = "TAKEDOWN"
+)
+
+var AllModerationActionKind = []ModerationActionKind{
+	ModerationActionKindBan,
+	ModerationActionKindUnban,
+	ModerationActionKindMute,
+	ModerationActionKindUnmute,
+	ModerationActionKindTakedown,
+}
+
+func (e ModerationActionKind) IsValid() bool {
+	switch e {
+	case ModerationActionKindBan, ModerationActionKindUnban, ModerationActionKindMute, ModerationActionKindUnmute, ModerationActionKindTakedown:
+		return true
+	}
+	return false
+}
+
+func (e ModerationActionKind) String() string {
+	return string(e)
+}
+
+func (e *ModerationActionKind) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = ModerationActionKind(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid ModerationActionKind", str)
+	}
+	return nil
+}
+
+func (e ModerationActionKind) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type OverflowPolicy string
+
+const (
+	OverflowPolicyDropOldest OverflowPolicy = "DROP_OLDEST"
+	OverflowPolicyDropNewest OverflowPolicy = "DROP_NEWEST"
+	OverflowPolicyBlock      OverflowPolicy = "BLOCK"
+	OverflowPolicyDisconnect OverflowPolicy = "DISCONNECT"
+)
+
+var AllOverflowPolicy = []OverflowPolicy{
+	OverflowPolicyDropOldest,
+	OverflowPolicyDropNewest,
+	OverflowPolicyBlock,
+	OverflowPolicyDisconnect,
+}
+
+func (e OverflowPolicy) IsValid() bool {
+	switch e {
+	case OverflowPolicyDropOldest, OverflowPolicyDropNewest, OverflowPolicyBlock, OverflowPolicyDisconnect:
+		return true
+	}
+	return false
+}
+
+func (e OverflowPolicy) String() string {
+	return string(e)
+}
+
+func (e *OverflowPolicy) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = OverflowPolicy(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid OverflowPolicy", str)
+	}
+	return nil
+}
+
+func (e OverflowPolicy) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type Role string
+
+const (
+	RoleAdmin     Role = "ADMIN"
+	RoleModerator Role = "MODERATOR"
+	RoleMember    Role = "MEMBER"
+	RoleGuest     Role = "GUEST"
+)
+
+var AllRole = []Role{
+	RoleAdmin,
+	RoleModerator,
+	RoleMember,
+	RoleGuest,
+}
+
+func (e Role) IsValid() bool {
+	switch e {
+	case RoleAdmin, RoleModerator, RoleMember, RoleGuest:
+		return true
+	}
+	return false
+}
+
+func (e Role) String() string {
+	return string(e)
+}
+
+func (e *Role) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = Role(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid Role", str)
+	}
+	return nil
+}
+
+func (e Role) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type RoomKind string
+
+const (
+	RoomKindChannel RoomKind = "CHANNEL"
+	RoomKindDirect  RoomKind = "DIRECT"
+)
+
+var AllRoomKind = []RoomKind{
+	RoomKindChannel,
+	RoomKindDirect,
+}
+
+func (e RoomKind) IsValid() bool {
+	switch e {
+	case RoomKindChannel, RoomKindDirect:
+		return true
+	}
+	return false
+}
+
+func (e RoomKind) String() string {
+	return string(e)
+}
+
+func (e *RoomKind) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = RoomKind(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid RoomKind", str)
+	}
+	return nil
+}
+
+func (e RoomKind) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
 }
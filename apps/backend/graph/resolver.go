@@ -1,6 +1,13 @@
 package graph
 
 import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kajidog/graphql-sse-test/apps/backend/graph/model"
+	"github.com/kajidog/graphql-sse-test/apps/backend/middleware"
+	"github.com/kajidog/graphql-sse-test/apps/backend/pubsub"
 	"github.com/kajidog/graphql-sse-test/apps/backend/service"
 )
 
@@ -9,13 +16,67 @@ import (
 // It serves as dependency injection for your app, add any dependencies you require here.
 
 type Resolver struct {
-	UserService    service.UserService
-	MessageService service.MessageService
+	AuthService       service.AuthService
+	UserService       service.UserService
+	RoomService       service.RoomService
+	MessageService    service.MessageService
+	ModerationService service.ModerationService
+	PubSubStats       pubsub.StatsProvider
 }
 
-func NewResolver(us service.UserService, ms service.MessageService) *Resolver {
+func NewResolver(as service.AuthService, us service.UserService, rs service.RoomService, ms service.MessageService, mods service.ModerationService, stats pubsub.StatsProvider) *Resolver {
 	return &Resolver{
-		UserService:    us,
-		MessageService: ms,
+		AuthService:       as,
+		UserService:       us,
+		RoomService:       rs,
+		MessageService:    ms,
+		ModerationService: mods,
+		PubSubStats:       stats,
+	}
+}
+
+// currentUserID はログイン中のユーザーIDを取得（未ログインの場合はエラー）
+func currentUserID(ctx context.Context) (string, error) {
+	userID, ok := middleware.UserIDFromContext(ctx)
+	if !ok {
+		return "", fmt.Errorf("unauthorized: user not logged in")
+	}
+	return userID, nil
+}
+
+// sanctionDuration は制裁の期間の秒数を time.Duration にする（省略した場合は0で、解除するまで続く）
+func sanctionDuration(seconds *int) (time.Duration, error) {
+	if seconds == nil {
+		return 0, nil
+	}
+	if *seconds <= 0 {
+		return 0, fmt.Errorf("durationSeconds must be positive")
+	}
+	return time.Duration(*seconds) * time.Second, nil
+}
+
+// overflowPolicies は GraphQL の OverflowPolicy と pubsub.OverflowPolicy の対応
+var overflowPolicies = map[model.OverflowPolicy]pubsub.OverflowPolicy{
+	model.OverflowPolicyDropOldest: pubsub.DropOldest,
+	model.OverflowPolicyDropNewest: pubsub.DropNewest,
+	model.OverflowPolicyBlock:      pubsub.Block,
+	model.OverflowPolicyDisconnect: pubsub.Disconnect,
+}
+
+// subscribeOptions は購読ごとに指定された設定（省略した場合はサーバーの設定に従う）
+func subscribeOptions(overflow *model.OverflowPolicy) []pubsub.SubscribeOption {
+	if overflow == nil {
+		return nil
+	}
+	return []pubsub.SubscribeOption{pubsub.WithOverflowPolicy(overflowPolicies[*overflow])}
+}
+
+// overflowPolicyModel は pubsub.OverflowPolicy を GraphQL の OverflowPolicy にする
+func overflowPolicyModel(policy pubsub.OverflowPolicy) model.OverflowPolicy {
+	for m, p := range overflowPolicies {
+		if p == policy {
+			return m
+		}
 	}
+	return model.OverflowPolicyDropNewest
 }
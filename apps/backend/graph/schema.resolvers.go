@@ -7,29 +7,304 @@ package graph
 import (
 	"context"
 	"fmt"
+	"sort"
 
-	"github.com/google/uuid"
 	"github.com/kajidog/graphql-sse-test/apps/backend/graph/model"
 	"github.com/kajidog/graphql-sse-test/apps/backend/middleware"
+	"github.com/kajidog/graphql-sse-test/apps/backend/service"
 )
 
+// Room is the resolver for the room field.
+func (r *messageResolver) Room(ctx context.Context, obj *model.Message) (*model.Room, error) {
+	room, ok := r.RoomService.GetRoom(obj.RoomID)
+	if !ok {
+		return nil, service.ErrRoomNotFound
+	}
+	return room, nil
+}
+
+// User is the resolver for the user field.
+func (r *messageResolver) User(ctx context.Context, obj *model.Message) (*model.User, error) {
+	user, ok := r.UserService.GetUser(obj.UserID)
+	if !ok {
+		return nil, fmt.Errorf("user not found")
+	}
+	return user, nil
+}
+
+// History is the resolver for the history field.
+func (r *messageResolver) History(ctx context.Context, obj *model.Message) ([]*model.MessageEdit, error) {
+	if obj.Deleted() {
+		return []*model.MessageEdit{}, nil
+	}
+	return r.MessageService.ListEdits(obj.ID), nil
+}
+
+// Moderator is the resolver for the moderator field.
+func (r *moderationActionResolver) Moderator(ctx context.Context, obj *model.ModerationAction) (*model.User, error) {
+	user, ok := r.UserService.GetUser(obj.ModeratorID)
+	if !ok {
+		return nil, fmt.Errorf("user not found")
+	}
+	return user, nil
+}
+
+// Target is the resolver for the target field.
+func (r *moderationActionResolver) Target(ctx context.Context, obj *model.ModerationAction) (*model.User, error) {
+	user, ok := r.UserService.GetUser(obj.TargetUserID)
+	if !ok {
+		return nil, fmt.Errorf("user not found")
+	}
+	return user, nil
+}
+
+// Message is the resolver for the message field.
+func (r *moderationActionResolver) Message(ctx context.Context, obj *model.ModerationAction) (*model.Message, error) {
+	if obj.MessageID == nil {
+		return nil, nil
+	}
+	msg, ok := r.MessageService.GetMessage(*obj.MessageID)
+	if !ok {
+		return nil, nil
+	}
+	return msg, nil
+}
+
+// Register is the resolver for the register field.
+func (r *mutationResolver) Register(ctx context.Context, username string, password string, nickname *string) (*model.AuthPayload, error) {
+	name := ""
+	if nickname != nil {
+		name = *nickname
+	}
+	return r.AuthService.Register(username, password, name)
+}
+
 // Login is the resolver for the login field.
-func (r *mutationResolver) Login(ctx context.Context, nickname string) (*model.User, error) {
-	return r.UserService.Login(nickname)
+func (r *mutationResolver) Login(ctx context.Context, username string, password string) (*model.AuthPayload, error) {
+	return r.AuthService.Login(username, password)
+}
+
+// RefreshToken is the resolver for the refreshToken field.
+func (r *mutationResolver) RefreshToken(ctx context.Context, refreshToken string) (*model.AuthPayload, error) {
+	return r.AuthService.Refresh(refreshToken)
+}
+
+// Logout is the resolver for the logout field.
+func (r *mutationResolver) Logout(ctx context.Context) (bool, error) {
+	userID, err := currentUserID(ctx)
+	if err != nil {
+		return false, err
+	}
+	if err := r.AuthService.Logout(userID, middleware.SessionIDFromContext(ctx)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// LogoutAllSessions is the resolver for the logoutAllSessions field.
+func (r *mutationResolver) LogoutAllSessions(ctx context.Context) (bool, error) {
+	userID, err := currentUserID(ctx)
+	if err != nil {
+		return false, err
+	}
+	if err := r.AuthService.LogoutAll(userID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// UpdateNickname is the resolver for the updateNickname field.
+func (r *mutationResolver) UpdateNickname(ctx context.Context, nickname string) (*model.User, error) {
+	userID, err := currentUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.UserService.UpdateNickname(userID, nickname)
+}
+
+// SetPassword is the resolver for the setPassword field.
+func (r *mutationResolver) SetPassword(ctx context.Context, password string) (*model.User, error) {
+	userID, err := currentUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.UserService.SetPassword(userID, password)
+}
+
+// SetUserRole is the resolver for the setUserRole field.
+func (r *mutationResolver) SetUserRole(ctx context.Context, userID string, role model.Role) (*model.User, error) {
+	actorID, err := currentUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.UserService.SetRole(actorID, userID, role)
+}
+
+// BanUser is the resolver for the banUser field.
+func (r *mutationResolver) BanUser(ctx context.Context, userID string, reason *string, durationSeconds *int) (*model.ModerationAction, error) {
+	actorID, err := currentUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	duration, err := sanctionDuration(durationSeconds)
+	if err != nil {
+		return nil, err
+	}
+	return r.ModerationService.Ban(actorID, userID, reason, duration)
+}
+
+// UnbanUser is the resolver for the unbanUser field.
+func (r *mutationResolver) UnbanUser(ctx context.Context, userID string, reason *string) (*model.ModerationAction, error) {
+	actorID, err := currentUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.ModerationService.Unban(actorID, userID, reason)
+}
+
+// MuteUser is the resolver for the muteUser field.
+func (r *mutationResolver) MuteUser(ctx context.Context, userID string, reason *string, durationSeconds *int) (*model.ModerationAction, error) {
+	actorID, err := currentUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	duration, err := sanctionDuration(durationSeconds)
+	if err != nil {
+		return nil, err
+	}
+	return r.ModerationService.Mute(actorID, userID, reason, duration)
+}
+
+// UnmuteUser is the resolver for the unmuteUser field.
+func (r *mutationResolver) UnmuteUser(ctx context.Context, userID string, reason *string) (*model.ModerationAction, error) {
+	actorID, err := currentUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.ModerationService.Unmute(actorID, userID, reason)
+}
+
+// TakedownMessage is the resolver for the takedownMessage field.
+func (r *mutationResolver) TakedownMessage(ctx context.Context, id string, reason *string) (*model.ModerationAction, error) {
+	actorID, err := currentUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.ModerationService.TakedownMessage(actorID, id, reason)
+}
+
+// CreateRoom is the resolver for the createRoom field.
+func (r *mutationResolver) CreateRoom(ctx context.Context, name string) (*model.Room, error) {
+	userID, err := currentUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.RoomService.CreateRoom(userID, name)
+}
+
+// JoinRoom is the resolver for the joinRoom field.
+func (r *mutationResolver) JoinRoom(ctx context.Context, roomID string) (*model.Room, error) {
+	userID, err := currentUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.RoomService.JoinRoom(userID, roomID)
+}
+
+// LeaveRoom is the resolver for the leaveRoom field.
+func (r *mutationResolver) LeaveRoom(ctx context.Context, roomID string) (bool, error) {
+	userID, err := currentUserID(ctx)
+	if err != nil {
+		return false, err
+	}
+	if err := r.RoomService.LeaveRoom(userID, roomID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ArchiveRoom is the resolver for the archiveRoom field.
+func (r *mutationResolver) ArchiveRoom(ctx context.Context, roomID string) (*model.Room, error) {
+	userID, err := currentUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.RoomService.ArchiveRoom(userID, roomID)
+}
+
+// CreateDirectRoom is the resolver for the createDirectRoom field.
+func (r *mutationResolver) CreateDirectRoom(ctx context.Context, userIds []string) (*model.Room, error) {
+	userID, err := currentUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.RoomService.CreateDirectRoom(userID, userIds)
 }
 
 // SendMessage is the resolver for the sendMessage field.
-func (r *mutationResolver) SendMessage(ctx context.Context, content string) (*model.Message, error) {
-	userID, ok := middleware.UserIDFromContext(ctx)
-	if !ok {
-		return nil, fmt.Errorf("unauthorized: user not logged in")
+func (r *mutationResolver) SendMessage(ctx context.Context, roomID string, content string) (*model.Message, error) {
+	userID, err := currentUserID(ctx)
+	if err != nil {
+		return nil, err
 	}
-	return r.MessageService.SendMessage(userID, content)
+	return r.MessageService.SendMessage(userID, roomID, content)
+}
+
+// EditMessage is the resolver for the editMessage field.
+func (r *mutationResolver) EditMessage(ctx context.Context, id string, content string) (*model.Message, error) {
+	userID, err := currentUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.MessageService.EditMessage(userID, id, content)
+}
+
+// DeleteMessage is the resolver for the deleteMessage field.
+func (r *mutationResolver) DeleteMessage(ctx context.Context, id string) (*model.Message, error) {
+	userID, err := currentUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.MessageService.DeleteMessage(userID, id)
+}
+
+// Rooms is the resolver for the rooms field.
+func (r *queryResolver) Rooms(ctx context.Context, includeArchived *bool) ([]*model.Room, error) {
+	return r.RoomService.ListRooms(includeArchived != nil && *includeArchived), nil
+}
+
+// Room is the resolver for the room field.
+func (r *queryResolver) Room(ctx context.Context, id string) (*model.Room, error) {
+	userID, _ := middleware.UserIDFromContext(ctx)
+	room, _ := r.RoomService.FindRoom(userID, id)
+	return room, nil
+}
+
+// DirectRooms is the resolver for the directRooms field.
+func (r *queryResolver) DirectRooms(ctx context.Context) ([]*model.Room, error) {
+	userID, err := currentUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.RoomService.ListDirectRooms(userID), nil
 }
 
 // Messages is the resolver for the messages field.
-func (r *queryResolver) Messages(ctx context.Context) ([]*model.Message, error) {
-	return r.MessageService.GetMessages(), nil
+func (r *queryResolver) Messages(ctx context.Context, roomID string) ([]*model.Message, error) {
+	userID, err := currentUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.MessageService.GetMessages(userID, roomID)
+}
+
+// MessagesConnection is the resolver for the messagesConnection field.
+func (r *queryResolver) MessagesConnection(ctx context.Context, roomID string, first *int, after *string, last *int, before *string) (*model.MessageConnection, error) {
+	userID, err := currentUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.MessageService.ListMessages(userID, roomID, service.PageArgs{First: first, After: after, Last: last, Before: before})
 }
 
 // Me is the resolver for the me field.
@@ -42,28 +317,156 @@ func (r *queryResolver) Me(ctx context.Context) (*model.User, error) {
 	return user, nil
 }
 
+// ModerationLog is the resolver for the moderationLog field.
+func (r *queryResolver) ModerationLog(ctx context.Context, userID *string, limit *int) ([]*model.ModerationAction, error) {
+	target := ""
+	if userID != nil {
+		target = *userID
+	}
+	n := 50
+	if limit != nil {
+		n = *limit
+	}
+	return r.ModerationService.ListActions(target, n)
+}
+
+// SubscriberStats is the resolver for the subscriberStats field.
+func (r *queryResolver) SubscriberStats(ctx context.Context) ([]*model.SubscriberStats, error) {
+	stats := r.PubSubStats.Stats()
+	out := make([]*model.SubscriberStats, 0, len(stats))
+	for _, s := range stats {
+		out = append(out, &model.SubscriberStats{
+			Topic:   s.Topic,
+			Policy:  overflowPolicyModel(s.Policy),
+			Pending: s.Pending,
+			Dropped: int(s.Dropped),
+		})
+	}
+	// 捨てられたイベントの多い順
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Dropped > out[j].Dropped })
+	return out, nil
+}
+
+// CreatedBy is the resolver for the createdBy field.
+func (r *roomResolver) CreatedBy(ctx context.Context, obj *model.Room) (*model.User, error) {
+	if obj.CreatedBy == "" {
+		return nil, nil
+	}
+	user, _ := r.UserService.GetUser(obj.CreatedBy)
+	return user, nil
+}
+
+// Members is the resolver for the members field.
+func (r *roomResolver) Members(ctx context.Context, obj *model.Room) ([]*model.User, error) {
+	userIDs := r.RoomService.Members(obj.ID)
+	users := make([]*model.User, 0, len(userIDs))
+	for _, id := range userIDs {
+		if user, ok := r.UserService.GetUser(id); ok {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
 // MessageAdded is the resolver for the messageAdded field.
-func (r *subscriptionResolver) MessageAdded(ctx context.Context) (<-chan *model.Message, error) {
-	id := uuid.New().String()
-	ch := r.MessageService.Subscribe(id)
+func (r *subscriptionResolver) MessageAdded(ctx context.Context, roomID string, overflow *model.OverflowPolicy) (<-chan *model.Message, error) {
+	// 再接続時は Last-Event-ID（メッセージの連番）以降を再送する
+	lastSeq, err := lastSeqFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	userID, err := currentUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := r.MessageService.Subscribe(ctx, userID, roomID, lastSeq, subscribeOptions(overflow)...)
+	if err != nil {
+		return nil, err
+	}
+	return forwardMessages(ctx, sub, true), nil
+}
 
-	go func() {
-		<-ctx.Done()
-		r.MessageService.Unsubscribe(id)
-	}()
+// DirectMessageReceived is the resolver for the directMessageReceived field.
+func (r *subscriptionResolver) DirectMessageReceived(ctx context.Context, overflow *model.OverflowPolicy) (<-chan *model.Message, error) {
+	lastSeq, err := lastSeqFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	userID, err := currentUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := r.MessageService.SubscribeDirect(ctx, userID, lastSeq, subscribeOptions(overflow)...)
+	if err != nil {
+		return nil, err
+	}
+	return forwardMessages(ctx, sub, true), nil
+}
 
-	return ch, nil
+// MessageUpdated is the resolver for the messageUpdated field.
+func (r *subscriptionResolver) MessageUpdated(ctx context.Context, roomID string, overflow *model.OverflowPolicy) (<-chan *model.Message, error) {
+	userID, err := currentUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := r.MessageService.SubscribeUpdated(ctx, userID, roomID, subscribeOptions(overflow)...)
+	if err != nil {
+		return nil, err
+	}
+	return forwardMessages(ctx, sub, false), nil
+}
+
+// MessageDeleted is the resolver for the messageDeleted field.
+func (r *subscriptionResolver) MessageDeleted(ctx context.Context, roomID string, overflow *model.OverflowPolicy) (<-chan *model.Message, error) {
+	userID, err := currentUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := r.MessageService.SubscribeDeleted(ctx, userID, roomID, subscribeOptions(overflow)...)
+	if err != nil {
+		return nil, err
+	}
+	return forwardMessages(ctx, sub, false), nil
 }
 
+// ChatEvents is the resolver for the chatEvents field.
+func (r *subscriptionResolver) ChatEvents(ctx context.Context, roomID string, overflow *model.OverflowPolicy) (<-chan model.ChatEvent, error) {
+	lastSeq, err := lastSeqFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	userID, err := currentUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := r.MessageService.SubscribeEvents(ctx, userID, roomID, lastSeq, subscribeOptions(overflow)...)
+	if err != nil {
+		return nil, err
+	}
+	return forwardChatEvents(ctx, sub), nil
+}
+
+// Message returns MessageResolver implementation.
+func (r *Resolver) Message() MessageResolver { return &messageResolver{r} }
+
+// ModerationAction returns ModerationActionResolver implementation.
+func (r *Resolver) ModerationAction() ModerationActionResolver { return &moderationActionResolver{r} }
+
 // Mutation returns MutationResolver implementation.
 func (r *Resolver) Mutation() MutationResolver { return &mutationResolver{r} }
 
 // Query returns QueryResolver implementation.
 func (r *Resolver) Query() QueryResolver { return &queryResolver{r} }
 
+// Room returns RoomResolver implementation.
+func (r *Resolver) Room() RoomResolver { return &roomResolver{r} }
+
 // Subscription returns SubscriptionResolver implementation.
 func (r *Resolver) Subscription() SubscriptionResolver { return &subscriptionResolver{r} }
 
+type messageResolver struct{ *Resolver }
+type moderationActionResolver struct{ *Resolver }
 type mutationResolver struct{ *Resolver }
 type queryResolver struct{ *Resolver }
+type roomResolver struct{ *Resolver }
 type subscriptionResolver struct{ *Resolver }
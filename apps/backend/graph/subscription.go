@@ -0,0 +1,95 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/kajidog/graphql-sse-test/apps/backend/graph/model"
+	"github.com/kajidog/graphql-sse-test/apps/backend/server"
+	"github.com/kajidog/graphql-sse-test/apps/backend/service"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// lastSeqFromContext は再接続時の Last-Event-ID（メッセージの連番）を取得（初回接続では0）
+func lastSeqFromContext(ctx context.Context) (int64, error) {
+	lastEventID, ok := server.LastEventIDFromContext(ctx)
+	if !ok {
+		return 0, nil
+	}
+	seq, err := strconv.ParseInt(lastEventID, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Last-Event-ID: %s", lastEventID)
+	}
+	return seq, nil
+}
+
+// forwardMessages は購読したメッセージを転送
+//
+// withEventID がtrueの場合は連番をSSEのイベントIDにする（Last-Event-IDで再開できる購読のみ）
+func forwardMessages(ctx context.Context, sub *service.MessageSubscription, withEventID bool) <-chan *model.Message {
+	ch := make(chan *model.Message)
+
+	go func() {
+		defer close(ch)
+		defer func() { notifySubscriptionError(ctx, sub.Err()) }()
+		for msg := range sub.Messages {
+			if withEventID {
+				// SSEのイベントIDとして連番を付与
+				server.SetNextEventID(ctx, strconv.FormatInt(msg.Seq, 10))
+			}
+			select {
+			case ch <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch
+}
+
+// forwardChatEvents は購読したルームのイベントを転送
+//
+// MessageAdded のみ連番をSSEのイベントIDにする（再接続時は追加されたメッセージだけが再送される）。
+// イベントIDは送る値ごとに1つ積む必要があるため、それ以外のイベントには空のIDを積む
+func forwardChatEvents(ctx context.Context, sub *service.EventSubscription) <-chan model.ChatEvent {
+	ch := make(chan model.ChatEvent)
+
+	go func() {
+		defer close(ch)
+		defer func() { notifySubscriptionError(ctx, sub.Err()) }()
+		for event := range sub.Events {
+			var id string
+			if added, ok := event.(*model.MessageAdded); ok {
+				id = strconv.FormatInt(added.Message.Seq, 10)
+			}
+			server.SetNextEventID(ctx, id)
+			select {
+			case ch <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch
+}
+
+// notifySubscriptionError は購読が打ち切られた理由をクライアントにエラーとして通知（errがnilなら何もしない）
+//
+// 配信が追いつかない場合は SLOW_CONSUMER、ルームから抜けた場合は FORBIDDEN
+func notifySubscriptionError(ctx context.Context, err error) {
+	if err == nil {
+		return
+	}
+	code := "SLOW_CONSUMER"
+	if errors.Is(err, service.ErrNotRoomMember) {
+		code = "FORBIDDEN"
+	}
+	server.SetSubscriptionError(ctx, &gqlerror.Error{
+		Message:    err.Error(),
+		Extensions: map[string]interface{}{"code": code},
+	})
+}
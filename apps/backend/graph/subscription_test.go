@@ -0,0 +1,152 @@
+package graph_test
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kajidog/graphql-sse-test/apps/backend/graph/model"
+	"github.com/kajidog/graphql-sse-test/apps/backend/server/servertest"
+	"github.com/kajidog/graphql-sse-test/apps/backend/store"
+)
+
+const chatEventsQuery = `subscription {
+  chatEvents(roomId: "general") {
+    __typename
+    ... on MessageAdded { message { id content } }
+    ... on MessageEdited { message { id content history { content } } }
+    ... on MessageDeleted { message { id } }
+  }
+}`
+
+type chatEvent struct {
+	Data struct {
+		ChatEvents struct {
+			Typename string `json:"__typename"`
+			Message  struct {
+				ID      string `json:"id"`
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"chatEvents"`
+	} `json:"data"`
+}
+
+// holdingStore は hold を呼んでから release を呼ぶまで編集履歴の取得を止める
+//
+// MessageEdited の配信中（history の解決中）にトランスポートを止め、後続のイベントを溜めるために使う
+type holdingStore struct {
+	store.Store
+	mu   sync.Mutex
+	gate chan struct{}
+}
+
+func (s *holdingStore) hold() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gate = make(chan struct{})
+}
+
+func (s *holdingStore) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	close(s.gate)
+	s.gate = nil
+}
+
+func (s *holdingStore) ListMessageEdits(messageID string) []*model.MessageEdit {
+	s.mu.Lock()
+	gate := s.gate
+	s.mu.Unlock()
+	if gate != nil {
+		<-gate
+	}
+	return s.Store.ListMessageEdits(messageID)
+}
+
+func sendMessage(t *testing.T, srv *servertest.Server, token, content string) string {
+	t.Helper()
+	var data struct {
+		SendMessage struct {
+			ID string `json:"id"`
+		} `json:"sendMessage"`
+	}
+	srv.MustDo(t, token, `mutation($c: String!) { sendMessage(roomId: "general", content: $c) { id } }`,
+		map[string]interface{}{"c": content}, &data)
+	return data.SendMessage.ID
+}
+
+// TestChatEventsEventIDs は、編集・削除と追加が混ざっても MessageAdded だけが自分の連番をイベントIDに持ち、
+// その連番で再接続すると取りこぼしも重複もなく再開できることを確認
+func TestChatEventsEventIDs(t *testing.T) {
+	st := &holdingStore{Store: store.NewMemoryStore()}
+	srv := servertest.New(t, servertest.Options{Store: st})
+	token := srv.Register(t, "alice")
+
+	// 連番は1始まり。最初のメッセージの後から再開する形で購読すると、購読の開始と送信の順序に依らず受け取れる
+	sendMessage(t, srv, token, "m0")
+	stream := srv.Subscribe(t, token, chatEventsQuery, nil, http.Header{"Last-Event-ID": {"1"}})
+	m1 := sendMessage(t, srv, token, "m1")
+	var first chatEvent
+	if event := stream.NextData(t, &first); event.ID != "2" || first.Data.ChatEvents.Message.ID != m1 {
+		t.Fatalf("first event = %v, want m1 with id 2", event)
+	}
+
+	edit := func(id, content string) {
+		srv.MustDo(t, token, `mutation($id: ID!, $c: String!) { editMessage(id: $id, content: $c) { id } }`,
+			map[string]interface{}{"id": id, "c": content}, nil)
+	}
+	remove := func(id string) {
+		srv.MustDo(t, token, `mutation($id: ID!) { deleteMessage(id: $id) { id } }`, map[string]interface{}{"id": id}, nil)
+	}
+
+	// 編集イベントの書き出しを止めている間に次のメッセージを送り、リゾルバーを先に進ませる
+	st.hold()
+	edit(m1, "m1 edited")
+	m2 := sendMessage(t, srv, token, "m2")
+	// リゾルバーが m2 のイベントIDを積んでチャンネルへの送信で待つまでの猶予
+	time.Sleep(50 * time.Millisecond)
+	st.release()
+	remove(m1)
+	m3 := sendMessage(t, srv, token, "m3")
+	edit(m2, "m2 edited")
+	m4 := sendMessage(t, srv, token, "m4")
+
+	want := []struct {
+		typename, messageID, eventID string
+	}{
+		{"MessageEdited", m1, ""},
+		{"MessageAdded", m2, "3"},
+		{"MessageDeleted", m1, ""},
+		{"MessageAdded", m3, "4"},
+		{"MessageEdited", m2, ""},
+		{"MessageAdded", m4, "5"},
+	}
+	for i, w := range want {
+		var got chatEvent
+		event := stream.NextData(t, &got)
+		if got.Data.ChatEvents.Typename != w.typename || got.Data.ChatEvents.Message.ID != w.messageID || event.ID != w.eventID {
+			t.Fatalf("event %d = %s %s id=%q, want %s %s id=%q",
+				i, got.Data.ChatEvents.Typename, got.Data.ChatEvents.Message.ID, event.ID, w.typename, w.messageID, w.eventID)
+		}
+	}
+	stream.Close()
+
+	// 切断中のメッセージは、最後に受け取ったイベントIDで再接続すると順に再送される
+	m5 := sendMessage(t, srv, token, "m5")
+	m6 := sendMessage(t, srv, token, "m6")
+	resumed := srv.Subscribe(t, token, chatEventsQuery, nil, http.Header{"Last-Event-ID": {"5"}})
+	for i, id := range []string{m5, m6} {
+		var got chatEvent
+		event := resumed.NextData(t, &got)
+		if got.Data.ChatEvents.Typename != "MessageAdded" || got.Data.ChatEvents.Message.ID != id || event.ID != strconv.Itoa(6+i) {
+			t.Fatalf("resumed event %d = %v, want MessageAdded %s", i, event, id)
+		}
+	}
+	m7 := sendMessage(t, srv, token, "m7")
+	var live chatEvent
+	if event := resumed.NextData(t, &live); live.Data.ChatEvents.Message.ID != m7 || event.ID != "8" {
+		t.Fatalf("live event after resume = %v, want m7 with id 8", event)
+	}
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"crypto/rand"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/99designs/gqlgen/graphql/playground"
+	"github.com/kajidog/graphql-sse-test/apps/backend/auth"
 	"github.com/kajidog/graphql-sse-test/apps/backend/graph"
 	"github.com/kajidog/graphql-sse-test/apps/backend/middleware"
 	"github.com/kajidog/graphql-sse-test/apps/backend/pubsub"
@@ -16,21 +22,125 @@ import (
 
 const defaultPort = "8080"
 
+var (
+	storeKind     = flag.String("store", "memory", "storage backend: memory, file or sqlite")
+	dataDir       = flag.String("data-dir", "data", "data directory for the file and sqlite stores")
+	admins        = flag.String("admins", "", "comma-separated usernames promoted to ADMIN at startup (must be registered unless -admin-password is set)")
+	adminPassword = flag.String("admin-password", os.Getenv("ADMIN_PASSWORD"), "password of -admins users created at startup if missing (default $ADMIN_PASSWORD)")
+
+	jwtAlgorithm  = flag.String("jwt-alg", auth.AlgorithmHS256, "token signing algorithm: HS256 or RS256")
+	jwtSecret     = flag.String("jwt-secret", os.Getenv("JWT_SECRET"), "HS256 signing secret (default $JWT_SECRET)")
+	jwtPrivateKey = flag.String("jwt-private-key", "", "PEM file of the RS256 signing key")
+	jwtPublicKey  = flag.String("jwt-public-key", "", "PEM file of the RS256 verification key (default: derived from the private key)")
+	jwtIssuer     = flag.String("jwt-issuer", "graphql-sse-test", "token issuer (iss)")
+	jwtAudience   = flag.String("jwt-audience", "graphql-sse-test", "token audience (aud)")
+	jwtTTL        = flag.Duration("jwt-ttl", auth.DefaultTokenTTL, "access token lifetime")
+	refreshTTL    = flag.Duration("refresh-ttl", service.DefaultRefreshTokenTTL, "refresh token lifetime, extended on every refresh")
+
+	jwksURL       = flag.String("jwks-url", "", "JWKS URL of an external identity provider whose tokens are also accepted")
+	jwksFile      = flag.String("jwks-file", "", "local JWKS file used instead of -jwks-url")
+	jwksIssuer    = flag.String("jwks-issuer", "", "expected issuer (iss) of external tokens")
+	jwksAudience  = flag.String("jwks-audience", "", "expected audience (aud) of external tokens")
+	jwksUserClaim = flag.String("jwks-user-claim", middleware.DefaultUserIDClaim, "claim of external tokens identifying the user (mapped to a local user together with the issuer)")
+
+	mutationRate       = flag.Float64("mutation-rate", middleware.DefaultUserRateLimit.MutationRate, "mutations per second allowed per user (0 disables)")
+	mutationBurst      = flag.Int("mutation-burst", middleware.DefaultUserRateLimit.MutationBurst, "mutations a user can send in a burst")
+	maxSubscriptions   = flag.Int("max-subscriptions", middleware.DefaultUserRateLimit.MaxSubscriptions, "concurrent subscriptions allowed per user (0 disables)")
+	ipMutationRate     = flag.Float64("ip-mutation-rate", middleware.DefaultIPRateLimit.MutationRate, "mutations per second allowed per client IP (0 disables)")
+	ipMutationBurst    = flag.Int("ip-mutation-burst", middleware.DefaultIPRateLimit.MutationBurst, "mutations a client IP can send in a burst")
+	ipMaxSubscriptions = flag.Int("ip-max-subscriptions", middleware.DefaultIPRateLimit.MaxSubscriptions, "concurrent subscriptions allowed per client IP (0 disables)")
+	trustedProxies     = flag.Int("trusted-proxies", 0, "number of reverse proxies in front of the server; the client IP is taken that many entries from the right of X-Forwarded-For (0 ignores the header)")
+
+	complexityLimit = flag.Int("complexity-limit", server.DefaultComplexityLimit, "maximum cost of an operation (negative disables)")
+	maxDepth        = flag.Int("max-depth", server.DefaultMaxDepth, "maximum nesting depth of an operation (negative disables)")
+
+	overflowPolicy = flag.String("overflow-policy", pubsub.DropOldest.String(), "default policy when a subscriber's buffer overflows: drop-oldest, drop-newest, block or disconnect")
+
+	persistedQueries = flag.String("persisted-queries", "", "persisted query manifest generated by cmd/persisted-manifest")
+	trustedDocuments = flag.Bool("trusted-documents", false, "execute only the operations in -persisted-queries")
+)
+
 func main() {
+	flag.Parse()
+
 	// インフラ層を初期化
-	memoryStore := store.NewMemoryStore()
+	dataStore, err := newStore(*storeKind)
+	if err != nil {
+		log.Fatal(err)
+	}
 	memoryPubSub := pubsub.NewMemoryPubSub()
+	tokens, err := newTokenManager()
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// サービス層を初期化
-	userService := service.NewUserService(memoryStore)
-	messageService := service.NewMessageService(memoryStore, memoryPubSub)
+	userService := service.NewUserService(dataStore)
+	authService := service.NewAuthService(dataStore, memoryPubSub, userService, tokens, *refreshTTL)
+	roomService := service.NewRoomService(dataStore, memoryPubSub)
+	if err := roomService.EnsureDefaultRoom(); err != nil {
+		log.Fatal(err)
+	}
+	// -admins のユーザーは起動時に ADMIN にする（登録時・ログイン時には昇格させない）
+	if err := userService.BootstrapAdmins(*adminPassword, splitList(*admins)...); err != nil {
+		log.Fatal(err)
+	}
+	// 購読ごとに最新64件までバッファし、溢れたときの動作は購読ごとに選べる（デフォルトは -overflow-policy）
+	policy, err := pubsub.ParseOverflowPolicy(*overflowPolicy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	messageService := service.NewMessageService(dataStore, memoryPubSub,
+		pubsub.WithBufferSize(64),
+		pubsub.WithOverflowPolicy(policy),
+	)
+	moderationService := service.NewModerationService(dataStore, memoryPubSub, authService, messageService)
+
+	// 登録済みの操作はAPQのハッシュだけで実行できる（-trusted-documents ではそれ以外を拒否する）
+	persisted, err := newPersistedQueries()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// ログアウトしたセッションのトークンは署名が正しくても拒否する
+	verifier, err := newTokenVerifier(tokens, userService)
+	if err != nil {
+		log.Fatal(err)
+	}
+	verifier = middleware.WithRevocation(verifier, authService)
 
 	// GraphQLリゾルバーとサーバーを初期化
-	resolver := graph.NewResolver(userService, messageService)
-	srv := server.NewServer(graph.NewExecutableSchema(graph.Config{Resolvers: resolver}))
+	resolver := graph.NewResolver(authService, userService, roomService, messageService, moderationService, memoryPubSub)
+	// ディレクティブ（@auth / @hasRole）でフィールドごとの認可を行い、一覧フィールドは取得件数に応じたコストにする
+	// SSEのクエリ・ミューテーションでは @defer / @stream の結果を段階的に返す
+	schema := graph.NewIncrementalExecutableSchema(graph.Config{
+		Resolvers:  resolver,
+		Directives: graph.NewDirectives(userService, moderationService),
+		Complexity: graph.NewComplexity(),
+	})
+
+	// ミューテーションの頻度とサブスクリプション・SSEのストリームの同時数をユーザー・クライアントIPごとに制限する
+	rateLimiter := middleware.NewRateLimiter(middleware.RateLimitConfig{
+		PerUser:        middleware.RateLimit{MutationRate: *mutationRate, MutationBurst: *mutationBurst, MaxSubscriptions: *maxSubscriptions},
+		PerIP:          middleware.RateLimit{MutationRate: *ipMutationRate, MutationBurst: *ipMutationBurst, MaxSubscriptions: *ipMaxSubscriptions},
+		TrustedProxies: *trustedProxies,
+	})
 
-	// CORS + 認証ミドルウェアを適用
-	handler := middleware.CORSMiddleware(middleware.AuthMiddleware(srv))
+	srv := server.NewServer(schema, server.Config{
+		KeepAliveInterval: server.DefaultKeepAliveInterval,
+		// 開いたままのサブスクリプションもトークンの期限切れ・ログアウト・利用停止で終了させる
+		CredentialWatcher: middleware.CredentialWatcher(authService, moderationService),
+		// single connection モードのストリームもサブスクリプションの同時数に数える
+		StreamLimiter:        rateLimiter.AcquireStream,
+		ComplexityLimit:      *complexityLimit,
+		MaxDepth:             *maxDepth,
+		PersistedQueries:     persisted,
+		TrustedDocumentsOnly: *trustedDocuments,
+	})
+	srv.Use(rateLimiter)
+
+	// CORS + レート制限 + 認証ミドルウェアを適用
+	handler := middleware.CORSMiddleware(rateLimiter.Middleware(middleware.AuthMiddleware(verifier, srv)))
 
 	http.Handle("/", playground.Handler("GraphQL playground", "/graphql"))
 	http.Handle("/graphql", handler)
@@ -40,3 +150,115 @@ func main() {
 	fmt.Printf("GraphQL endpoint: http://localhost:%s/graphql\n", defaultPort)
 	log.Fatal(http.ListenAndServe(":"+defaultPort, nil))
 }
+
+// newStore は -store フラグに応じたストレージを作成
+func newStore(kind string) (store.Store, error) {
+	switch kind {
+	case "memory":
+		return store.NewMemoryStore(), nil
+	case "file":
+		log.Printf("Using file store: %s", *dataDir)
+		return store.NewFileStore(*dataDir, store.DefaultCompactThreshold)
+	case "sqlite":
+		if err := os.MkdirAll(*dataDir, 0o755); err != nil {
+			return nil, fmt.Errorf("create data directory: %w", err)
+		}
+		path := filepath.Join(*dataDir, "chat.db")
+		log.Printf("Using sqlite store: %s", path)
+		return store.OpenSQLite(path)
+	default:
+		return nil, fmt.Errorf("unknown store: %s", kind)
+	}
+}
+
+// newTokenManager は -jwt-* フラグに応じてアクセストークンの発行・検証を作成
+//
+// HS256 で鍵が指定されていない場合は起動ごとにランダムな鍵を使う（再起動で発行済みのトークンは無効になる）
+func newTokenManager() (*auth.TokenManager, error) {
+	cfg := auth.Config{
+		Algorithm: *jwtAlgorithm,
+		Issuer:    *jwtIssuer,
+		Audience:  *jwtAudience,
+		TTL:       *jwtTTL,
+	}
+	switch *jwtAlgorithm {
+	case auth.AlgorithmHS256:
+		cfg.Secret = []byte(*jwtSecret)
+		if len(cfg.Secret) == 0 {
+			log.Printf("JWT secret is not set; using a random key for this process")
+			cfg.Secret = make([]byte, 32)
+			if _, err := rand.Read(cfg.Secret); err != nil {
+				return nil, fmt.Errorf("generate JWT secret: %w", err)
+			}
+		}
+	case auth.AlgorithmRS256:
+		if *jwtPrivateKey != "" {
+			key, err := auth.LoadRSAPrivateKey(*jwtPrivateKey)
+			if err != nil {
+				return nil, err
+			}
+			cfg.PrivateKey = key
+		}
+		if *jwtPublicKey != "" {
+			key, err := auth.LoadRSAPublicKey(*jwtPublicKey)
+			if err != nil {
+				return nil, err
+			}
+			cfg.PublicKey = key
+		}
+		if cfg.PrivateKey == nil {
+			return nil, fmt.Errorf("-jwt-private-key is required for RS256")
+		}
+	}
+	return auth.NewTokenManager(cfg)
+}
+
+// newTokenVerifier はリクエストのトークンの検証を作成
+//
+// -jwks-url / -jwks-file が指定された場合は、login で発行したトークンに加えて外部のIDプロバイダーのトークンも受け付ける。
+// 外部の利用者は初回のリクエストでローカルのユーザーとして作成する
+func newTokenVerifier(tokens *auth.TokenManager, users service.UserService) (middleware.TokenVerifier, error) {
+	if *jwksURL == "" && *jwksFile == "" {
+		return tokens, nil
+	}
+	jwks, err := middleware.NewJWKSVerifier(middleware.JWKSConfig{
+		URL:         *jwksURL,
+		File:        *jwksFile,
+		Issuer:      *jwksIssuer,
+		Audience:    *jwksAudience,
+		UserIDClaim: *jwksUserClaim,
+	}, users)
+	if err != nil {
+		return nil, err
+	}
+	return middleware.ChainVerifier{tokens, jwks}, nil
+}
+
+// newPersistedQueries は -persisted-queries の登録済みの操作を読み込む
+//
+// 指定されていない場合はAPQで登録された操作だけを使う（-trusted-documents には必須）
+func newPersistedQueries() (map[string]string, error) {
+	if *persistedQueries == "" {
+		if *trustedDocuments {
+			return nil, fmt.Errorf("-persisted-queries is required for -trusted-documents")
+		}
+		return nil, nil
+	}
+	queries, err := server.LoadPersistedQueries(*persistedQueries)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Loaded %d persisted queries: %s", len(queries), *persistedQueries)
+	return queries, nil
+}
+
+// splitList はカンマ区切りのフラグの値を分割（空の要素は除く）
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
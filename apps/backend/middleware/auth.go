@@ -2,17 +2,25 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strings"
+
+	"github.com/kajidog/graphql-sse-test/apps/backend/auth"
 )
 
-// 固定の認証トークン（本番ではCognitoトークンを使用）
-const ValidToken = "sample-auth-token-12345"
+// TokenVerifier はアクセストークンを検証してクレームを返す
+type TokenVerifier interface {
+	Verify(token string) (*auth.Claims, error)
+}
 
 // コンテキストキー
 type contextKey string
 
-const userIDKey contextKey = "userID"
+const (
+	userIDKey contextKey = "userID"
+	claimsKey contextKey = "claims"
+)
 
 // WithUserID はコンテキストにユーザーIDを追加
 func WithUserID(ctx context.Context, userID string) context.Context {
@@ -25,38 +33,72 @@ func UserIDFromContext(ctx context.Context) (string, bool) {
 	return userID, ok
 }
 
-// AuthMiddleware はBearerトークンを検証し、ユーザーIDをコンテキストに追加
-func AuthMiddleware(next http.Handler) http.Handler {
+// WithClaims はコンテキストに検証済みのクレームを追加
+func WithClaims(ctx context.Context, claims *auth.Claims) context.Context {
+	return context.WithValue(ctx, claimsKey, claims)
+}
+
+// ClaimsFromContext はコンテキストから検証済みのクレームを取得
+func ClaimsFromContext(ctx context.Context) (*auth.Claims, bool) {
+	claims, ok := ctx.Value(claimsKey).(*auth.Claims)
+	return claims, ok
+}
+
+// SessionIDFromContext はコンテキストからトークンのセッションIDを取得（外部のトークンでは空）
+func SessionIDFromContext(ctx context.Context) string {
+	if claims, ok := ClaimsFromContext(ctx); ok {
+		return claims.SessionID
+	}
+	return ""
+}
+
+// AuthMiddleware はBearerトークンを検証し、トークンの subject をユーザーIDとしてコンテキストに追加
+//
+// Authorizationヘッダーがないリクエストは未ログインとして通す（login などは未ログインで呼べる）。
+// ヘッダーがある場合は、署名・有効期限などが正しくなければ401を返す。
+func AuthMiddleware(verifier TokenVerifier, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		auth := r.Header.Get("Authorization")
+		header := r.Header.Get("Authorization")
 
-		// Authorizationヘッダーがない場合
-		if auth == "" {
-			http.Error(w, `{"errors":[{"message":"Authorization header required"}]}`, http.StatusUnauthorized)
+		// Authorizationヘッダーがない場合は未ログイン
+		if header == "" {
+			next.ServeHTTP(w, r)
 			return
 		}
 
 		// Bearer形式でない場合
-		if !strings.HasPrefix(auth, "Bearer ") {
-			http.Error(w, `{"errors":[{"message":"Invalid authorization format. Use: Bearer <token>"}]}`, http.StatusUnauthorized)
+		if !strings.HasPrefix(header, "Bearer ") {
+			unauthorized(w, "Invalid authorization format. Use: Bearer <token>")
 			return
 		}
 
-		token := strings.TrimPrefix(auth, "Bearer ")
-
 		// トークンを検証
-		if token != ValidToken {
-			http.Error(w, `{"errors":[{"message":"Invalid token"}]}`, http.StatusUnauthorized)
+		claims, err := verifier.Verify(strings.TrimPrefix(header, "Bearer "))
+		if err != nil {
+			unauthorized(w, tokenErrorMessage(err))
 			return
 		}
 
-		// 認証成功：X-User-IDヘッダーからユーザーIDを取得してコンテキストに追加
-		userID := r.Header.Get("X-User-ID")
-		if userID != "" {
-			ctx := WithUserID(r.Context(), userID)
-			r = r.WithContext(ctx)
-		}
-
+		// 認証成功：検証済みのクレームからユーザーIDを取得してコンテキストに追加
+		ctx := WithClaims(r.Context(), claims)
+		r = r.WithContext(WithUserID(ctx, claims.UserID()))
 		next.ServeHTTP(w, r)
 	})
 }
+
+func tokenErrorMessage(err error) string {
+	if errors.Is(err, auth.ErrTokenExpired) {
+		return "Token expired"
+	}
+	if errors.Is(err, auth.ErrSessionRevoked) {
+		return "Session revoked"
+	}
+	return "Invalid token"
+}
+
+func unauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write([]byte(`{"errors":[{"message":"` + message + `","extensions":{"code":"UNAUTHENTICATED"}}]}`))
+}
@@ -0,0 +1,388 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/kajidog/graphql-sse-test/apps/backend/auth"
+)
+
+const (
+	// DefaultJWKSRefreshInterval は鍵セットを定期的に取り直す間隔のデフォルト値
+	DefaultJWKSRefreshInterval = time.Hour
+	// DefaultJWKSMinRefreshInterval は未知の kid による取り直しの最短間隔のデフォルト値
+	DefaultJWKSMinRefreshInterval = time.Minute
+	// DefaultUserIDClaim は利用者を識別するクレームのデフォルト値
+	DefaultUserIDClaim = "sub"
+)
+
+// JWKSConfig は外部のIDプロバイダー（Cognitoなど）が発行したトークンの検証設定
+//
+// URL と File のどちらか一方で鍵セット（JWKS）の取得元を指定する
+type JWKSConfig struct {
+	// URL はJWKSのURL（例: https://cognito-idp.<region>.amazonaws.com/<poolId>/.well-known/jwks.json）
+	URL string
+	// File はJWKSのローカルファイル（取り直すたびに読み込むため、ファイルの差し替えで鍵を更新できる）
+	File string
+	// Issuer / Audience は iss / aud の期待値（空の場合は検証しない）
+	Issuer   string
+	Audience string
+	// UserIDClaim は利用者を識別するクレーム（空の場合は sub）。発行者との組でローカルのユーザーに対応づける
+	UserIDClaim string
+	// Algorithms は受け付ける署名アルゴリズム（空の場合は RS256 / ES256）
+	Algorithms []string
+	// RefreshInterval は鍵セットを取り直す間隔（0以下は DefaultJWKSRefreshInterval）
+	RefreshInterval time.Duration
+	// MinRefreshInterval は未知の kid による取り直しの最短間隔（0以下は DefaultJWKSMinRefreshInterval）
+	MinRefreshInterval time.Duration
+	// HTTPClient はJWKSの取得に使う（nilの場合はタイムアウト付きのクライアント）
+	HTTPClient *http.Client
+}
+
+// ExternalUserProvisioner は外部のIDプロバイダーの利用者に対応するローカルのユーザーを用意する
+type ExternalUserProvisioner interface {
+	// ProvisionExternalUser は発行者と subject の組に対応するユーザーのIDを返す（初回はユーザーを作成する）
+	ProvisionExternalUser(issuer, subject, nickname string) (string, error)
+}
+
+// JWKSVerifier はJWKSの公開鍵でトークンを検証する TokenVerifier
+//
+// 鍵は kid ごとにキャッシュし、期限切れ・未知の kid（鍵のローテーション）で取り直す。
+// トークンの利用者は発行者と UserIDClaim の組でローカルのユーザーに対応づける
+type JWKSVerifier struct {
+	cfg   JWKSConfig
+	users ExternalUserProvisioner
+	now   func() time.Time
+	load  func(ctx context.Context) ([]byte, error)
+
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	// refreshing は実行中の取り直し（同時に来たリクエストはこれを待つ）
+	refreshing *jwksRefresh
+}
+
+// jwksRefresh は実行中の鍵セットの取り直し
+type jwksRefresh struct {
+	done chan struct{}
+}
+
+// NewJWKSVerifier は鍵セットを取得してJWKSVerifierを作成
+//
+// 検証したトークンの利用者は users でローカルのユーザーに対応づける
+func NewJWKSVerifier(cfg JWKSConfig, users ExternalUserProvisioner) (*JWKSVerifier, error) {
+	if (cfg.URL == "") == (cfg.File == "") {
+		return nil, fmt.Errorf("exactly one of JWKS URL or file is required")
+	}
+	if users == nil {
+		return nil, fmt.Errorf("external user provisioner is required")
+	}
+	if cfg.UserIDClaim == "" {
+		cfg.UserIDClaim = DefaultUserIDClaim
+	}
+	if len(cfg.Algorithms) == 0 {
+		cfg.Algorithms = []string{"RS256", "ES256"}
+	}
+	if cfg.RefreshInterval <= 0 {
+		cfg.RefreshInterval = DefaultJWKSRefreshInterval
+	}
+	if cfg.MinRefreshInterval <= 0 {
+		cfg.MinRefreshInterval = DefaultJWKSMinRefreshInterval
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	v := &JWKSVerifier{cfg: cfg, users: users, now: time.Now}
+	if cfg.URL != "" {
+		v.load = v.fetch
+	} else {
+		v.load = func(context.Context) ([]byte, error) { return os.ReadFile(cfg.File) }
+	}
+
+	v.attemptedAt = v.now()
+	keys, err := v.loadKeys(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	v.keys = keys
+	v.fetchedAt = v.attemptedAt
+	return v, nil
+}
+
+// Verify は署名・有効期限・発行者・対象を検証し、対応するローカルのユーザーIDを Subject にしたクレームを返す
+func (v *JWKSVerifier) Verify(token string) (*auth.Claims, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(v.cfg.Algorithms),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(v.now),
+	}
+	if v.cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(v.cfg.Issuer))
+	}
+	if v.cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(v.cfg.Audience))
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.key(kid)
+	}, opts...)
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return nil, auth.ErrTokenExpired
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", auth.ErrInvalidToken, err)
+	}
+
+	subject, ok := claims[v.cfg.UserIDClaim].(string)
+	if !ok || subject == "" {
+		return nil, fmt.Errorf("%w: missing %s claim", auth.ErrInvalidToken, v.cfg.UserIDClaim)
+	}
+	issuer, _ := claims.GetIssuer()
+	userID, err := v.users.ProvisionExternalUser(issuer, subject, displayName(claims))
+	if err != nil {
+		return nil, fmt.Errorf("%w: provision user: %v", auth.ErrInvalidToken, err)
+	}
+	return mapClaims(claims, userID), nil
+}
+
+// displayName は外部トークンから表示名に使えるクレームを取り出す（無ければ空）
+func displayName(claims jwt.MapClaims) string {
+	for _, name := range []string{"name", "preferred_username", "cognito:username"} {
+		if value, ok := claims[name].(string); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// key は kid の公開鍵を返す
+//
+// キャッシュが古い場合はキャッシュ済みの鍵で検証を続けながら裏で取り直す。
+// kid が未知の場合は取り直しを待つが、取り直しを始めるのは MinRefreshInterval に1回までに制限する
+// （偽の kid で取得元に負荷をかけさせない）。実行中の取り直しがあれば、新たに取得せずそれを待つ
+func (v *JWKSVerifier) key(kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	now := v.now()
+	key, known := v.keys[kid]
+	var pending *jwksRefresh
+	if !known || now.Sub(v.fetchedAt) >= v.cfg.RefreshInterval {
+		if v.refreshing != nil || now.Sub(v.attemptedAt) >= v.cfg.MinRefreshInterval {
+			pending = v.startRefresh()
+		}
+	}
+	v.mu.Unlock()
+
+	if known {
+		return key, nil
+	}
+	if pending != nil {
+		<-pending.done
+		v.mu.Lock()
+		key, known = v.keys[kid]
+		v.mu.Unlock()
+		if known {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key id: %q", kid)
+}
+
+// startRefresh は鍵セットの取り直しを始める（ロックを取得済みであること）
+//
+// 実行中の取り直しがあればそれを返す。取得はロックの外で行う
+func (v *JWKSVerifier) startRefresh() *jwksRefresh {
+	if v.refreshing != nil {
+		return v.refreshing
+	}
+	r := &jwksRefresh{done: make(chan struct{})}
+	v.refreshing = r
+	v.attemptedAt = v.now()
+	attemptedAt := v.attemptedAt
+
+	go func() {
+		defer close(r.done)
+		keys, err := v.loadKeys(context.Background())
+
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		v.refreshing = nil
+		if err != nil {
+			// 取得に失敗しても、次の取り直しまではキャッシュ済みの鍵で検証を続ける
+			log.Printf("[JWKS] refresh: %v", err)
+			return
+		}
+		v.keys = keys
+		v.fetchedAt = attemptedAt
+	}()
+	return r
+}
+
+// loadKeys は鍵セットを取得して公開鍵を取り出す
+func (v *JWKSVerifier) loadKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	data, err := v.load(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("load JWKS: %w", err)
+	}
+	return parseJWKS(data)
+}
+
+// fetch はURLからJWKSを取得
+func (v *JWKSVerifier) fetch(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.cfg.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := v.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// jwk はJWKSの鍵1つ（RFC 7517）
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS はJWKSから署名用の公開鍵を kid ごとに取り出す（未対応の鍵は無視する）
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			log.Printf("[JWKS] skip key %q: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS has no usable signing keys")
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid n: %w", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, fmt.Errorf("invalid e")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve: %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x: %w", err)
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y: %w", err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve %s", k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// mapClaims は外部トークンのクレームを、userID を Subject にした auth.Claims に変換
+func mapClaims(claims jwt.MapClaims, userID string) *auth.Claims {
+	c := &auth.Claims{}
+	c.Subject = userID
+	c.Issuer, _ = claims.GetIssuer()
+	c.Audience, _ = claims.GetAudience()
+	c.ExpiresAt, _ = claims.GetExpirationTime()
+	c.IssuedAt, _ = claims.GetIssuedAt()
+	c.NotBefore, _ = claims.GetNotBefore()
+	c.ID, _ = claims["jti"].(string)
+	return c
+}
+
+// ChainVerifier は複数の TokenVerifier を順に試し、最初に検証できたクレームを返す
+//
+// 全て失敗した場合、期限切れと判定したものがあれば auth.ErrTokenExpired を返す
+type ChainVerifier []TokenVerifier
+
+// Verify は順に検証し、最初に成功したクレームを返す
+func (c ChainVerifier) Verify(token string) (*auth.Claims, error) {
+	err := auth.ErrInvalidToken
+	for _, v := range c {
+		claims, verr := v.Verify(token)
+		if verr == nil {
+			return claims, nil
+		}
+		if !errors.Is(err, auth.ErrTokenExpired) {
+			err = verr
+		}
+	}
+	return nil, err
+}
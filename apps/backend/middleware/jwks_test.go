@@ -0,0 +1,378 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/kajidog/graphql-sse-test/apps/backend/auth"
+)
+
+const testIssuer = "https://idp.example.com"
+
+// jwksServer は鍵セットを差し替えられるJWKSの取得元
+type jwksServer struct {
+	*httptest.Server
+	requests atomic.Int32
+
+	mu     sync.Mutex
+	keys   []map[string]string
+	status int
+}
+
+func newJWKSServer(t *testing.T, keys ...map[string]string) *jwksServer {
+	t.Helper()
+	s := &jwksServer{keys: keys, status: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.status != http.StatusOK {
+			w.WriteHeader(s.status)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": s.keys})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// setKeys は取得元の鍵セットを差し替える
+func (s *jwksServer) setKeys(keys ...map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+func (s *jwksServer) setStatus(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+// signingKey はテスト用の署名鍵とそのJWK
+type signingKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.Signer
+	jwk     map[string]string
+}
+
+func newRSAKey(t *testing.T, kid string) signingKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingKey{kid: kid, method: jwt.SigningMethodRS256, private: key, jwk: map[string]string{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}
+}
+
+func newECKey(t *testing.T, kid string) signingKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingKey{kid: kid, method: jwt.SigningMethodES256, private: key, jwk: map[string]string{
+		"kty": "EC",
+		"kid": kid,
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}}
+}
+
+// sign は subject と有効期限のトークンに署名する
+func (k signingKey) sign(t *testing.T, subject string, expiresAt time.Time) string {
+	t.Helper()
+	token := jwt.NewWithClaims(k.method, jwt.MapClaims{
+		"iss":  testIssuer,
+		"sub":  subject,
+		"exp":  expiresAt.Unix(),
+		"name": "External " + subject,
+	})
+	token.Header["kid"] = k.kid
+	signed, err := token.SignedString(k.private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// fakeProvisioner は発行者と subject の組から決まったユーザーIDを返す
+type fakeProvisioner struct{}
+
+func (fakeProvisioner) ProvisionExternalUser(issuer, subject, nickname string) (string, error) {
+	return "local:" + subject, nil
+}
+
+// testClock はテストから進める時計
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// newTestJWKSVerifier は src から鍵セットを取得し、clock で時刻を決める JWKSVerifier を作成
+func newTestJWKSVerifier(t *testing.T, src *jwksServer) (*JWKSVerifier, *testClock) {
+	t.Helper()
+	v, err := NewJWKSVerifier(JWKSConfig{
+		URL:                src.URL,
+		Issuer:             testIssuer,
+		RefreshInterval:    time.Hour,
+		MinRefreshInterval: time.Minute,
+	}, fakeProvisioner{})
+	if err != nil {
+		t.Fatalf("NewJWKSVerifier: %v", err)
+	}
+	clock := &testClock{now: v.attemptedAt}
+	v.now = clock.Now
+	return v, clock
+}
+
+// waitRefresh は取得元へのリクエスト数が want になり、裏での取り直しが終わるまで待つ
+func waitRefresh(t *testing.T, v *JWKSVerifier, src *jwksServer, want int32) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		v.mu.Lock()
+		refreshing := v.refreshing != nil
+		v.mu.Unlock()
+		if !refreshing && src.requests.Load() == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("JWKS requests = %d, want %d (refreshing: %v)", src.requests.Load(), want, refreshing)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestJWKSVerifierRSAAndEC(t *testing.T) {
+	rsaKey, ecKey := newRSAKey(t, "rsa-1"), newECKey(t, "ec-1")
+	src := newJWKSServer(t, rsaKey.jwk, ecKey.jwk)
+	v, clock := newTestJWKSVerifier(t, src)
+
+	for _, key := range []signingKey{rsaKey, ecKey} {
+		claims, err := v.Verify(key.sign(t, "alice", clock.Now().Add(time.Hour)))
+		if err != nil {
+			t.Fatalf("Verify(%s): %v", key.kid, err)
+		}
+		if claims.Subject != "local:alice" || claims.Issuer != testIssuer {
+			t.Errorf("Verify(%s) = subject %q, issuer %q", key.kid, claims.Subject, claims.Issuer)
+		}
+	}
+
+	// 鍵セットの別の鍵で署名したもの（kid の差し替え）は受け付けない
+	forged := newRSAKey(t, "rsa-1")
+	if _, err := v.Verify(forged.sign(t, "alice", clock.Now().Add(time.Hour))); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Errorf("Verify(forged) error = %v, want ErrInvalidToken", err)
+	}
+	if got := src.requests.Load(); got != 1 {
+		t.Errorf("JWKS requests = %d, want 1", got)
+	}
+}
+
+func TestJWKSVerifierExpired(t *testing.T) {
+	key := newRSAKey(t, "rsa-1")
+	src := newJWKSServer(t, key.jwk)
+	v, clock := newTestJWKSVerifier(t, src)
+
+	token := key.sign(t, "alice", clock.Now().Add(time.Minute))
+	if _, err := v.Verify(token); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	clock.Advance(2 * time.Minute)
+	if _, err := v.Verify(token); !errors.Is(err, auth.ErrTokenExpired) {
+		t.Errorf("Verify(expired) error = %v, want ErrTokenExpired", err)
+	}
+}
+
+func TestJWKSVerifierKeyRotation(t *testing.T) {
+	oldKey, newKey := newRSAKey(t, "key-1"), newECKey(t, "key-2")
+	src := newJWKSServer(t, oldKey.jwk)
+	v, clock := newTestJWKSVerifier(t, src)
+	expiresAt := clock.Now().Add(24 * time.Hour)
+
+	// 取得元が新しい鍵に切り替わった後、MinRefreshInterval を過ぎていれば未知の kid で取り直す
+	src.setKeys(newKey.jwk)
+	clock.Advance(time.Minute)
+	if _, err := v.Verify(newKey.sign(t, "alice", expiresAt)); err != nil {
+		t.Fatalf("Verify(rotated key): %v", err)
+	}
+	if got := src.requests.Load(); got != 2 {
+		t.Errorf("JWKS requests = %d, want 2", got)
+	}
+
+	// 取得元から消えた鍵は受け付けない
+	if _, err := v.Verify(oldKey.sign(t, "alice", expiresAt)); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Errorf("Verify(removed key) error = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestJWKSVerifierMinRefreshInterval(t *testing.T) {
+	key, unknown := newRSAKey(t, "key-1"), newRSAKey(t, "key-2")
+	src := newJWKSServer(t, key.jwk)
+	v, clock := newTestJWKSVerifier(t, src)
+	expiresAt := clock.Now().Add(24 * time.Hour)
+
+	// 前回の取得から MinRefreshInterval 以内は、未知の kid でも取り直さない
+	for i := 0; i < 5; i++ {
+		if _, err := v.Verify(unknown.sign(t, "alice", expiresAt)); !errors.Is(err, auth.ErrInvalidToken) {
+			t.Fatalf("Verify(unknown kid) error = %v, want ErrInvalidToken", err)
+		}
+	}
+	if got := src.requests.Load(); got != 1 {
+		t.Fatalf("JWKS requests within MinRefreshInterval = %d, want 1", got)
+	}
+
+	// 過ぎると1回だけ取り直し、次の取り直しはまた MinRefreshInterval 後
+	clock.Advance(time.Minute)
+	for i := 0; i < 5; i++ {
+		v.Verify(unknown.sign(t, "alice", expiresAt))
+	}
+	if got := src.requests.Load(); got != 2 {
+		t.Fatalf("JWKS requests after MinRefreshInterval = %d, want 2", got)
+	}
+
+	// 既知の kid は取り直しを待たずに検証できる
+	if _, err := v.Verify(key.sign(t, "alice", expiresAt)); err != nil {
+		t.Errorf("Verify(known kid): %v", err)
+	}
+}
+
+func TestJWKSVerifierRefreshInterval(t *testing.T) {
+	key := newECKey(t, "key-1")
+	src := newJWKSServer(t, key.jwk)
+	v, clock := newTestJWKSVerifier(t, src)
+	token := key.sign(t, "alice", clock.Now().Add(24*time.Hour))
+
+	// 古くなったキャッシュは検証を続けながら裏で取り直し、失敗しても鍵を捨てない
+	src.setStatus(http.StatusInternalServerError)
+	clock.Advance(time.Hour)
+	if _, err := v.Verify(token); err != nil {
+		t.Fatalf("Verify(stale cache): %v", err)
+	}
+	waitRefresh(t, v, src, 2)
+	clock.Advance(time.Minute)
+	if _, err := v.Verify(token); err != nil {
+		t.Fatalf("Verify after failed refresh: %v", err)
+	}
+	waitRefresh(t, v, src, 3)
+}
+
+func TestJWKSVerifierCoalescesRefresh(t *testing.T) {
+	key := newRSAKey(t, "key-1")
+	src := newJWKSServer(t, key.jwk)
+	v, clock := newTestJWKSVerifier(t, src)
+
+	// 取り直しを止めている間に、未知の kid のリクエストを並行して送る
+	rotated := newECKey(t, "key-2")
+	src.setKeys(rotated.jwk)
+	clock.Advance(time.Minute)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var loads atomic.Int32
+	fetch := v.load
+	v.load = func(ctx context.Context) ([]byte, error) {
+		if loads.Add(1) == 1 {
+			close(started)
+		}
+		<-release
+		return fetch(ctx)
+	}
+
+	token := rotated.sign(t, "alice", clock.Now().Add(time.Hour))
+	const concurrency = 10
+	errs := make(chan error, concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			_, err := v.Verify(token)
+			errs <- err
+		}()
+	}
+	<-started
+	// 後から来たリクエストも実行中の取り直しに合流させる
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	for i := 0; i < concurrency; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Verify: %v", err)
+		}
+	}
+	if got := loads.Load(); got != 1 {
+		t.Errorf("loads = %d, want 1", got)
+	}
+}
+
+// verifierFunc は関数を TokenVerifier として使う
+type verifierFunc func(token string) (*auth.Claims, error)
+
+func (f verifierFunc) Verify(token string) (*auth.Claims, error) { return f(token) }
+
+func TestChainVerifierExpired(t *testing.T) {
+	key := newRSAKey(t, "key-1")
+	src := newJWKSServer(t, key.jwk)
+	jwks, clock := newTestJWKSVerifier(t, src)
+	local := verifierFunc(func(token string) (*auth.Claims, error) {
+		if token == "local" {
+			return &auth.Claims{}, nil
+		}
+		return nil, auth.ErrInvalidToken
+	})
+	expired := key.sign(t, "alice", clock.Now().Add(-time.Minute))
+
+	// どの順で試しても、期限切れと判定したものがあれば ErrTokenExpired（クライアントに再発行を促す）
+	for name, chain := range map[string]ChainVerifier{
+		"local first": {local, jwks},
+		"jwks first":  {jwks, local},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := chain.Verify(expired); !errors.Is(err, auth.ErrTokenExpired) {
+				t.Errorf("Verify(expired) error = %v, want ErrTokenExpired", err)
+			}
+			if _, err := chain.Verify("garbage"); !errors.Is(err, auth.ErrInvalidToken) {
+				t.Errorf("Verify(garbage) error = %v, want ErrInvalidToken", err)
+			}
+			if _, err := chain.Verify("local"); err != nil {
+				t.Errorf("Verify(local): %v", err)
+			}
+			claims, err := chain.Verify(key.sign(t, "alice", clock.Now().Add(time.Hour)))
+			if err != nil || claims.Subject != "local:alice" {
+				t.Errorf("Verify(jwks) = %+v, %v", claims, err)
+			}
+		})
+	}
+}
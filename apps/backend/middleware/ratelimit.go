@@ -0,0 +1,395 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// RateLimit は1つのキー（ユーザーまたはクライアントIP）あたりの上限
+//
+// 0以下の値はその上限を設けない
+type RateLimit struct {
+	// MutationRate は1秒あたりに補充されるミューテーションの回数
+	MutationRate float64
+	// MutationBurst は連続して実行できるミューテーションの回数（バケットの容量）
+	MutationBurst int
+	// MaxSubscriptions は同時に購読できるサブスクリプションの数
+	// （SSEの single connection モードで予約・接続したストリームも1つと数える）
+	MaxSubscriptions int
+}
+
+var (
+	// DefaultUserRateLimit はログイン中のユーザーごとの上限のデフォルト値
+	DefaultUserRateLimit = RateLimit{MutationRate: 2, MutationBurst: 10, MaxSubscriptions: 20}
+	// DefaultIPRateLimit はクライアントIPごとの上限のデフォルト値（NATなどで複数のユーザーが共有するため大きめ）
+	DefaultIPRateLimit = RateLimit{MutationRate: 10, MutationBurst: 50, MaxSubscriptions: 100}
+)
+
+// RateLimitConfig はレート制限の設定
+type RateLimitConfig struct {
+	PerUser RateLimit
+	PerIP   RateLimit
+	// TrustedProxies は前段にある信頼できるリバースプロキシの数（0の場合は X-Forwarded-For を使わない）
+	//
+	// 各プロキシは受け取った接続の送信元を X-Forwarded-For の末尾に追加するため、右からこの数番目をクライアントIPとして使う。
+	// それより左の値はクライアントが自由に付けられるため使わない
+	TrustedProxies int
+}
+
+// rateLimitSweepInterval は使われなくなったキーを捨てる間隔
+const rateLimitSweepInterval = time.Minute
+
+// RateLimiter はミューテーションの頻度とサブスクリプションの同時数を制限する
+//
+// ログイン中のリクエストはユーザーとクライアントIPの両方、未ログインのリクエストはクライアントIPの上限を確認する。
+// ミューテーションはトークンバケットで、サブスクリプションは終了するまで枠を占有する。
+// 超えた操作は実行せず、extensions.code が RATE_LIMITED のGraphQLエラーを返す。
+// single connection モードのストリームも、予約から閉じるまでサブスクリプションと同じ枠を1つ占有する（AcquireStream）。
+//
+// Middleware でHTTPハンドラーを包み、gqlgen のサーバーに拡張として登録して使う
+type RateLimiter struct {
+	cfg RateLimitConfig
+
+	mu            sync.Mutex
+	buckets       map[string]*tokenBucket
+	subscriptions map[string]int
+	lastSweep     time.Time
+	now           func() time.Time
+}
+
+// NewRateLimiter は新しいRateLimiterを作成
+func NewRateLimiter(cfg RateLimitConfig) *RateLimiter {
+	return &RateLimiter{
+		cfg:           cfg,
+		buckets:       make(map[string]*tokenBucket),
+		subscriptions: make(map[string]int),
+		now:           time.Now,
+	}
+}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.OperationInterceptor
+} = (*RateLimiter)(nil)
+
+// ExtensionName は gqlgen の拡張としての名前
+func (l *RateLimiter) ExtensionName() string {
+	return "RateLimiter"
+}
+
+// Validate は gqlgen の拡張としての検証（設定の誤りは無い）
+func (l *RateLimiter) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+// Middleware はクライアントIPをコンテキストに追加し、POSTのレスポンスを 429 と Retry-After にできるようにする
+//
+// SSEのレスポンス（text/event-stream）はステータスを変えず、エラーを `next` イベントで送る
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := &rateLimitState{}
+		ctx := withClientIP(r.Context(), clientIP(r, l.cfg.TrustedProxies))
+		ctx = context.WithValue(ctx, rateLimitStateKey, state)
+		next.ServeHTTP(&rateLimitResponseWriter{ResponseWriter: w, state: state}, r.WithContext(ctx))
+	})
+}
+
+// InterceptOperation は操作の実行前に上限を確認する
+func (l *RateLimiter) InterceptOperation(ctx context.Context, next graphql.OperationHandler) graphql.ResponseHandler {
+	keys := rateLimitKeys(ctx)
+	switch graphql.GetOperationContext(ctx).Operation.Operation {
+	case ast.Mutation:
+		if retryAfter, ok := l.takeMutation(keys); !ok {
+			if state, ok := ctx.Value(rateLimitStateKey).(*rateLimitState); ok {
+				state.setRetryAfter(retryAfter)
+			}
+			log.Printf("[RateLimit] mutation limited: %v", keys)
+			return rejected(&gqlerror.Error{
+				Message: "Too many requests",
+				Extensions: map[string]interface{}{
+					"code":       "RATE_LIMITED",
+					"retryAfter": retryAfterSeconds(retryAfter),
+				},
+			})
+		}
+	case ast.Subscription:
+		release, ok := l.acquireSubscription(keys)
+		if !ok {
+			log.Printf("[RateLimit] subscription limited: %v", keys)
+			return rejected(&gqlerror.Error{
+				Message:    "Too many subscriptions",
+				Extensions: map[string]interface{}{"code": "RATE_LIMITED"},
+			})
+		}
+		return releasing(ctx, next(ctx), release)
+	}
+	return next(ctx)
+}
+
+// AcquireStream はSSEの single connection モードのストリーム1本分の枠を確保し、解放する関数を返す
+//
+// server.Config.StreamLimiter に設定して使う。枠はサブスクリプションの同時数（MaxSubscriptions）と共有する
+func (l *RateLimiter) AcquireStream(ctx context.Context) (release func(), ok bool) {
+	keys := rateLimitKeys(ctx)
+	release, ok = l.acquireSubscription(keys)
+	if !ok {
+		log.Printf("[RateLimit] stream limited: %v", keys)
+	}
+	return release, ok
+}
+
+// rateLimitKeys は上限を数える単位（ユーザーとクライアントIP）の一覧
+func rateLimitKeys(ctx context.Context) []string {
+	var keys []string
+	if userID, ok := UserIDFromContext(ctx); ok {
+		keys = append(keys, "user:"+userID)
+	}
+	if ip, ok := ClientIPFromContext(ctx); ok {
+		keys = append(keys, "ip:"+ip)
+	}
+	return keys
+}
+
+// limitFor はキーの種類に応じた上限
+func (l *RateLimiter) limitFor(key string) RateLimit {
+	if strings.HasPrefix(key, "user:") {
+		return l.cfg.PerUser
+	}
+	return l.cfg.PerIP
+}
+
+// takeMutation は全てのキーのバケットから1回分を取り出す（どれかが空なら何も取り出さない）
+func (l *RateLimiter) takeMutation(keys []string) (retryAfter time.Duration, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+
+	var limited []*tokenBucket
+	buckets := make([]*tokenBucket, 0, len(keys))
+	for _, key := range keys {
+		limit := l.limitFor(key)
+		if limit.MutationRate <= 0 || limit.MutationBurst <= 0 {
+			continue
+		}
+		b, exists := l.buckets[key]
+		if !exists {
+			b = &tokenBucket{tokens: float64(limit.MutationBurst), last: now, limit: limit}
+			l.buckets[key] = b
+		}
+		b.refill(now)
+		if b.tokens < 1 {
+			limited = append(limited, b)
+		}
+		buckets = append(buckets, b)
+	}
+	for _, b := range limited {
+		if wait := b.wait(); wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+	if len(limited) > 0 {
+		return retryAfter, false
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return 0, true
+}
+
+// acquireSubscription は全てのキーでサブスクリプションの枠を1つ確保し、解放する関数を返す
+func (l *RateLimiter) acquireSubscription(keys []string) (release func(), ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		if limit := l.limitFor(key).MaxSubscriptions; limit > 0 && l.subscriptions[key] >= limit {
+			return nil, false
+		}
+	}
+	for _, key := range keys {
+		l.subscriptions[key]++
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			for _, key := range keys {
+				if l.subscriptions[key]--; l.subscriptions[key] <= 0 {
+					delete(l.subscriptions, key)
+				}
+			}
+		})
+	}, true
+}
+
+// sweep は満タンに戻ったバケットを捨てる（呼び出し側でロックすること）
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.MutationBurst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// tokenBucket はミューテーションのトークンバケット
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	limit  RateLimit
+}
+
+// refill は前回からの経過時間分のトークンを補充
+func (b *tokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	b.tokens = math.Min(float64(b.limit.MutationBurst), b.tokens+elapsed*b.limit.MutationRate)
+	b.last = now
+}
+
+// wait は次の1回分が補充されるまでの時間
+func (b *tokenBucket) wait() time.Duration {
+	return time.Duration((1 - b.tokens) / b.limit.MutationRate * float64(time.Second))
+}
+
+// rejected は操作を実行せずにエラーだけを返すレスポンス
+func rejected(err *gqlerror.Error) graphql.ResponseHandler {
+	return graphql.OneShot(&graphql.Response{Errors: gqlerror.List{err}})
+}
+
+// releasing はサブスクリプションが終わったとき（レスポンスの終端またはコンテキストの終了）に release を呼ぶ
+func releasing(ctx context.Context, responses graphql.ResponseHandler, release func()) graphql.ResponseHandler {
+	go func() {
+		<-ctx.Done()
+		release()
+	}()
+	return func(ctx context.Context) *graphql.Response {
+		resp := responses(ctx)
+		if resp == nil {
+			release()
+		}
+		return resp
+	}
+}
+
+// retryAfterSeconds は Retry-After に入れる秒数（切り上げ、最小1秒）
+func retryAfterSeconds(d time.Duration) int {
+	return max(1, int(math.Ceil(d.Seconds())))
+}
+
+const (
+	clientIPKey       contextKey = "clientIP"
+	rateLimitStateKey contextKey = "rateLimitState"
+)
+
+// withClientIP はコンテキストにクライアントIPを追加
+func withClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey, ip)
+}
+
+// ClientIPFromContext はコンテキストからクライアントIPを取得
+func ClientIPFromContext(ctx context.Context) (string, bool) {
+	ip, ok := ctx.Value(clientIPKey).(string)
+	return ip, ok && ip != ""
+}
+
+// clientIP はリクエストの送信元IP
+//
+// trustedProxies が1以上の場合は X-Forwarded-For の右から trustedProxies 番目（足りない場合は先頭）を使う
+func clientIP(r *http.Request, trustedProxies int) string {
+	if trustedProxies > 0 {
+		// 複数の X-Forwarded-For ヘッダーは続けて1つのリストとして扱う
+		var forwarded []string
+		for _, value := range r.Header.Values("X-Forwarded-For") {
+			for _, addr := range strings.Split(value, ",") {
+				forwarded = append(forwarded, strings.TrimSpace(addr))
+			}
+		}
+		if len(forwarded) > 0 {
+			if addr := forwarded[max(0, len(forwarded)-trustedProxies)]; addr != "" {
+				return addr
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimitState はリクエスト中に制限された操作の Retry-After を、レスポンスを書き出すまで保持する
+type rateLimitState struct {
+	mu         sync.Mutex
+	retryAfter time.Duration
+}
+
+func (s *rateLimitState) setRetryAfter(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retryAfter = max(s.retryAfter, d, time.Nanosecond)
+}
+
+func (s *rateLimitState) get() (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.retryAfter, s.retryAfter > 0
+}
+
+// rateLimitResponseWriter は制限されたPOSTのレスポンスを 429 にして Retry-After を付ける
+type rateLimitResponseWriter struct {
+	http.ResponseWriter
+	state       *rateLimitState
+	wroteHeader bool
+}
+
+func (w *rateLimitResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	retryAfter, limited := w.state.get()
+	if limited && status == http.StatusOK && !strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(retryAfter)))
+		status = http.StatusTooManyRequests
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *rateLimitResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush はSSEのストリーミングのために元の ResponseWriter へ委譲
+func (w *rateLimitResponseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap は http.ResponseController のために元の ResponseWriter を返す
+func (w *rateLimitResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketRefill(t *testing.T) {
+	l := NewRateLimiter(RateLimitConfig{PerUser: RateLimit{MutationRate: 2, MutationBurst: 2}})
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	keys := []string{"user:u1"}
+
+	take := func(want bool, wantRetryAfter time.Duration) {
+		t.Helper()
+		retryAfter, ok := l.takeMutation(keys)
+		if ok != want || retryAfter != wantRetryAfter {
+			t.Fatalf("takeMutation() = %v, %v, want %v, %v", retryAfter, ok, wantRetryAfter, want)
+		}
+	}
+
+	// 容量（MutationBurst）までは続けて実行できる
+	take(true, 0)
+	take(true, 0)
+	take(false, 500*time.Millisecond)
+
+	// 1秒あたり MutationRate 回分ずつ補充される
+	now = now.Add(250 * time.Millisecond)
+	take(false, 250*time.Millisecond)
+	now = now.Add(250 * time.Millisecond)
+	take(true, 0)
+
+	// 長く空いても容量を超えては溜まらない
+	now = now.Add(time.Hour)
+	take(true, 0)
+	take(true, 0)
+	take(false, 500*time.Millisecond)
+}
+
+func TestTakeMutationAllKeys(t *testing.T) {
+	l := NewRateLimiter(RateLimitConfig{
+		PerUser: RateLimit{MutationRate: 1, MutationBurst: 1},
+		PerIP:   RateLimit{MutationRate: 1, MutationBurst: 2},
+	})
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+
+	if _, ok := l.takeMutation([]string{"user:u1", "ip:10.0.0.1"}); !ok {
+		t.Fatal("first mutation was limited")
+	}
+	// ユーザーの上限で断った操作はIPのバケットからも取り出さない
+	if _, ok := l.takeMutation([]string{"user:u1", "ip:10.0.0.1"}); ok {
+		t.Fatal("second mutation of u1 was not limited")
+	}
+	if _, ok := l.takeMutation([]string{"user:u2", "ip:10.0.0.1"}); !ok {
+		t.Fatal("mutation of u2 was limited by the rejected mutation of u1")
+	}
+}
+
+func TestAcquireSubscriptionRelease(t *testing.T) {
+	l := NewRateLimiter(RateLimitConfig{PerUser: RateLimit{MaxSubscriptions: 1}})
+	keys := []string{"user:u1", "ip:10.0.0.1"}
+
+	release, ok := l.acquireSubscription(keys)
+	if !ok {
+		t.Fatal("first subscription was limited")
+	}
+	if _, ok := l.acquireSubscription(keys); ok {
+		t.Fatal("second subscription was not limited")
+	}
+
+	// 解放は何度呼んでも1回分
+	release()
+	release()
+	if _, ok := l.acquireSubscription(keys); !ok {
+		t.Fatal("subscription was limited after release")
+	}
+	if _, ok := l.acquireSubscription(keys); ok {
+		t.Fatal("double release freed two slots")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name           string
+		forwarded      []string
+		trustedProxies int
+		want           string
+	}{
+		{"ignored without trusted proxies", []string{"192.0.2.1"}, 0, "10.0.0.1"},
+		{"no header", nil, 1, "10.0.0.1"},
+		{"one proxy uses the rightmost entry", []string{"192.0.2.9, 192.0.2.1"}, 1, "192.0.2.1"},
+		{"two proxies", []string{"192.0.2.9, 192.0.2.1, 198.51.100.1"}, 2, "192.0.2.1"},
+		{"multiple headers", []string{"192.0.2.9", "192.0.2.1"}, 1, "192.0.2.1"},
+		{"fewer entries than proxies", []string{"192.0.2.1"}, 2, "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &http.Request{RemoteAddr: "10.0.0.1:12345", Header: http.Header{}}
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got := clientIP(r, tt.trustedProxies); got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
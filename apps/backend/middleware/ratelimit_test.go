@@ -0,0 +1,108 @@
+package middleware_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kajidog/graphql-sse-test/apps/backend/middleware"
+	"github.com/kajidog/graphql-sse-test/apps/backend/server/servertest"
+)
+
+type rateLimitedError struct {
+	Message    string `json:"message"`
+	Extensions struct {
+		Code       string `json:"code"`
+		RetryAfter int    `json:"retryAfter"`
+	} `json:"extensions"`
+}
+
+func TestRateLimitMutationPOST(t *testing.T) {
+	// 未ログインの登録はIPの上限のみ（無制限）で数える
+	srv := servertest.New(t, servertest.Options{RateLimit: &middleware.RateLimitConfig{
+		PerUser: middleware.RateLimit{MutationRate: 0.1, MutationBurst: 1},
+	}})
+	token := srv.Register(t, "alice")
+
+	const mutation = `mutation { updateNickname(nickname: "Alice") { id } }`
+	srv.MustDo(t, token, mutation, nil, nil)
+
+	res := srv.Do(t, token, mutation, nil)
+	if res.Status != http.StatusTooManyRequests || res.Header.Get("Retry-After") != "10" {
+		t.Fatalf("status = %d, Retry-After = %q, want 429 and 10", res.Status, res.Header.Get("Retry-After"))
+	}
+	if len(res.Errors) != 1 {
+		t.Fatalf("errors = %s", res.Errors)
+	}
+	var gqlErr rateLimitedError
+	if err := json.Unmarshal(res.Errors[0], &gqlErr); err != nil {
+		t.Fatal(err)
+	}
+	if gqlErr.Extensions.Code != "RATE_LIMITED" || gqlErr.Extensions.RetryAfter != 10 {
+		t.Errorf("error = %+v, want RATE_LIMITED with retryAfter 10", gqlErr)
+	}
+
+	// クエリは制限しない
+	if res := srv.Do(t, token, `{ me { id } }`, nil); res.Status != http.StatusOK || len(res.Errors) != 0 {
+		t.Errorf("query after limit = %d %s", res.Status, res.Errors)
+	}
+}
+
+func TestRateLimitSubscriptionSSE(t *testing.T) {
+	srv := servertest.New(t, servertest.Options{RateLimit: &middleware.RateLimitConfig{
+		PerUser: middleware.RateLimit{MaxSubscriptions: 1},
+	}})
+	token := srv.Register(t, "alice")
+	const subscription = `subscription { messageAdded(roomId: "general") { content } }`
+
+	send := func(content string) {
+		srv.MustDo(t, token, `mutation($c: String!) { sendMessage(roomId: "general", content: $c) { id } }`,
+			map[string]interface{}{"c": content}, nil)
+	}
+
+	// 最初のメッセージの後から再開する形で購読し、購読の開始と送信の順序に依らず2件目を受け取る
+	// （受け取れば購読が始まって枠を占有している）
+	resume := http.Header{"Last-Event-ID": {"1"}}
+	send("m0")
+	first := srv.Subscribe(t, token, subscription, nil, resume)
+	send("first")
+	first.NextData(t, nil)
+
+	// 上限を超えた購読はエラーの next を送ってから complete する（SSEのステータスは 200 のまま）
+	limited := srv.Subscribe(t, token, subscription, nil, resume)
+	if limited.Response.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200", limited.Response.StatusCode)
+	}
+	var payload struct {
+		Errors []rateLimitedError `json:"errors"`
+	}
+	limited.NextData(t, &payload)
+	if len(payload.Errors) != 1 || payload.Errors[0].Extensions.Code != "RATE_LIMITED" {
+		t.Fatalf("payload = %+v, want a RATE_LIMITED error", payload)
+	}
+	if event := limited.Next(t); event.Event != "complete" {
+		t.Fatalf("event = %v, want complete", event)
+	}
+	limited.Closed(t)
+
+	// 切断すると枠が解放され、次の購読ができる
+	first.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for i := 0; ; i++ {
+		stream := srv.Subscribe(t, token, subscription, nil, resume)
+		event := stream.Next(t)
+		if !strings.Contains(string(event.Data), "RATE_LIMITED") {
+			if !strings.Contains(string(event.Data), `"first"`) {
+				t.Fatalf("event = %v, want the message", event)
+			}
+			break
+		}
+		stream.Close()
+		if time.Now().After(deadline) {
+			t.Fatalf("subscription slot was not released after %d attempts", i+1)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
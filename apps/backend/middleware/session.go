@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/kajidog/graphql-sse-test/apps/backend/auth"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// SessionStore はセッションの失効を確認・監視する
+type SessionStore interface {
+	IsRevoked(sessionID string) bool
+	// WatchSession はセッションが失効すると auth.ErrSessionRevoked を理由に終了するコンテキストを返す
+	WatchSession(sessionID string) (context.Context, context.CancelFunc)
+}
+
+// BanWatcher はユーザーの利用停止を監視する
+type BanWatcher interface {
+	// WatchBan はユーザーが利用停止になると auth.ErrAccountBanned を理由に終了するコンテキストを返す
+	WatchBan(userID string) (context.Context, context.CancelFunc)
+}
+
+// revocationVerifier はトークンの検証に加えて、セッションが失効していないか確認する
+type revocationVerifier struct {
+	verifier TokenVerifier
+	sessions SessionStore
+}
+
+// WithRevocation は失効したセッションのトークンを auth.ErrSessionRevoked で拒否する TokenVerifier を返す
+//
+// セッションを持たないトークン（外部のIDプロバイダーのトークン）は確認しない
+func WithRevocation(verifier TokenVerifier, sessions SessionStore) TokenVerifier {
+	return &revocationVerifier{verifier: verifier, sessions: sessions}
+}
+
+// Verify はトークンを検証し、セッションが失効していればエラーを返す
+func (v *revocationVerifier) Verify(token string) (*auth.Claims, error) {
+	claims, err := v.verifier.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	if claims.SessionID != "" && v.sessions.IsRevoked(claims.SessionID) {
+		return nil, auth.ErrSessionRevoked
+	}
+	return claims, nil
+}
+
+// CredentialWatcher はリクエストの認証情報が無効になると終了するコンテキストを返す関数を作成
+//
+// SSEのサブスクリプションは接続時にだけ認証されるため、開いたままのストリームを
+// トークンの有効期限切れやログアウト（sessions が nil でなければ）で止めるのに使う。
+// 終了理由は UNAUTHENTICATED のGraphQLエラーになり、クライアントは新しいトークンで再接続する。
+// 利用停止（bans が nil でなければ）では FORBIDDEN のGraphQLエラーで止める。
+// 未ログインのリクエストでは終了しないコンテキストを返す。
+func CredentialWatcher(sessions SessionStore, bans BanWatcher) func(ctx context.Context) (context.Context, context.CancelFunc) {
+	return func(ctx context.Context) (context.Context, context.CancelFunc) {
+		out, cancel := context.WithCancelCause(context.Background())
+		claims, ok := ClaimsFromContext(ctx)
+		if !ok {
+			return out, func() { cancel(context.Canceled) }
+		}
+
+		var stops []func()
+		if claims.ExpiresAt != nil {
+			timer := time.AfterFunc(time.Until(claims.ExpiresAt.Time), func() {
+				cancel(unauthenticatedError(tokenErrorMessage(auth.ErrTokenExpired)))
+			})
+			stops = append(stops, func() { timer.Stop() })
+		}
+		if claims.SessionID != "" && sessions != nil {
+			watched, stop := sessions.WatchSession(claims.SessionID)
+			go func() {
+				<-watched.Done()
+				if errors.Is(context.Cause(watched), auth.ErrSessionRevoked) {
+					cancel(unauthenticatedError(tokenErrorMessage(auth.ErrSessionRevoked)))
+				}
+			}()
+			stops = append(stops, stop)
+		}
+		if bans != nil {
+			watched, stop := bans.WatchBan(claims.UserID())
+			go func() {
+				<-watched.Done()
+				if errors.Is(context.Cause(watched), auth.ErrAccountBanned) {
+					cancel(&gqlerror.Error{
+						Message:    "Account banned",
+						Extensions: map[string]interface{}{"code": "FORBIDDEN"},
+					})
+				}
+			}()
+			stops = append(stops, stop)
+		}
+
+		return out, func() {
+			for _, stop := range stops {
+				stop()
+			}
+			cancel(context.Canceled)
+		}
+	}
+}
+
+// unauthenticatedError はSSEで送る認証エラー（401のレスポンスと同じ extensions.code）
+func unauthenticatedError(message string) *gqlerror.Error {
+	return &gqlerror.Error{
+		Message:    message,
+		Extensions: map[string]interface{}{"code": "UNAUTHENTICATED"},
+	}
+}
@@ -1,59 +1,136 @@
 package pubsub
 
 import (
+	"context"
 	"sync"
-
-	"github.com/kajidog/graphql-sse-test/apps/backend/graph/model"
 )
 
-// PubSub はメッセージのPub/Sub管理インターフェース
+// Event はトピックに配信されるイベント
+//
+// Err が設定されたイベントは購読が打ち切られたことを表し、その後チャンネルは閉じられる
+type Event struct {
+	Topic   string
+	Payload interface{}
+	Err     error
+}
+
+// PubSub はトピック単位のPub/Sub管理インターフェース
+//
+// トピックは "." 区切りのセグメントで表す（例: "message.added"）。
+// 購読時のトピックには以下のワイルドカードを使える
+//   - "*" : 任意の1セグメント（例: "room.*.message"）
+//   - ">" : 末尾に置き、残りの1つ以上のセグメント（例: "room.>"）
 type PubSub interface {
-	Subscribe(id string) chan *model.Message
-	Unsubscribe(id string)
-	Publish(msg *model.Message)
+	// Subscribe はトピックを購読し、ctxが終了すると購読を終了する
+	Subscribe(ctx context.Context, topic string, opts ...SubscribeOption) *Subscription
+	// Publish はトピックに一致する全サブスクライバーにペイロードを配信
+	Publish(topic string, payload interface{})
+}
+
+// SubscribeTyped はトピックを購読し、ペイロードが型Tのイベントだけを受け取る
+//
+// 購読が打ち切られた場合はチャンネルが閉じられ、理由は Subscription.Err で取得できる
+func SubscribeTyped[T any](ctx context.Context, ps PubSub, topic string, opts ...SubscribeOption) (<-chan T, *Subscription) {
+	sub := ps.Subscribe(ctx, topic, opts...)
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for event := range sub.Events() {
+			if event.Err != nil {
+				return
+			}
+			payload, ok := event.Payload.(T)
+			if !ok {
+				continue
+			}
+			select {
+			case out <- payload:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, sub
+}
+
+// SubscriberStats はサブスクライバーごとの配信状況
+type SubscriberStats struct {
+	Topic   string
+	Policy  OverflowPolicy
+	Pending int
+	Dropped uint64
+}
+
+// StatsProvider はサブスクライバーごとの配信状況を提供する
+type StatsProvider interface {
+	Stats() []SubscriberStats
 }
 
 // MemoryPubSub はインメモリPub/Subの実装
 type MemoryPubSub struct {
-	subscribers map[string]chan *model.Message
+	subscribers map[*Subscription]struct{}
 	mu          sync.Mutex
 }
 
 // NewMemoryPubSub は新しいMemoryPubSubを作成
 func NewMemoryPubSub() *MemoryPubSub {
 	return &MemoryPubSub{
-		subscribers: make(map[string]chan *model.Message),
+		subscribers: make(map[*Subscription]struct{}),
 	}
 }
 
-// Subscribe はサブスクライバーを追加
-func (p *MemoryPubSub) Subscribe(id string) chan *model.Message {
+// Subscribe はサブスクライバーを追加し、購読が終了したら削除
+func (p *MemoryPubSub) Subscribe(ctx context.Context, topic string, opts ...SubscribeOption) *Subscription {
+	sub := NewSubscription(ctx, topic, opts...)
+
 	p.mu.Lock()
-	defer p.mu.Unlock()
-	ch := make(chan *model.Message, 1)
-	p.subscribers[id] = ch
-	return ch
+	p.subscribers[sub] = struct{}{}
+	p.mu.Unlock()
+
+	go func() {
+		<-sub.Done()
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		delete(p.subscribers, sub)
+	}()
+
+	return sub
 }
 
-// Unsubscribe はサブスクライバーを削除
-func (p *MemoryPubSub) Unsubscribe(id string) {
+// Publish はトピックに一致する全サブスクライバーに配信
+//
+// Block ポリシーで待つ間も購読の追加・削除を止めないよう、ロックの外でバッファに積む
+func (p *MemoryPubSub) Publish(topic string, payload interface{}) {
+	event := Event{Topic: topic, Payload: payload}
+
 	p.mu.Lock()
-	defer p.mu.Unlock()
-	if ch, ok := p.subscribers[id]; ok {
-		close(ch)
-		delete(p.subscribers, id)
+	matched := make([]*Subscription, 0, len(p.subscribers))
+	for sub := range p.subscribers {
+		if MatchTopic(sub.Topic(), topic) {
+			matched = append(matched, sub)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, sub := range matched {
+		sub.Offer(event)
 	}
 }
 
-// Publish はメッセージを全サブスクライバーに配信
-func (p *MemoryPubSub) Publish(msg *model.Message) {
+var _ StatsProvider = (*MemoryPubSub)(nil)
+
+// Stats は全サブスクライバーの配信状況を返す
+func (p *MemoryPubSub) Stats() []SubscriberStats {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	for _, ch := range p.subscribers {
-		select {
-		case ch <- msg:
-		default:
-			// チャンネルが詰まっている場合はスキップ
-		}
+	stats := make([]SubscriberStats, 0, len(p.subscribers))
+	for sub := range p.subscribers {
+		stats = append(stats, SubscriberStats{
+			Topic:   sub.Topic(),
+			Policy:  sub.Policy(),
+			Pending: sub.Pending(),
+			Dropped: sub.Dropped(),
+		})
 	}
+	return stats
 }
@@ -0,0 +1,281 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrSlowConsumer は Disconnect ポリシーで購読が打ち切られたことを表す
+var ErrSlowConsumer = errors.New("pubsub: subscriber is too slow")
+
+// OverflowPolicy はサブスクライバーのバッファが溢れたときの動作
+type OverflowPolicy int
+
+const (
+	// DropNewest は新しいイベントを捨てる（デフォルト）
+	DropNewest OverflowPolicy = iota
+	// DropOldest は最も古いイベントを捨てて新しいイベントを入れる（リングバッファ）
+	DropOldest
+	// Block は空きが出るまで配信側を待たせ、タイムアウトしたら新しいイベントを捨てる
+	Block
+	// Disconnect は購読を打ち切り、バッファ済みのイベントの後にエラーイベントを送る
+	Disconnect
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case DropNewest:
+		return "drop-newest"
+	case DropOldest:
+		return "drop-oldest"
+	case Block:
+		return "block"
+	case Disconnect:
+		return "disconnect"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseOverflowPolicy は String の表記から OverflowPolicy を取得
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	for _, p := range []OverflowPolicy{DropNewest, DropOldest, Block, Disconnect} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown overflow policy: %s", s)
+}
+
+const (
+	defaultBufferSize   = 1
+	defaultBlockTimeout = time.Second
+)
+
+type subscribeOptions struct {
+	bufferSize   int
+	policy       OverflowPolicy
+	blockTimeout time.Duration
+}
+
+// SubscribeOption は購読ごとの設定
+type SubscribeOption func(*subscribeOptions)
+
+// WithBufferSize は配信待ちイベントのバッファサイズを設定
+func WithBufferSize(n int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		if n > 0 {
+			o.bufferSize = n
+		}
+	}
+}
+
+// WithOverflowPolicy はバッファが溢れたときの動作を設定
+func WithOverflowPolicy(policy OverflowPolicy) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.policy = policy
+	}
+}
+
+// WithBlockTimeout は Block ポリシーで配信側が待つ最大時間を設定
+func WithBlockTimeout(d time.Duration) SubscribeOption {
+	return func(o *subscribeOptions) {
+		if d > 0 {
+			o.blockTimeout = d
+		}
+	}
+}
+
+// Subscription は1つの購読
+//
+// 配信されたイベントは内部のバッファに積まれ、Events() から順に取り出せる。
+// バッファが溢れたときの動作は OverflowPolicy で選ぶ。
+type Subscription struct {
+	topic string
+	opts  subscribeOptions
+
+	mu      sync.Mutex
+	queue   []Event
+	closing bool
+	err     error
+
+	notify  chan struct{}
+	space   chan struct{}
+	events  chan Event
+	done    chan struct{}
+	dropped atomic.Uint64
+}
+
+// NewSubscription は購読を作成し、ctxが終了するまで配信を続ける
+//
+// PubSubの実装はマッチしたイベントを Offer で渡す。
+func NewSubscription(ctx context.Context, topic string, opts ...SubscribeOption) *Subscription {
+	o := subscribeOptions{
+		bufferSize:   defaultBufferSize,
+		policy:       DropNewest,
+		blockTimeout: defaultBlockTimeout,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	s := &Subscription{
+		topic:  topic,
+		opts:   o,
+		queue:  make([]Event, 0, o.bufferSize),
+		notify: make(chan struct{}, 1),
+		space:  make(chan struct{}, 1),
+		events: make(chan Event),
+		done:   make(chan struct{}),
+	}
+	go s.deliver(ctx)
+	return s
+}
+
+// Topic は購読したトピック（パターン）を返す
+func (s *Subscription) Topic() string {
+	return s.topic
+}
+
+// Policy はバッファが溢れたときの動作を返す
+func (s *Subscription) Policy() OverflowPolicy {
+	return s.opts.policy
+}
+
+// Events はイベントを受け取るチャンネルを返す（購読終了時に閉じられる）
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Done は購読が終了すると閉じられるチャンネルを返す
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Dropped はバッファ溢れで捨てられたイベント数を返す
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Pending は配信待ちのイベント数を返す
+func (s *Subscription) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.queue)
+}
+
+// Err は購読が打ち切られた理由を返す（通常の終了ではnil）
+func (s *Subscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Offer はイベントをバッファに積む
+func (s *Subscription) Offer(event Event) {
+	var timer *time.Timer
+	for {
+		s.mu.Lock()
+		if s.closing {
+			s.mu.Unlock()
+			return
+		}
+		if len(s.queue) < s.opts.bufferSize {
+			s.queue = append(s.queue, event)
+			s.mu.Unlock()
+			signal(s.notify)
+			return
+		}
+
+		switch s.opts.policy {
+		case DropOldest:
+			s.queue = append(s.queue[1:], event)
+			s.mu.Unlock()
+			s.dropped.Add(1)
+			signal(s.notify)
+			return
+		case Disconnect:
+			s.closing = true
+			s.err = ErrSlowConsumer
+			s.queue = append(s.queue, Event{Topic: event.Topic, Err: ErrSlowConsumer})
+			s.mu.Unlock()
+			s.dropped.Add(1)
+			signal(s.notify)
+			return
+		case Block:
+			s.mu.Unlock()
+			if timer == nil {
+				timer = time.NewTimer(s.opts.blockTimeout)
+				defer timer.Stop()
+			}
+			select {
+			case <-s.space:
+				continue
+			case <-s.done:
+				return
+			case <-timer.C:
+				s.dropped.Add(1)
+				return
+			}
+		default:
+			s.mu.Unlock()
+			s.dropped.Add(1)
+			return
+		}
+	}
+}
+
+// deliver はバッファのイベントを順にEventsへ送る
+func (s *Subscription) deliver(ctx context.Context) {
+	defer close(s.done)
+	defer close(s.events)
+	// ctx の終了で抜けた場合も以降の Offer を受け付けない（捨てたイベントとして数えない）
+	defer s.markClosed()
+
+	for {
+		s.mu.Lock()
+		if len(s.queue) == 0 {
+			closing := s.closing
+			s.mu.Unlock()
+			if closing {
+				return
+			}
+			select {
+			case <-s.notify:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		event := s.queue[0]
+		s.queue = s.queue[1:]
+		s.mu.Unlock()
+		signal(s.space)
+
+		select {
+		case s.events <- event:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// markClosed は購読を終了済みにし、配信待ちのイベントを破棄する
+func (s *Subscription) markClosed() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closing = true
+	s.queue = nil
+}
+
+// signal はバッファ1のチャンネルへ取りこぼしのない通知を送る
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
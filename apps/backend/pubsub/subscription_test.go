@@ -0,0 +1,207 @@
+package pubsub_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kajidog/graphql-sse-test/apps/backend/pubsub"
+)
+
+// newSubscription はテスト終了時に終了する購読を作成する
+func newSubscription(t *testing.T, opts ...pubsub.SubscribeOption) (*pubsub.Subscription, context.CancelFunc) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return pubsub.NewSubscription(ctx, "topic", opts...), cancel
+}
+
+// fill はバッファを満たす（最初のイベントは配信側が取り出して Events への送信で待つ）
+func fill(t *testing.T, s *pubsub.Subscription, n int) {
+	t.Helper()
+	s.Offer(pubsub.Event{Payload: 0})
+	waitPending(t, s, 0)
+	for i := 1; i <= n; i++ {
+		s.Offer(pubsub.Event{Payload: i})
+	}
+	waitPending(t, s, n)
+}
+
+func waitPending(t *testing.T, s *pubsub.Subscription, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Pending() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Pending() = %d, want %d", s.Pending(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// receive は n 件のイベントを受け取る
+func receive(t *testing.T, s *pubsub.Subscription, n int) []pubsub.Event {
+	t.Helper()
+	events := make([]pubsub.Event, 0, n)
+	for len(events) < n {
+		select {
+		case event, ok := <-s.Events():
+			if !ok {
+				t.Fatalf("events closed after %v", events)
+			}
+			events = append(events, event)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out after %v", events)
+		}
+	}
+	return events
+}
+
+func payloads(events []pubsub.Event) []interface{} {
+	out := make([]interface{}, len(events))
+	for i, e := range events {
+		out[i] = e.Payload
+	}
+	return out
+}
+
+func expectPayloads(t *testing.T, s *pubsub.Subscription, want ...interface{}) {
+	t.Helper()
+	got := payloads(receive(t, s, len(want)))
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("payloads = %v, want %v", got, want)
+		}
+	}
+}
+
+func expectClosed(t *testing.T, s *pubsub.Subscription) {
+	t.Helper()
+	select {
+	case event, ok := <-s.Events():
+		if ok {
+			t.Fatalf("unexpected event %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("events were not closed")
+	}
+	<-s.Done()
+}
+
+func TestDropNewest(t *testing.T) {
+	s, _ := newSubscription(t, pubsub.WithBufferSize(2))
+	fill(t, s, 2)
+
+	s.Offer(pubsub.Event{Payload: 3})
+	if got := s.Dropped(); got != 1 {
+		t.Errorf("Dropped() = %d, want 1", got)
+	}
+	expectPayloads(t, s, 0, 1, 2)
+}
+
+func TestDropOldest(t *testing.T) {
+	s, _ := newSubscription(t, pubsub.WithBufferSize(2), pubsub.WithOverflowPolicy(pubsub.DropOldest))
+	fill(t, s, 2)
+
+	s.Offer(pubsub.Event{Payload: 3})
+	s.Offer(pubsub.Event{Payload: 4})
+	if got := s.Dropped(); got != 2 {
+		t.Errorf("Dropped() = %d, want 2", got)
+	}
+	expectPayloads(t, s, 0, 3, 4)
+}
+
+func TestBlock(t *testing.T) {
+	s, _ := newSubscription(t, pubsub.WithOverflowPolicy(pubsub.Block), pubsub.WithBlockTimeout(time.Hour))
+	fill(t, s, 1)
+
+	// 空きが出るまで配信側を待たせ、捨てずに積む
+	offered := make(chan struct{})
+	go func() {
+		s.Offer(pubsub.Event{Payload: 2})
+		close(offered)
+	}()
+	select {
+	case <-offered:
+		t.Fatal("Offer returned while the buffer was full")
+	case <-time.After(20 * time.Millisecond):
+	}
+	expectPayloads(t, s, 0)
+	select {
+	case <-offered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Offer did not return after the buffer had space")
+	}
+	if got := s.Dropped(); got != 0 {
+		t.Errorf("Dropped() = %d, want 0", got)
+	}
+	expectPayloads(t, s, 1, 2)
+}
+
+func TestBlockTimeout(t *testing.T) {
+	s, _ := newSubscription(t, pubsub.WithOverflowPolicy(pubsub.Block), pubsub.WithBlockTimeout(10*time.Millisecond))
+	fill(t, s, 1)
+
+	// 待っても空かなければ新しいイベントを捨てる
+	s.Offer(pubsub.Event{Payload: 2})
+	if got := s.Dropped(); got != 1 {
+		t.Errorf("Dropped() = %d, want 1", got)
+	}
+	expectPayloads(t, s, 0, 1)
+}
+
+func TestDisconnect(t *testing.T) {
+	s, _ := newSubscription(t, pubsub.WithOverflowPolicy(pubsub.Disconnect))
+	fill(t, s, 1)
+
+	// 溢れたらバッファ済みのイベントの後にエラーを送って終了する
+	s.Offer(pubsub.Event{Topic: "topic", Payload: 2})
+	s.Offer(pubsub.Event{Payload: 3})
+	if got := s.Dropped(); got != 1 {
+		t.Errorf("Dropped() = %d, want 1", got)
+	}
+	events := receive(t, s, 3)
+	if got := payloads(events[:2]); got[0] != 0 || got[1] != 1 {
+		t.Errorf("payloads = %v, want [0 1]", got)
+	}
+	if last := events[2]; !errors.Is(last.Err, pubsub.ErrSlowConsumer) || last.Topic != "topic" {
+		t.Errorf("last event = %+v, want ErrSlowConsumer", last)
+	}
+	expectClosed(t, s)
+	if !errors.Is(s.Err(), pubsub.ErrSlowConsumer) {
+		t.Errorf("Err() = %v, want ErrSlowConsumer", s.Err())
+	}
+}
+
+func TestOfferAfterContextDone(t *testing.T) {
+	for _, policy := range []pubsub.OverflowPolicy{pubsub.DropNewest, pubsub.DropOldest, pubsub.Block, pubsub.Disconnect} {
+		t.Run(policy.String(), func(t *testing.T) {
+			s, cancel := newSubscription(t, pubsub.WithOverflowPolicy(policy), pubsub.WithBlockTimeout(time.Hour))
+			fill(t, s, 1)
+			cancel()
+			select {
+			case <-s.Done():
+			case <-time.After(5 * time.Second):
+				t.Fatal("subscription did not end")
+			}
+
+			// 終了した購読への配信は待たずに無視し、捨てたイベントとして数えない
+			offered := make(chan struct{})
+			go func() {
+				s.Offer(pubsub.Event{Payload: 2})
+				close(offered)
+			}()
+			select {
+			case <-offered:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Offer blocked after the subscription ended")
+			}
+			if got := s.Dropped(); got != 0 {
+				t.Errorf("Dropped() = %d, want 0", got)
+			}
+			if s.Err() != nil {
+				t.Errorf("Err() = %v, want nil", s.Err())
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package pubsub
+
+import "strings"
+
+const (
+	// TopicSeparator はトピックのセグメント区切り
+	TopicSeparator = "."
+	// WildcardSegment は任意の1セグメントに一致する
+	WildcardSegment = "*"
+	// WildcardRest は残りの1つ以上のセグメントに一致する（末尾のみ）
+	WildcardRest = ">"
+)
+
+// Topic はセグメントを連結してトピックを作成
+func Topic(segments ...string) string {
+	return strings.Join(segments, TopicSeparator)
+}
+
+// MatchTopic は購読パターンがトピックに一致するかを判定
+func MatchTopic(pattern, topic string) bool {
+	if pattern == topic {
+		return true
+	}
+
+	patterns := strings.Split(pattern, TopicSeparator)
+	topics := strings.Split(topic, TopicSeparator)
+	for i, p := range patterns {
+		if p == WildcardRest && i == len(patterns)-1 {
+			return len(topics) > i
+		}
+		if i >= len(topics) {
+			return false
+		}
+		if p != WildcardSegment && p != topics[i] {
+			return false
+		}
+	}
+	return len(patterns) == len(topics)
+}
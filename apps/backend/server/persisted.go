@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/errcode"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+const (
+	// PersistedQueryManifestFormat は登録済みの操作の一覧のファイル形式（Apollo の persisted query manifest と同じ）
+	PersistedQueryManifestFormat = "apollo-persisted-query-manifest"
+	// DefaultPersistedQueryCacheSize はAPQで登録されたクエリを覚えておく件数
+	DefaultPersistedQueryCacheSize = 1000
+
+	errPersistedQueryNotFound = "PERSISTED_QUERY_NOT_FOUND"
+	errTrustedDocumentOnly    = "TRUSTED_DOCUMENT_REQUIRED"
+)
+
+// PersistedQueryManifest は登録済みの操作の一覧
+//
+// operations.graphql から cmd/persisted-manifest で生成する
+type PersistedQueryManifest struct {
+	Format     string               `json:"format"`
+	Version    int                  `json:"version"`
+	Operations []PersistedOperation `json:"operations"`
+}
+
+// PersistedOperation は登録済みの操作（ID は Body の sha256 の16進表記）
+type PersistedOperation struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Body string `json:"body"`
+}
+
+// PersistedQueryHash はクエリ文字列のハッシュ（extensions.persistedQuery.sha256Hash の値）
+func PersistedQueryHash(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadPersistedQueries は登録済みの操作の一覧を読み込み、ハッシュからクエリ文字列への対応を返す
+func LoadPersistedQueries(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read persisted queries: %w", err)
+	}
+	var manifest PersistedQueryManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("decode persisted queries: %w", err)
+	}
+	if manifest.Format != PersistedQueryManifestFormat || manifest.Version != 1 {
+		return nil, fmt.Errorf("unsupported persisted queries format: %s version %d", manifest.Format, manifest.Version)
+	}
+
+	queries := make(map[string]string, len(manifest.Operations))
+	for _, op := range manifest.Operations {
+		if PersistedQueryHash(op.Body) != op.ID {
+			return nil, fmt.Errorf("persisted query %s: id does not match the body", op.Name)
+		}
+		queries[op.ID] = op.Body
+	}
+	return queries, nil
+}
+
+// persistedQueryCache はAPQのキャッシュの前に登録済みの操作を引く
+type persistedQueryCache struct {
+	registered map[string]string
+	graphql.Cache
+}
+
+func (c persistedQueryCache) Get(ctx context.Context, key string) (interface{}, bool) {
+	if query, ok := c.registered[key]; ok {
+		return query, true
+	}
+	return c.Cache.Get(ctx, key)
+}
+
+// TrustedDocuments は登録済みの操作だけを実行する拡張
+//
+// extensions.persistedQuery のハッシュで操作を指定する。クエリ文字列が送られた場合も、
+// 登録済みのものと完全に一致する場合だけ実行する（APQでの新たな登録は受け付けない）。
+type TrustedDocuments struct {
+	Operations map[string]string
+}
+
+var _ interface {
+	graphql.OperationParameterMutator
+	graphql.HandlerExtension
+} = TrustedDocuments{}
+
+func (t TrustedDocuments) ExtensionName() string {
+	return "TrustedDocuments"
+}
+
+func (t TrustedDocuments) Validate(schema graphql.ExecutableSchema) error {
+	if len(t.Operations) == 0 {
+		return fmt.Errorf("trusted documents require at least one persisted query")
+	}
+	return nil
+}
+
+func (t TrustedDocuments) MutateOperationParameters(ctx context.Context, params *graphql.RawParams) *gqlerror.Error {
+	if params.Query != "" {
+		if _, ok := t.Operations[PersistedQueryHash(params.Query)]; !ok {
+			err := gqlerror.Errorf("only trusted documents can be executed; send a registered persisted query")
+			errcode.Set(err, errTrustedDocumentOnly)
+			return err
+		}
+		return nil
+	}
+
+	hash, ok := persistedQueryHash(params.Extensions)
+	if !ok {
+		// クエリもハッシュも無い場合は通常の「クエリが無い」エラーにする
+		return nil
+	}
+	query, ok := t.Operations[hash]
+	if !ok {
+		err := gqlerror.Errorf("PersistedQueryNotFound")
+		errcode.Set(err, errPersistedQueryNotFound)
+		return err
+	}
+	params.Query = query
+	return nil
+}
+
+// persistedQueryHash は extensions.persistedQuery からハッシュを取得
+func persistedQueryHash(extensions map[string]interface{}) (string, bool) {
+	persisted, ok := extensions["persistedQuery"].(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	hash, ok := persisted["sha256Hash"].(string)
+	return hash, ok && hash != ""
+}
+
+// hasPersistedQuery は操作がクエリ文字列の代わりにハッシュで指定されているかどうか
+func hasPersistedQuery(extensions map[string]interface{}) bool {
+	_, ok := persistedQueryHash(extensions)
+	return ok
+}
@@ -1,23 +1,89 @@
 package server
 
 import (
+	"context"
+	"time"
+
 	"github.com/99designs/gqlgen/graphql"
 	"github.com/99designs/gqlgen/graphql/handler"
 	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/99designs/gqlgen/graphql/handler/lru"
 	"github.com/99designs/gqlgen/graphql/handler/transport"
 )
 
+const (
+	// DefaultComplexityLimit は操作のコスト（一覧は件数を掛けたフィールド数）の上限のデフォルト値
+	DefaultComplexityLimit = 2000
+	// DefaultMaxDepth は操作のネストの深さの上限のデフォルト値（イントロスペクションのクエリが通る深さ）
+	DefaultMaxDepth = 15
+)
+
+// Config はGraphQLサーバーの設定
+type Config struct {
+	// KeepAliveInterval はSSEストリームのハートビート送信間隔
+	// 0の場合はDefaultKeepAliveInterval、負の値の場合はハートビートを送らない
+	KeepAliveInterval time.Duration
+	// CredentialWatcher はSSEのサブスクリプション中に認証情報の失効を監視する（nilの場合は監視しない）
+	CredentialWatcher func(ctx context.Context) (context.Context, context.CancelFunc)
+	// StreamLimiter はSSEの single connection モードのストリームの同時数を制限する（nilの場合は制限しない）
+	StreamLimiter func(ctx context.Context) (release func(), ok bool)
+	// ComplexityLimit は操作のコストの上限
+	// 0の場合はDefaultComplexityLimit、負の値の場合は制限しない
+	ComplexityLimit int
+	// MaxDepth は操作のネストの深さの上限
+	// 0の場合はDefaultMaxDepth、負の値の場合は制限しない
+	MaxDepth int
+	// PersistedQueries は登録済みの操作（ハッシュ → クエリ文字列）で、APQで最初から使える
+	PersistedQueries map[string]string
+	// TrustedDocumentsOnly は PersistedQueries にある操作だけを実行する（APQでの登録も受け付けない）
+	TrustedDocumentsOnly bool
+}
+
 // NewServer はGraphQLサーバーを作成
-func NewServer(es graphql.ExecutableSchema) *handler.Server {
+func NewServer(es graphql.ExecutableSchema, cfg Config) *handler.Server {
 	srv := handler.New(es)
 
+	keepAlive := cfg.KeepAliveInterval
+	if keepAlive == 0 {
+		keepAlive = DefaultKeepAliveInterval
+	}
+
 	// トランスポートを追加
-	srv.AddTransport(SSETransport{})
+	sse := NewSSETransport(keepAlive)
+	sse.CredentialWatcher = cfg.CredentialWatcher
+	sse.StreamLimiter = cfg.StreamLimiter
+	srv.AddTransport(sse)
 	srv.AddTransport(transport.Options{})
 	srv.AddTransport(transport.POST{})
 
 	// Introspection有効
 	srv.Use(extension.Introspection{})
+	// クエリ文字列の代わりに extensions.persistedQuery のハッシュで操作を指定できる
+	if cfg.TrustedDocumentsOnly {
+		srv.Use(TrustedDocuments{Operations: cfg.PersistedQueries})
+	} else {
+		srv.Use(extension.AutomaticPersistedQuery{
+			Cache: persistedQueryCache{registered: cfg.PersistedQueries, Cache: lru.New(DefaultPersistedQueryCacheSize)},
+		})
+	}
+	// @defer / @stream はSSEのクエリ・ミューテーションでのみ段階的に返す
+	srv.Use(deferSupport{})
+
+	// 重すぎる・深すぎる操作は実行前に拒否する（コストの計算はスキーマの Complexity による）
+	if limit := orDefault(cfg.ComplexityLimit, DefaultComplexityLimit); limit > 0 {
+		srv.Use(extension.FixedComplexityLimit(limit))
+	}
+	if depth := orDefault(cfg.MaxDepth, DefaultMaxDepth); depth > 0 {
+		srv.Use(DepthLimit{Limit: depth})
+	}
 
 	return srv
 }
+
+// orDefault は0の場合にデフォルト値を返す
+func orDefault(v, def int) int {
+	if v == 0 {
+		return def
+	}
+	return v
+}
@@ -0,0 +1,324 @@
+// Package servertest はアプリ全体（graph・service・メモリのストア）を httptest のサーバーで起動するテスト用のヘルパー
+//
+// SSEトランスポートの振る舞いを、実際のスキーマとリゾルバーを通して確かめるために使う
+//
+//	srv := servertest.New(t, servertest.Options{})
+//	token := srv.Register(t, "alice")
+//	stream := srv.Subscribe(t, token, `subscription { messageAdded(roomId: "general") { id } }`, nil, nil)
+//	event := stream.Next(t)
+package servertest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kajidog/graphql-sse-test/apps/backend/auth"
+	"github.com/kajidog/graphql-sse-test/apps/backend/graph"
+	"github.com/kajidog/graphql-sse-test/apps/backend/middleware"
+	"github.com/kajidog/graphql-sse-test/apps/backend/pubsub"
+	"github.com/kajidog/graphql-sse-test/apps/backend/server"
+	"github.com/kajidog/graphql-sse-test/apps/backend/service"
+	"github.com/kajidog/graphql-sse-test/apps/backend/store"
+)
+
+// eventTimeout はイベントを待つ最大時間
+const eventTimeout = 5 * time.Second
+
+// Options はテスト用サーバーの設定
+type Options struct {
+	// Server はGraphQLサーバーの設定（KeepAliveInterval が0の場合はハートビートを送らない）
+	Server server.Config
+	// Store はサービスが使うストア（nilの場合はメモリのストア）
+	Store store.Store
+	// RateLimit はレート制限の設定（nilの場合は制限しない）
+	RateLimit *middleware.RateLimitConfig
+}
+
+// Server は起動したテスト用サーバー
+type Server struct {
+	*httptest.Server
+	Store store.Store
+}
+
+// New はテスト用サーバーを起動する（テスト終了時に停止する）
+func New(t *testing.T, opts Options) *Server {
+	t.Helper()
+	s := opts.Store
+	if s == nil {
+		s = store.NewMemoryStore()
+	}
+	ps := pubsub.NewMemoryPubSub()
+	tokens, err := auth.NewTokenManager(auth.Config{Secret: []byte(strings.Repeat("s", 32))})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users := service.NewUserService(s)
+	auths := service.NewAuthService(s, ps, users, tokens, 0)
+	rooms := service.NewRoomService(s, ps)
+	if err := rooms.EnsureDefaultRoom(); err != nil {
+		t.Fatal(err)
+	}
+	messages := service.NewMessageService(s, ps, pubsub.WithBufferSize(64))
+	moderation := service.NewModerationService(s, ps, auths, messages)
+
+	schema := graph.NewIncrementalExecutableSchema(graph.Config{
+		Resolvers:  graph.NewResolver(auths, users, rooms, messages, moderation, ps),
+		Directives: graph.NewDirectives(users, moderation),
+		Complexity: graph.NewComplexity(),
+	})
+	cfg := opts.Server
+	if cfg.KeepAliveInterval == 0 {
+		cfg.KeepAliveInterval = -1
+	}
+	if cfg.CredentialWatcher == nil {
+		cfg.CredentialWatcher = middleware.CredentialWatcher(auths, moderation)
+	}
+	var limiter *middleware.RateLimiter
+	if opts.RateLimit != nil {
+		limiter = middleware.NewRateLimiter(*opts.RateLimit)
+		cfg.StreamLimiter = limiter.AcquireStream
+	}
+	srv := server.NewServer(schema, cfg)
+	verifier := middleware.WithRevocation(tokens, auths)
+	var handler http.Handler = middleware.AuthMiddleware(verifier, srv)
+	if limiter != nil {
+		srv.Use(limiter)
+		handler = limiter.Middleware(handler)
+	}
+
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	return &Server{Server: ts, Store: s}
+}
+
+// GraphQLURL はGraphQLのエンドポイント
+func (s *Server) GraphQLURL() string {
+	return s.URL + "/graphql"
+}
+
+// Register はユーザーを登録し、アクセストークンを返す
+func (s *Server) Register(t *testing.T, username string) string {
+	t.Helper()
+	var data struct {
+		Register struct {
+			Token string `json:"token"`
+		} `json:"register"`
+	}
+	s.MustDo(t, "", `mutation($u: String!) { register(username: $u, password: "password123") { token } }`,
+		map[string]interface{}{"u": username}, &data)
+	return data.Register.Token
+}
+
+// Response はPOSTで実行した操作のレスポンス
+type Response struct {
+	Status int
+	Header http.Header
+	Data   json.RawMessage   `json:"data"`
+	Errors []json.RawMessage `json:"errors"`
+}
+
+// Do は操作をPOSTで実行する（token が空の場合は未ログイン）
+func (s *Server) Do(t *testing.T, token, query string, variables map[string]interface{}) *Response {
+	t.Helper()
+	req := s.newRequest(t, http.MethodPost, token, query, variables)
+	req.Header.Set("Accept", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	out := &Response{Status: res.StatusCode, Header: res.Header}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		t.Fatalf("decode response %q: %v", body, err)
+	}
+	return out
+}
+
+// MustDo は操作をPOSTで実行し、エラーが無いことを確認して data を out に読み込む
+func (s *Server) MustDo(t *testing.T, token, query string, variables map[string]interface{}, out interface{}) {
+	t.Helper()
+	res := s.Do(t, token, query, variables)
+	if len(res.Errors) > 0 {
+		t.Fatalf("%s: errors %s", query, res.Errors)
+	}
+	if out != nil {
+		if err := json.Unmarshal(res.Data, out); err != nil {
+			t.Fatalf("decode data %s: %v", res.Data, err)
+		}
+	}
+}
+
+// Subscribe は操作をSSE（distinct connections）で実行し、イベントを読み出すストリームを返す
+//
+// header は追加するヘッダー（Last-Event-ID など）
+func (s *Server) Subscribe(t *testing.T, token, query string, variables map[string]interface{}, header http.Header) *Stream {
+	t.Helper()
+	req := s.newRequest(t, http.MethodPost, token, query, variables)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	return Open(t, req)
+}
+
+func (s *Server) newRequest(t *testing.T, method, token, query string, variables map[string]interface{}) *http.Request {
+	t.Helper()
+	body, err := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(method, s.GraphQLURL(), bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+// Event はSSEのイベント1件（コメント行は含まない）
+type Event struct {
+	Event string
+	ID    string
+	Data  json.RawMessage
+}
+
+// Stream は受信中のSSEのレスポンス
+type Stream struct {
+	Response *http.Response
+	events   chan Event
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+// Open はリクエストを送り、SSEのイベントを読み出すストリームを返す（テスト終了時に切断する）
+func Open(t *testing.T, req *http.Request) *Stream {
+	t.Helper()
+	ctx, cancel := context.WithCancel(req.Context())
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		cancel()
+		t.Fatalf("status %d, Content-Type %q: %s", res.StatusCode, ct, body)
+	}
+
+	s := &Stream{Response: res, events: make(chan Event), ctx: ctx, cancel: cancel}
+	go s.read()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// read はレスポンスを読み、イベントごとに events へ送る（切断されたら閉じる）
+func (s *Stream) read() {
+	defer close(s.events)
+	scanner := bufio.NewScanner(s.Response.Body)
+	var event Event
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if event.Event == "" && data == nil {
+				continue
+			}
+			if data != nil {
+				event.Data = json.RawMessage(strings.Join(data, "\n"))
+			}
+			select {
+			case s.events <- event:
+			case <-s.ctx.Done():
+				return
+			}
+			event, data = Event{}, nil
+		case strings.HasPrefix(line, ":"):
+			// コメント（ハートビート）
+		default:
+			field, value, _ := strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+			switch field {
+			case "event":
+				event.Event = value
+			case "id":
+				event.ID = value
+			case "data":
+				data = append(data, value)
+			}
+		}
+	}
+}
+
+// Next は次のイベントを返す（一定時間内に届かなければテストを失敗させる）
+func (s *Stream) Next(t *testing.T) Event {
+	t.Helper()
+	select {
+	case event, ok := <-s.events:
+		if !ok {
+			t.Fatal("stream closed")
+		}
+		return event
+	case <-time.After(eventTimeout):
+		t.Fatal("timed out waiting for an event")
+	}
+	return Event{}
+}
+
+// NextData は次の `next` イベントの data を out に読み込み、イベントを返す
+func (s *Stream) NextData(t *testing.T, out interface{}) Event {
+	t.Helper()
+	event := s.Next(t)
+	if event.Event != "next" {
+		t.Fatalf("event = %q, want next", event.Event)
+	}
+	if out != nil {
+		if err := json.Unmarshal(event.Data, out); err != nil {
+			t.Fatalf("decode %s: %v", event.Data, err)
+		}
+	}
+	return event
+}
+
+// Closed はストリームが一定時間内にサーバーから閉じられることを確認する
+func (s *Stream) Closed(t *testing.T) {
+	t.Helper()
+	select {
+	case event, ok := <-s.events:
+		if ok {
+			t.Fatalf("unexpected event %q: %s", event.Event, event.Data)
+		}
+	case <-time.After(eventTimeout):
+		t.Fatal("stream was not closed")
+	}
+}
+
+// Close は接続を切断する
+func (s *Stream) Close() {
+	s.cancel()
+	s.Response.Body.Close()
+}
+
+// String はログ用の表現
+func (e Event) String() string {
+	return fmt.Sprintf("event=%s id=%s data=%s", e.Event, e.ID, e.Data)
+}
@@ -1,137 +1,303 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
 	"github.com/vektah/gqlparser/v2/gqlerror"
 )
 
 // SSETransport はServer-Sent Eventsトランスポート
-type SSETransport struct{}
+//
+// graphql-sseの2つのモードに対応する
+//   - distinct connections: 操作ごとに Accept: text/event-stream のリクエストを張る
+//   - single connection: 予約したトークンの1本のストリームに全操作を多重化する
+type SSETransport struct {
+	// KeepAliveInterval はハートビート（SSEコメント行）の送信間隔（0以下で無効）
+	KeepAliveInterval time.Duration
+	// CredentialWatcher はリクエストの認証情報が無効になると終了するコンテキストを返す（nilの場合は監視しない）
+	//
+	// サブスクリプションの実行中に監視し、終了した場合は理由（context.Cause）をエラーとして通知してから complete する
+	CredentialWatcher func(ctx context.Context) (context.Context, context.CancelFunc)
+	// StreamLimiter は single connection モードのストリームの予約（PUT）ごとに枠を確保する（nilの場合は制限しない）
+	//
+	// 確保できなければ予約を断る。枠はストリームが閉じる（接続したGETの切断・予約の期限切れ）まで占有する
+	StreamLimiter func(ctx context.Context) (release func(), ok bool)
+
+	streams *sseStreamRegistry
+}
+
+// NewSSETransport は新しいSSETransportを作成
+func NewSSETransport(keepAliveInterval time.Duration) SSETransport {
+	return SSETransport{
+		KeepAliveInterval: keepAliveInterval,
+		streams:           newSSEStreamRegistry(),
+	}
+}
 
 func (t SSETransport) Supports(r *http.Request) bool {
+	// single connectionモード：予約(PUT)とトークン付きリクエストを引き受ける
+	if t.streams != nil {
+		if r.Method == http.MethodPut || streamToken(r) != "" {
+			return true
+		}
+	}
+
 	// Acceptが text/event-stream を含む場合のみSSEへ切り替える
-	accept := strings.ToLower(r.Header.Get("Accept"))
-	return strings.Contains(accept, "text/event-stream")
+	return acceptsEventStream(r)
 }
 
 func (t SSETransport) Do(w http.ResponseWriter, r *http.Request, exec graphql.GraphExecutor) {
-	// SSEとしてのレスポンスヘッダーを設定
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("X-Accel-Buffering", "no")
+	if t.streams != nil {
+		if r.Method == http.MethodPut {
+			t.reserveStream(w, r)
+			return
+		}
+		if token := streamToken(r); token != "" {
+			t.doSingleConnection(w, r, exec, token)
+			return
+		}
+	}
+
+	t.doDistinctConnection(w, r, exec)
+}
 
+// doDistinctConnection は1リクエスト1操作のSSEストリームを処理
+func (t SSETransport) doDistinctConnection(w http.ResponseWriter, r *http.Request, exec graphql.GraphExecutor) {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
 		return
 	}
 
+	// SSEとしてのレスポンスヘッダーを設定
+	setSSEHeaders(w)
+	sw := &sseWriter{w: w, flusher: flusher}
+
+	// エラー時も含め、操作の終了は必ず complete イベントで通知する
+	defer func() {
+		if r.Context().Err() == nil {
+			sw.event("complete", "", nil)
+		}
+	}()
+
+	// responses(ctx) のブロック中もハートビートを送り続ける
+	stopKeepAlive := t.startKeepAlive(sw.comment)
+	defer stopKeepAlive()
+
 	// リクエストボディからGraphQLパラメータを取得
 	params, err := readGraphQLParams(r)
 	if err != nil {
 		log.Printf("[SSE] Request parse error: %v", err)
-		sendSSEError(w, flusher, err)
+		sendSSEError(sw, err)
 		return
 	}
 
+	// 最初のイベントを待たずにヘッダーを送り、クライアントに接続の確立を知らせる
+	// （リクエストボディを読み終える前に書き込むとボディが閉じられるため、読み取りの後で送る）
+	sw.header()
+
+	t.executeOperation(r.Context(), exec, params, r.Header.Get("Last-Event-ID"), func(id string, payload []byte) {
+		// SSE形式で送信
+		sw.event("next", id, payload)
+	})
+}
+
+// executeOperation はGraphQL操作を実行し、送信すべきペイロードを順にemitへ渡す
+//
+// lastEventID はクライアントが再接続時に送った Last-Event-ID で、リゾルバーから参照できる。
+// emit に渡すIDはリゾルバーが SetNextEventID で設定したもの（無ければ空文字）。
+func (t SSETransport) executeOperation(ctx context.Context, exec graphql.GraphExecutor, params graphQLParams, lastEventID string, emit func(id string, payload []byte)) {
+	ctx, op := withSSEOperation(ctx, lastEventID)
+	ctx = withIncrementalDelivery(ctx)
+
 	// GraphQL操作の準備
-	rc, err := exec.CreateOperationContext(r.Context(), &graphql.RawParams{
+	rc, gqlErrors := exec.CreateOperationContext(ctx, &graphql.RawParams{
 		Query:         params.Query,
 		OperationName: params.OperationName,
 		Variables:     params.Variables,
+		Extensions:    params.Extensions,
 	})
-	if err != nil {
-		if gqlErrors, ok := err.(gqlerror.List); ok {
-			// 空のリストは異常系だが、rcが作成されているなら継続する
-			if len(gqlErrors) == 0 && rc != nil {
-				log.Printf("[SSE] CreateOperationContext returned empty error list, continuing")
-			} else {
-				sendSSEGraphQLErrors(w, flusher, gqlErrors)
-				return
-			}
-		} else {
-			log.Printf("[SSE] CreateOperationContext error: %v", err)
-			sendSSEError(w, flusher, err)
-			return
-		}
+	if len(gqlErrors) > 0 {
+		emit("", graphQLErrorsPayload(gqlErrors))
+		return
 	}
+	if params.readOnly && rc.Operation.Operation == ast.Mutation {
+		emit("", errorPayload(fmt.Errorf("mutations are not allowed over GET")))
+		return
+	}
+
+	// クエリ・ミューテーションは hasNext の無い（または false の）レスポンスで完了
+	isSubscription := rc.Operation.Operation == ast.Subscription
 
-	// Subscriptionの場合
-	responses, ctx := exec.DispatchOperation(r.Context(), rc)
-	log.Printf("[SSE] Subscription started")
+	// 認証情報が無効になったら（ログアウトなど）サブスクリプションを打ち切る
+	if isSubscription && t.CredentialWatcher != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		stop := t.watchCredential(ctx, op, cancel)
+		defer stop()
+	}
+
+	responses, dispatchCtx := exec.DispatchOperation(ctx, rc)
+	// 拡張が操作を実行せずに応答した場合（レート制限など）はコンテキストが返らない
+	if dispatchCtx != nil {
+		ctx = dispatchCtx
+	}
+	log.Printf("[SSE] Operation started: %s", rc.Operation.Operation)
 
 	// responses(ctx) が次のイベントまでブロックする前提で待機
-	for {
+	for subsequent := false; ; subsequent = true {
 		select {
 		case <-ctx.Done():
+			if err := op.error(); err != nil {
+				emit("", subscriptionErrorPayload(err))
+			}
 			return
 		default:
 		}
 
 		response := responses(ctx)
 		if response == nil {
+			// リゾルバーが理由を設定して打ち切った場合はエラーとして通知
+			if err := op.error(); err != nil {
+				emit("", subscriptionErrorPayload(err))
+			}
 			return
 		}
 
-		// データもエラーも無いレスポンスは無視
+		// データもエラーも無いレスポンスは無視（設定されたイベントIDも次のイベントに持ち越さない）
 		if response.Data == nil && len(response.Errors) == 0 {
+			op.popEventID()
 			continue
 		}
 
-		data, err := json.Marshal(response)
+		// @defer の後続結果は incremental 形式で送る
+		data, err := marshalResponse(response, subsequent && !isSubscription)
 		if err != nil {
-			sendSSEError(w, flusher, err)
+			emit("", errorPayload(err))
 			return
 		}
 
-		// SSE形式で送信
-		writeSSEEvent(w, flusher, "next", data)
+		emit(op.popEventID(), data)
+
+		if !isSubscription && (response.HasNext == nil || !*response.HasNext) {
+			return
+		}
 	}
 }
 
+// watchCredential は認証情報の監視を開始し、無効になったら理由を設定して cancel を呼ぶ
+func (t SSETransport) watchCredential(ctx context.Context, op *sseOperation, cancel context.CancelFunc) (stop func()) {
+	credential, stopWatch := t.CredentialWatcher(ctx)
+	go func() {
+		select {
+		case <-credential.Done():
+			if cause := context.Cause(credential); !errors.Is(cause, context.Canceled) {
+				op.setError(cause)
+				cancel()
+			}
+		case <-ctx.Done():
+		}
+	}()
+	return stopWatch
+}
+
 type graphQLParams struct {
 	Query         string                 `json:"query"`
 	OperationName string                 `json:"operationName"`
 	Variables     map[string]interface{} `json:"variables"`
+	Extensions    map[string]interface{} `json:"extensions"`
+
+	// GETで受け取った操作は副作用を持たないものに限る
+	readOnly bool
 }
 
+// readGraphQLParams はリクエストからGraphQLパラメータを取得
+//
+// POSTはJSONボディ、GETはクエリ文字列（variables / extensions はURLエンコードされたJSON）から読み取る。
+// extensions.persistedQuery でハッシュを指定した場合はクエリ文字列を省略できる（実行時に解決する）
 func readGraphQLParams(r *http.Request) (graphQLParams, error) {
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		return graphQLParams{}, err
-	}
-
 	var params graphQLParams
-	if err := json.Unmarshal(body, &params); err != nil {
-		return graphQLParams{}, err
+	if r.Method == http.MethodGet {
+		query := r.URL.Query()
+		params.Query = query.Get("query")
+		params.OperationName = query.Get("operationName")
+		params.readOnly = true
+
+		if v := query.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &params.Variables); err != nil {
+				return graphQLParams{}, fmt.Errorf("variables could not be decoded: %w", err)
+			}
+		}
+		if ext := query.Get("extensions"); ext != "" {
+			if err := json.Unmarshal([]byte(ext), &params.Extensions); err != nil {
+				return graphQLParams{}, fmt.Errorf("extensions could not be decoded: %w", err)
+			}
+		}
+	} else {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return graphQLParams{}, err
+		}
+
+		if err := json.Unmarshal(body, &params); err != nil {
+			return graphQLParams{}, err
+		}
 	}
 
-	if strings.TrimSpace(params.Query) == "" {
+	if strings.TrimSpace(params.Query) == "" && !hasPersistedQuery(params.Extensions) {
 		return graphQLParams{}, fmt.Errorf("empty GraphQL query")
 	}
 
 	return params, nil
 }
 
-func sendSSEError(w http.ResponseWriter, flusher http.Flusher, err error) {
+func acceptsEventStream(r *http.Request) bool {
+	accept := strings.ToLower(r.Header.Get("Accept"))
+	return strings.Contains(accept, "text/event-stream")
+}
+
+func setSSEHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+}
+
+func sendSSEError(sw *sseWriter, err error) {
+	// graphql-sse expects errors to be sent as 'next' event, not 'error'
+	sw.event("next", "", errorPayload(err))
+}
+
+func errorPayload(err error) []byte {
 	errData, _ := json.Marshal(map[string]interface{}{
 		"errors": []map[string]interface{}{
 			{"message": err.Error()},
 		},
 	})
-	// graphql-sse expects errors to be sent as 'next' event, not 'error'
-	writeSSEEvent(w, flusher, "next", errData)
+	return errData
+}
+
+func subscriptionErrorPayload(err error) []byte {
+	var gqlErr *gqlerror.Error
+	if errors.As(err, &gqlErr) {
+		return graphQLErrorsPayload(gqlerror.List{gqlErr})
+	}
+	return errorPayload(err)
 }
 
-func sendSSEGraphQLErrors(w http.ResponseWriter, flusher http.Flusher, errors gqlerror.List) {
+func graphQLErrorsPayload(errors gqlerror.List) []byte {
 	log.Printf("[SSE] GraphQL errors: %d", len(errors))
 	for i, e := range errors {
 		log.Printf("[SSE] Error[%d]: %s", i, e.Message)
@@ -159,10 +325,49 @@ func sendSSEGraphQLErrors(w http.ResponseWriter, flusher http.Flusher, errors gq
 	errData, _ := json.Marshal(map[string]interface{}{
 		"errors": errList,
 	})
-	writeSSEEvent(w, flusher, "next", errData)
+	return errData
+}
+
+// sseWriter はイベントとハートビートの書き込みを直列化する
+type sseWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	flusher http.Flusher
+}
+
+func (sw *sseWriter) event(event, id string, data []byte) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	writeSSEEvent(sw.w, sw.flusher, event, id, data)
+}
+
+func (sw *sseWriter) header() {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	sw.flusher.Flush()
+}
+
+func (sw *sseWriter) comment() {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	writeSSEComment(sw.w, sw.flusher)
+}
+
+// writeSSEEvent はイベントを送信（idが空の場合は id 行、dataがnilの場合は data 行を省略）
+func writeSSEEvent(w io.Writer, flusher http.Flusher, event, id string, data []byte) {
+	fmt.Fprintf(w, "event: %s\n", event)
+	if id != "" {
+		fmt.Fprintf(w, "id: %s\n", id)
+	}
+	if data != nil {
+		fmt.Fprintf(w, "data: %s\n", data)
+	}
+	fmt.Fprint(w, "\n")
+	flusher.Flush()
 }
 
-func writeSSEEvent(w http.ResponseWriter, flusher http.Flusher, event string, data []byte) {
-	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+// writeSSEComment はクライアントに無視されるコメント行を送信（接続維持用）
+func writeSSEComment(w io.Writer, flusher http.Flusher) {
+	fmt.Fprint(w, ":\n\n")
 	flusher.Flush()
 }
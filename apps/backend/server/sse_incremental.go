@@ -0,0 +1,186 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+type incrementalDeliveryContextKey struct{}
+
+// withIncrementalDelivery は @defer / @stream の後続結果を受け取れるトランスポートであることをコンテキストに記録
+func withIncrementalDelivery(ctx context.Context) context.Context {
+	return context.WithValue(ctx, incrementalDeliveryContextKey{}, true)
+}
+
+func incrementalDeliveryFromContext(ctx context.Context) bool {
+	ok, _ := ctx.Value(incrementalDeliveryContextKey{}).(bool)
+	return ok
+}
+
+// incrementalPayload は @defer / @stream の後続結果（GraphQL incremental delivery 形式）
+type incrementalPayload struct {
+	Incremental []incrementalResult    `json:"incremental"`
+	HasNext     bool                   `json:"hasNext"`
+	Extensions  map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// incrementalResult は @defer の遅延グループ（data）または @stream の要素（items）
+type incrementalResult struct {
+	Data   json.RawMessage `json:"data,omitempty"`
+	Items  json.RawMessage `json:"items,omitempty"`
+	Path   ast.Path        `json:"path"`
+	Label  string          `json:"label,omitempty"`
+	Errors gqlerror.List   `json:"errors,omitempty"`
+}
+
+// marshalResponse はレスポンスをJSONに変換（後続結果は incremental 形式にする）
+//
+// 後続結果のデータが配列の場合は @stream の要素、オブジェクトの場合は @defer の遅延グループ
+func marshalResponse(response *graphql.Response, subsequent bool) ([]byte, error) {
+	if !subsequent {
+		return json.Marshal(response)
+	}
+
+	result := incrementalResult{
+		Path:   response.Path,
+		Label:  response.Label,
+		Errors: response.Errors,
+	}
+	if data := bytes.TrimSpace(response.Data); len(data) > 0 && data[0] == '[' {
+		result.Items = response.Data
+	} else {
+		result.Data = response.Data
+	}
+	hasNext := response.HasNext != nil && *response.HasNext
+	return json.Marshal(incrementalPayload{
+		Incremental: []incrementalResult{result},
+		HasNext:     hasNext,
+		Extensions:  response.Extensions,
+	})
+}
+
+// deferSupport は @defer / @stream を実行可能な操作だけに残すハンドラー拡張
+//
+// 後続結果を返せるのはSSEで送ったクエリ・ミューテーションのみで、サブスクリプションや
+// 1レスポンスしか返せないトランスポート（POST）では後続結果が読み出されない。
+// それらの操作では @defer / @stream を取り除き、通常どおり1つのレスポンスで返す。
+type deferSupport struct{}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.OperationContextMutator
+} = deferSupport{}
+
+func (deferSupport) ExtensionName() string {
+	return "DeferSupport"
+}
+
+func (deferSupport) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (deferSupport) MutateOperationContext(ctx context.Context, rc *graphql.OperationContext) *gqlerror.Error {
+	if !hasIncrementalDirectives(rc.Doc) {
+		return nil
+	}
+	if rc.Operation.Operation != ast.Subscription && incrementalDeliveryFromContext(ctx) {
+		return nil
+	}
+
+	// ドキュメントはキャッシュされ得るため、コピーしてから書き換える
+	doc := *rc.Doc
+	doc.Fragments = make(ast.FragmentDefinitionList, len(rc.Doc.Fragments))
+	for i, f := range rc.Doc.Fragments {
+		fragment := *f
+		fragment.SelectionSet = stripDefer(f.SelectionSet)
+		doc.Fragments[i] = &fragment
+	}
+	op := *rc.Operation
+	op.SelectionSet = stripDefer(rc.Operation.SelectionSet)
+
+	rc.Doc = &doc
+	rc.Operation = &op
+	return nil
+}
+
+// hasIncrementalDirectives はドキュメントの操作・フラグメントに @defer / @stream があるかを返す
+//
+// 文字列やコメントに含まれる "@defer" は数えない
+func hasIncrementalDirectives(doc *ast.QueryDocument) bool {
+	for _, op := range doc.Operations {
+		if containsDefer(op.SelectionSet) {
+			return true
+		}
+	}
+	for _, f := range doc.Fragments {
+		if containsDefer(f.SelectionSet) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsDefer は選択セット（入れ子を含む）に @defer / @stream があるかを返す
+func containsDefer(set ast.SelectionSet) bool {
+	for _, sel := range set {
+		switch sel := sel.(type) {
+		case *ast.Field:
+			if sel.Directives.ForName("stream") != nil || containsDefer(sel.SelectionSet) {
+				return true
+			}
+		case *ast.InlineFragment:
+			if sel.Directives.ForName("defer") != nil || containsDefer(sel.SelectionSet) {
+				return true
+			}
+		case *ast.FragmentSpread:
+			if sel.Directives.ForName("defer") != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// stripDefer は選択セットから @defer / @stream を取り除いたコピーを返す
+func stripDefer(set ast.SelectionSet) ast.SelectionSet {
+	if set == nil {
+		return nil
+	}
+	out := make(ast.SelectionSet, len(set))
+	for i, sel := range set {
+		switch sel := sel.(type) {
+		case *ast.Field:
+			field := *sel
+			field.Directives = withoutDirective(sel.Directives, "stream")
+			field.SelectionSet = stripDefer(sel.SelectionSet)
+			out[i] = &field
+		case *ast.InlineFragment:
+			fragment := *sel
+			fragment.Directives = withoutDirective(sel.Directives, "defer")
+			fragment.SelectionSet = stripDefer(sel.SelectionSet)
+			out[i] = &fragment
+		case *ast.FragmentSpread:
+			spread := *sel
+			spread.Directives = withoutDirective(sel.Directives, "defer")
+			out[i] = &spread
+		default:
+			out[i] = sel
+		}
+	}
+	return out
+}
+
+func withoutDirective(directives ast.DirectiveList, name string) ast.DirectiveList {
+	var out ast.DirectiveList
+	for _, d := range directives {
+		if d.Name != name {
+			out = append(out, d)
+		}
+	}
+	return out
+}
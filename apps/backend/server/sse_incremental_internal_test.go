@@ -0,0 +1,35 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+func TestHasIncrementalDirectives(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  bool
+	}{
+		{"none", `{ me { id } }`, false},
+		{"string argument", `mutation { sendMessage(roomId: "general", content: "@defer @stream") { id } }`, false},
+		{"comment", "{ me { id } } # @defer", false},
+		{"inline fragment", `{ me { ... @defer { nickname } } }`, true},
+		{"nested field", `{ rooms { members @stream { id } } }`, true},
+		{"fragment spread", `{ me { ...F @defer } } fragment F on User { id }`, true},
+		{"inside fragment definition", `{ me { ...F } } fragment F on User { ... @defer { id } }`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := parser.ParseQuery(&ast.Source{Input: tt.query})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hasIncrementalDirectives(doc); got != tt.want {
+				t.Errorf("hasIncrementalDirectives() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
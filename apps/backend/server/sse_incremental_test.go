@@ -0,0 +1,107 @@
+package server_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/kajidog/graphql-sse-test/apps/backend/server/servertest"
+)
+
+// expectEvents はストリームから want の順に next イベントの data を受け取り、最後に complete で閉じられることを確認する
+func expectEvents(t *testing.T, stream *servertest.Stream, want ...string) {
+	t.Helper()
+	for i, data := range want {
+		if event := stream.NextData(t, nil); string(event.Data) != data {
+			t.Fatalf("event %d data = %s, want %s", i, event.Data, data)
+		}
+	}
+	if event := stream.Next(t); event.Event != "complete" {
+		t.Fatalf("event = %v, want complete", event)
+	}
+	stream.Closed(t)
+}
+
+// createRoom は token のユーザーでルームを作成し、IDを返す（作成者はメンバーになる）
+func createRoom(t *testing.T, srv *servertest.Server, token, name string) string {
+	t.Helper()
+	var out struct {
+		CreateRoom struct{ ID string } `json:"createRoom"`
+	}
+	srv.MustDo(t, token, `mutation($name: String!) { createRoom(name: $name) { id } }`,
+		map[string]interface{}{"name": name}, &out)
+	return out.CreateRoom.ID
+}
+
+func TestDeferOverSSE(t *testing.T) {
+	srv := servertest.New(t, servertest.Options{})
+	token := srv.Register(t, "alice")
+	vars := map[string]interface{}{"id": createRoom(t, srv, token, "one")}
+
+	// 遅延できるのはリゾルバーで解決するフィールド（Room.members）
+	const query = `query($id: ID!) { room(id: $id) { name ... on Room @defer(label: "members") { members { username } } } }`
+
+	// SSEでは初めの結果（遅延したフィールドは null）の後に遅延グループを incremental 形式で送る
+	expectEvents(t, srv.Subscribe(t, token, query, vars, nil),
+		`{"data":{"room":{"name":"one","members":null}},"hasNext":true}`,
+		`{"incremental":[{"data":{"members":[{"username":"alice"}]},"path":["room"],"label":"members"}],"hasNext":false}`,
+	)
+
+	// POSTでは @defer を取り除いて1つのレスポンスで返す
+	res := srv.Do(t, token, query, vars)
+	if len(res.Errors) != 0 {
+		t.Fatalf("errors = %s", res.Errors)
+	}
+	if want := `{"room":{"name":"one","members":[{"username":"alice"}]}}`; string(res.Data) != want {
+		t.Errorf("POST data = %s, want %s", res.Data, want)
+	}
+}
+
+func TestDeferFragmentSpreadOverSSE(t *testing.T) {
+	srv := servertest.New(t, servertest.Options{})
+	token := srv.Register(t, "alice")
+	vars := map[string]interface{}{"id": createRoom(t, srv, token, "one")}
+
+	const query = `
+		query($id: ID!) { room(id: $id) { name ...Members @defer } }
+		fragment Members on Room { members { username } }
+	`
+	expectEvents(t, srv.Subscribe(t, token, query, vars, nil),
+		`{"data":{"room":{"name":"one","members":null}},"hasNext":true}`,
+		`{"incremental":[{"data":{"members":[{"username":"alice"}]},"path":["room"]}],"hasNext":false}`,
+	)
+}
+
+func TestStreamOverSSE(t *testing.T) {
+	srv := servertest.New(t, servertest.Options{})
+	token := srv.Register(t, "alice")
+	for _, name := range []string{"one", "two", "three"} {
+		createRoom(t, srv, token, name)
+	}
+	// 同時刻に作成したルームの順序はIDで決まるため、通常の実行の結果と比べる
+	var list struct {
+		Rooms []struct{ Name string } `json:"rooms"`
+	}
+	srv.MustDo(t, token, `{ rooms { name } }`, nil, &list)
+	if len(list.Rooms) < 3 {
+		t.Fatalf("rooms = %+v, want at least 3 rooms", list.Rooms)
+	}
+
+	const query = `{ rooms @stream(initialCount: 1) { name } }`
+
+	// initialCount 件を初めの結果で送り、残りを1件ずつ items として送る
+	want := []string{fmt.Sprintf(`{"data":{"rooms":[{"name":%q}]},"hasNext":true}`, list.Rooms[0].Name)}
+	for i := 1; i < len(list.Rooms); i++ {
+		want = append(want, fmt.Sprintf(`{"incremental":[{"items":[{"name":%q}],"path":["rooms",%d]}],"hasNext":%t}`,
+			list.Rooms[i].Name, i, i < len(list.Rooms)-1))
+	}
+	expectEvents(t, srv.Subscribe(t, token, query, nil, nil), want...)
+
+	// POSTでは @stream を取り除いて一覧全体を返す
+	res := srv.Do(t, token, query, nil)
+	if len(res.Errors) != 0 {
+		t.Fatalf("errors = %s", res.Errors)
+	}
+	if plain := srv.Do(t, token, `{ rooms { name } }`, nil); string(res.Data) != string(plain.Data) {
+		t.Errorf("POST data = %s, want %s", res.Data, plain.Data)
+	}
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultKeepAliveInterval はハートビート送信間隔のデフォルト値
+//
+// ロードバランサーやプロキシのアイドルタイムアウト（60秒程度が多い）より十分短くする
+const DefaultKeepAliveInterval = 15 * time.Second
+
+// startKeepAlive はKeepAliveIntervalごとにpingを呼び出すゴルーチンを開始し、停止関数を返す
+//
+// 停止関数はゴルーチンの終了を待つため、呼び出し後にレスポンスへ書き込まれることはない
+func (t SSETransport) startKeepAlive(ping func()) (stop func()) {
+	if t.KeepAliveInterval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(t.KeepAliveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				ping()
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"sync"
+)
+
+type operationContextKey struct{}
+
+// sseOperation はSSEで実行中の1つの操作について、リゾルバーとトランスポートの間で状態を受け渡す
+//
+// イベントIDは、リゾルバーが値をチャンネルへ送る直前に積み、トランスポートがレスポンスを1件
+// 書き出すたびに先頭から取り出す。チャンネルの受信1回がレスポンス1件に対応するため、順序がずれることはない。
+type sseOperation struct {
+	lastEventID string
+
+	mu       sync.Mutex
+	eventIDs []string
+	err      error
+}
+
+func withSSEOperation(ctx context.Context, lastEventID string) (context.Context, *sseOperation) {
+	op := &sseOperation{lastEventID: lastEventID}
+	return context.WithValue(ctx, operationContextKey{}, op), op
+}
+
+func sseOperationFromContext(ctx context.Context) (*sseOperation, bool) {
+	op, ok := ctx.Value(operationContextKey{}).(*sseOperation)
+	return op, ok
+}
+
+func (op *sseOperation) pushEventID(id string) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.eventIDs = append(op.eventIDs, id)
+}
+
+func (op *sseOperation) popEventID() string {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	if len(op.eventIDs) == 0 {
+		return ""
+	}
+	id := op.eventIDs[0]
+	op.eventIDs = op.eventIDs[1:]
+	return id
+}
+
+func (op *sseOperation) setError(err error) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	if op.err == nil {
+		op.err = err
+	}
+}
+
+func (op *sseOperation) error() error {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	return op.err
+}
+
+// LastEventIDFromContext は再接続したクライアントが送った Last-Event-ID を取得
+func LastEventIDFromContext(ctx context.Context) (string, bool) {
+	op, ok := sseOperationFromContext(ctx)
+	if !ok || op.lastEventID == "" {
+		return "", false
+	}
+	return op.lastEventID, true
+}
+
+// SetNextEventID はサブスクリプションが次に配信するイベントのIDを設定
+//
+// 値をチャンネルへ送る直前に、送る値ごとに1回呼び出す。SSE以外のトランスポートでは何もしない。
+func SetNextEventID(ctx context.Context, id string) {
+	if op, ok := sseOperationFromContext(ctx); ok {
+		op.pushEventID(id)
+	}
+}
+
+// SetSubscriptionError はサブスクリプションを打ち切る理由を設定
+//
+// チャンネルを閉じる前に呼び出すと、complete の直前にエラーとして `next` イベントで通知される。
+// *gqlerror.Error を渡すと extensions もそのまま送られる。
+func SetSubscriptionError(ctx context.Context, err error) {
+	if op, ok := sseOperationFromContext(ctx); ok {
+		op.setError(err)
+	}
+}
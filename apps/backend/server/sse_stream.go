@@ -0,0 +1,376 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/google/uuid"
+)
+
+// single connectionモードでストリームトークンを受け渡すヘッダー／クエリパラメータ
+const (
+	streamTokenHeader = "X-GraphQL-Event-Stream-Token"
+	streamTokenQuery  = "token"
+)
+
+const (
+	// streamReservationTTL は予約したストリームに接続（GET）されるまでの猶予
+	streamReservationTTL = 30 * time.Second
+	// maxPendingEvents は接続前のストリームに保留できるイベント数（超えたらストリームを破棄する）
+	maxPendingEvents = 256
+)
+
+var (
+	errStreamAlreadyOpen = errors.New("stream already open")
+	errStreamClosed      = errors.New("stream closed")
+)
+
+// streamToken はリクエストからストリームトークンを取得
+func streamToken(r *http.Request) string {
+	if token := r.Header.Get(streamTokenHeader); token != "" {
+		return token
+	}
+	return r.URL.Query().Get(streamTokenQuery)
+}
+
+// sseEvent は送信待ちのSSEイベント
+type sseEvent struct {
+	event string
+	id    string
+	data  []byte
+}
+
+// sseStream はsingle connectionモードで予約された1本のイベントストリーム
+type sseStream struct {
+	token string
+
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+	// ストリーム接続前に発生したイベント（接続時にまとめて送信）
+	pending []sseEvent
+	// 実行中の操作（operationId → キャンセル関数）
+	ops    map[string]context.CancelFunc
+	closed bool
+	// 接続されないまま猶予が過ぎたら予約を破棄するタイマー
+	expiry *time.Timer
+	// release はストリームをレジストリから削除して閉じる
+	release func()
+	// releaseLimit はストリームが占有する同時数の枠を解放する（制限しない場合はnil）
+	releaseLimit func()
+}
+
+// attach はストリームにHTTPレスポンスを接続し、保留中のイベントを送信
+func (s *sseStream) attach(w http.ResponseWriter, flusher http.Flusher) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errStreamClosed
+	}
+	if s.w != nil {
+		return errStreamAlreadyOpen
+	}
+	if s.expiry != nil {
+		s.expiry.Stop()
+	}
+
+	setSSEHeaders(w)
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	s.w = w
+	s.flusher = flusher
+	for _, e := range s.pending {
+		writeSSEEvent(s.w, s.flusher, e.event, e.id, e.data)
+	}
+	s.pending = nil
+	return nil
+}
+
+// send はイベントを送信（未接続の場合は保留）
+//
+// 保留中のイベントが maxPendingEvents を超えた場合はストリームを破棄する
+func (s *sseStream) send(event, id string, data []byte) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	if s.w != nil {
+		writeSSEEvent(s.w, s.flusher, event, id, data)
+		s.mu.Unlock()
+		return
+	}
+	if len(s.pending) < maxPendingEvents {
+		s.pending = append(s.pending, sseEvent{event: event, id: id, data: data})
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+
+	log.Printf("[SSE] Stream dropped: %s (too many pending events)", s.token)
+	s.release()
+}
+
+// acceptsOperation は新しい操作を開始できるかどうか（接続前に保留が溢れそうな場合は受け付けない）
+func (s *sseStream) acceptsOperation() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.closed && len(s.pending) < maxPendingEvents
+}
+
+// keepAlive は接続中であればハートビートを送信
+func (s *sseStream) keepAlive() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.w == nil {
+		return
+	}
+	writeSSEComment(s.w, s.flusher)
+}
+
+// addOperation は操作を登録（同じIDが実行中ならfalse）
+func (s *sseStream) addOperation(opID string, cancel context.CancelFunc) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if _, exists := s.ops[opID]; exists {
+		return false
+	}
+	s.ops[opID] = cancel
+	return true
+}
+
+// stopOperation は操作をキャンセル
+func (s *sseStream) stopOperation(opID string) {
+	s.mu.Lock()
+	cancel, ok := s.ops[opID]
+	delete(s.ops, opID)
+	s.mu.Unlock()
+
+	if ok {
+		cancel()
+	}
+}
+
+// close はストリームを閉じ、実行中の全操作をキャンセル
+func (s *sseStream) close() {
+	s.mu.Lock()
+	ops := s.ops
+	s.ops = make(map[string]context.CancelFunc)
+	s.w = nil
+	s.flusher = nil
+	s.pending = nil
+	s.closed = true
+	if s.expiry != nil {
+		s.expiry.Stop()
+	}
+	s.mu.Unlock()
+
+	for _, cancel := range ops {
+		cancel()
+	}
+	if s.releaseLimit != nil {
+		s.releaseLimit()
+	}
+}
+
+// sseStreamRegistry は予約済みストリームを管理
+type sseStreamRegistry struct {
+	streams map[string]*sseStream
+	mu      sync.Mutex
+}
+
+func newSSEStreamRegistry() *sseStreamRegistry {
+	return &sseStreamRegistry{
+		streams: make(map[string]*sseStream),
+	}
+}
+
+// reserve は新しいストリームを予約してトークンを発行
+//
+// ttl の間に接続されなかった予約は破棄する。releaseLimit はストリームを閉じたときに呼ぶ
+func (reg *sseStreamRegistry) reserve(ttl time.Duration, releaseLimit func()) *sseStream {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	stream := &sseStream{
+		token:        uuid.New().String(),
+		ops:          make(map[string]context.CancelFunc),
+		releaseLimit: releaseLimit,
+	}
+	stream.release = func() { reg.remove(stream.token) }
+	stream.expiry = time.AfterFunc(ttl, func() { reg.expire(stream) })
+	reg.streams[stream.token] = stream
+	return stream
+}
+
+// expire は接続されていないストリームの予約を破棄
+func (reg *sseStreamRegistry) expire(stream *sseStream) {
+	stream.mu.Lock()
+	attached := stream.w != nil
+	if !attached {
+		// 削除するまでの間に接続されないようにする
+		stream.closed = true
+	}
+	stream.mu.Unlock()
+	if attached {
+		return
+	}
+	log.Printf("[SSE] Stream reservation expired: %s", stream.token)
+	reg.remove(stream.token)
+}
+
+// get はトークンに対応するストリームを取得
+func (reg *sseStreamRegistry) get(token string) (*sseStream, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	stream, ok := reg.streams[token]
+	return stream, ok
+}
+
+// remove はストリームを削除して閉じる
+func (reg *sseStreamRegistry) remove(token string) {
+	reg.mu.Lock()
+	stream, ok := reg.streams[token]
+	delete(reg.streams, token)
+	reg.mu.Unlock()
+
+	if ok {
+		stream.close()
+	}
+}
+
+// reserveStream はPUTリクエストでストリームを予約し、トークンを返す
+//
+// StreamLimiter の枠を確保できない場合は 429 を返す
+func (t SSETransport) reserveStream(w http.ResponseWriter, r *http.Request) {
+	var releaseLimit func()
+	if t.StreamLimiter != nil {
+		release, ok := t.StreamLimiter(r.Context())
+		if !ok {
+			http.Error(w, "Too many streams", http.StatusTooManyRequests)
+			return
+		}
+		releaseLimit = release
+	}
+
+	stream := t.streams.reserve(streamReservationTTL, releaseLimit)
+	log.Printf("[SSE] Stream reserved: %s", stream.token)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(stream.token))
+}
+
+// doSingleConnection はトークン付きリクエスト（ストリーム接続・操作開始・操作停止）を処理
+func (t SSETransport) doSingleConnection(w http.ResponseWriter, r *http.Request, exec graphql.GraphExecutor, token string) {
+	stream, ok := t.streams.get(token)
+	if !ok {
+		http.Error(w, "Stream not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodDelete:
+		opID := r.URL.Query().Get("operationId")
+		if opID == "" {
+			http.Error(w, "Operation ID is missing", http.StatusBadRequest)
+			return
+		}
+		stream.stopOperation(opID)
+		w.WriteHeader(http.StatusOK)
+	case acceptsEventStream(r):
+		t.serveStream(w, r, stream)
+	case r.Method == http.MethodPost:
+		t.startOperation(w, r, exec, stream)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveStream は予約済みストリームを接続し、クライアントが切断するまで保持
+func (t SSETransport) serveStream(w http.ResponseWriter, r *http.Request, stream *sseStream) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	if err := stream.attach(w, flusher); err != nil {
+		if errors.Is(err, errStreamClosed) {
+			http.Error(w, "Stream not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Stream already open", http.StatusConflict)
+		return
+	}
+	log.Printf("[SSE] Stream connected: %s", stream.token)
+
+	stopKeepAlive := t.startKeepAlive(stream.keepAlive)
+
+	// 切断されたらストリームと全操作を破棄
+	<-r.Context().Done()
+	stopKeepAlive()
+	t.streams.remove(stream.token)
+	log.Printf("[SSE] Stream closed: %s", stream.token)
+}
+
+// startOperation はPOSTされた操作をストリーム上で開始
+func (t SSETransport) startOperation(w http.ResponseWriter, r *http.Request, exec graphql.GraphExecutor, stream *sseStream) {
+	params, err := readGraphQLParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opID, _ := params.Extensions["operationId"].(string)
+	if opID == "" {
+		http.Error(w, "Operation ID is missing", http.StatusBadRequest)
+		return
+	}
+	// 接続されないまま結果が溜まり続けないようにする
+	if !stream.acceptsOperation() {
+		http.Error(w, "Too many pending events; connect the stream first", http.StatusServiceUnavailable)
+		return
+	}
+
+	// 操作はPOSTのレスポンス後も継続するため、リクエストの値（認証情報など）だけを引き継ぐ
+	ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	if !stream.addOperation(opID, cancel) {
+		cancel()
+		http.Error(w, "Operation with ID already exists", http.StatusConflict)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+
+	go func() {
+		defer stream.stopOperation(opID)
+
+		t.executeOperation(ctx, exec, params, r.Header.Get("Last-Event-ID"), func(id string, payload []byte) {
+			stream.send("next", id, operationMessage(opID, payload))
+		})
+		stream.send("complete", "", operationMessage(opID, nil))
+	}()
+}
+
+// operationMessage はsingle connectionモードのイベントデータ（{id, payload}）を作成
+func operationMessage(opID string, payload []byte) []byte {
+	msg := struct {
+		ID      string          `json:"id"`
+		Payload json.RawMessage `json:"payload,omitempty"`
+	}{
+		ID:      opID,
+		Payload: payload,
+	}
+	data, _ := json.Marshal(msg)
+	return data
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// releaseCounter は releaseLimit の呼び出しを記録する
+type releaseCounter chan struct{}
+
+func (c releaseCounter) release() { c <- struct{}{} }
+
+func (c releaseCounter) wait(t *testing.T) {
+	t.Helper()
+	select {
+	case <-c:
+	case <-time.After(5 * time.Second):
+		t.Fatal("releaseLimit was not called")
+	}
+}
+
+func TestStreamReservationExpiry(t *testing.T) {
+	reg := newSSEStreamRegistry()
+	released := make(releaseCounter, 1)
+	stream := reg.reserve(10*time.Millisecond, released.release)
+
+	// 接続されないまま猶予が過ぎると予約を破棄して枠を解放する
+	released.wait(t)
+	if _, ok := reg.get(stream.token); ok {
+		t.Error("expired stream is still registered")
+	}
+	rec := httptest.NewRecorder()
+	if err := stream.attach(rec, rec); !errors.Is(err, errStreamClosed) {
+		t.Errorf("attach after expiry = %v, want errStreamClosed", err)
+	}
+}
+
+func TestStreamReservationAttachedBeforeExpiry(t *testing.T) {
+	reg := newSSEStreamRegistry()
+	stream := reg.reserve(10*time.Millisecond, nil)
+	t.Cleanup(func() { reg.remove(stream.token) })
+
+	rec := httptest.NewRecorder()
+	if err := stream.attach(rec, rec); err != nil {
+		t.Fatalf("attach: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, ok := reg.get(stream.token); !ok {
+		t.Error("attached stream was removed by the reservation timer")
+	}
+}
+
+func TestStreamPendingEvents(t *testing.T) {
+	reg := newSSEStreamRegistry()
+	stream := reg.reserve(time.Hour, nil)
+	t.Cleanup(func() { reg.remove(stream.token) })
+
+	// 接続前のイベントは保留し、接続したときに順に送る
+	stream.send("next", "1", []byte(`{"id":"a"}`))
+	stream.send("complete", "", []byte(`{"id":"a"}`))
+	rec := httptest.NewRecorder()
+	if err := stream.attach(rec, rec); err != nil {
+		t.Fatalf("attach: %v", err)
+	}
+	want := "event: next\nid: 1\ndata: {\"id\":\"a\"}\n\nevent: complete\ndata: {\"id\":\"a\"}\n\n"
+	if got := rec.Body.String(); got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
+
+func TestStreamPendingEventsLimit(t *testing.T) {
+	reg := newSSEStreamRegistry()
+	released := make(releaseCounter, 1)
+	stream := reg.reserve(time.Hour, released.release)
+
+	for i := 0; i < maxPendingEvents-1; i++ {
+		stream.send("next", "", []byte(`{}`))
+	}
+	if !stream.acceptsOperation() {
+		t.Fatal("operation was refused below the limit")
+	}
+	// 上限に達したら新しい操作を受け付けない
+	stream.send("next", "", []byte(`{}`))
+	if stream.acceptsOperation() {
+		t.Error("operation was accepted at the limit")
+	}
+	if _, ok := reg.get(stream.token); !ok {
+		t.Fatal("stream was dropped at the limit")
+	}
+
+	// 上限を超えたらストリームを破棄する
+	stream.send("next", "", []byte(`{}`))
+	released.wait(t)
+	if _, ok := reg.get(stream.token); ok {
+		t.Error("stream is still registered after overflowing")
+	}
+}
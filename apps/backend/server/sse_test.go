@@ -0,0 +1,288 @@
+package server_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kajidog/graphql-sse-test/apps/backend/server"
+	"github.com/kajidog/graphql-sse-test/apps/backend/server/servertest"
+)
+
+const (
+	messageAdded = `subscription { messageAdded(roomId: "general") { content } }`
+	sendMessage  = `mutation($c: String!) { sendMessage(roomId: "general", content: $c) { id } }`
+)
+
+func send(t *testing.T, srv *servertest.Server, token, content string) {
+	t.Helper()
+	srv.MustDo(t, token, sendMessage, map[string]interface{}{"c": content}, nil)
+}
+
+// streamRequest は single connection モードのストリームトークン付きリクエストを作成
+func streamRequest(t *testing.T, srv *servertest.Server, method, token, streamToken string, body interface{}) *http.Request {
+	t.Helper()
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, srv.GraphQLURL(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-GraphQL-Event-Stream-Token", streamToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+// status はリクエストを送ってステータスコードを返す
+func status(t *testing.T, req *http.Request) int {
+	t.Helper()
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+	return res.StatusCode
+}
+
+// reserve はPUTでストリームを予約し、トークンを返す
+func reserve(t *testing.T, srv *servertest.Server) string {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPut, srv.GraphQLURL(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode != http.StatusCreated || len(body) == 0 {
+		t.Fatalf("PUT = %d %q, want 201 and a token", res.StatusCode, body)
+	}
+	return string(body)
+}
+
+// operation は single connection モードのイベントデータ
+type operation struct {
+	ID      string          `json:"id"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+func TestSingleConnection(t *testing.T) {
+	srv := servertest.New(t, servertest.Options{})
+	token := srv.Register(t, "alice")
+	streamToken := reserve(t, srv)
+
+	start := func(opID, query string, header http.Header) int {
+		t.Helper()
+		req := streamRequest(t, srv, http.MethodPost, token, streamToken, map[string]interface{}{
+			"query":      query,
+			"extensions": map[string]interface{}{"operationId": opID},
+		})
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		return status(t, req)
+	}
+
+	// 接続前に開始した操作の結果は保留され、接続したときにまとめて届く
+	send(t, srv, token, "m0")
+	if got := start("sub", messageAdded, http.Header{"Last-Event-ID": {"1"}}); got != http.StatusAccepted {
+		t.Fatalf("POST sub = %d, want 202", got)
+	}
+	if got := start("query", `{ me { username } }`, nil); got != http.StatusAccepted {
+		t.Fatalf("POST query = %d, want 202", got)
+	}
+	if got := start("sub", messageAdded, nil); got != http.StatusConflict {
+		t.Errorf("POST with a running operationId = %d, want 409", got)
+	}
+	if got := start("", messageAdded, nil); got != http.StatusBadRequest {
+		t.Errorf("POST without operationId = %d, want 400", got)
+	}
+	send(t, srv, token, "first")
+
+	get := streamRequest(t, srv, http.MethodGet, token, streamToken, nil)
+	get.Header.Set("Accept", "text/event-stream")
+	stream := servertest.Open(t, get)
+
+	// 操作ごとのイベントは operationId で振り分けられる（操作間の順序は決まらない）
+	want := map[string]string{
+		"next query":     `{"data":{"me":{"username":"alice"}}}`,
+		"complete query": ``,
+		"next sub":       `{"data":{"messageAdded":{"content":"first"}}}`,
+	}
+	for len(want) > 0 {
+		event := stream.Next(t)
+		var op operation
+		if err := json.Unmarshal(event.Data, &op); err != nil {
+			t.Fatalf("decode %v: %v", event, err)
+		}
+		key := event.Event + " " + op.ID
+		payload, ok := want[key]
+		if !ok {
+			t.Fatalf("unexpected event %v", event)
+		}
+		if string(op.Payload) != payload {
+			t.Errorf("%s payload = %s, want %s", key, op.Payload, payload)
+		}
+		if key == "next sub" && event.ID != "2" {
+			t.Errorf("next sub id = %q, want 2", event.ID)
+		}
+		delete(want, key)
+	}
+
+	// 同じトークンで2本目のストリームは張れない
+	second := streamRequest(t, srv, http.MethodGet, token, streamToken, nil)
+	second.Header.Set("Accept", "text/event-stream")
+	if got := status(t, second); got != http.StatusConflict {
+		t.Errorf("second GET = %d, want 409", got)
+	}
+
+	// DELETE で停止した操作は complete で終わる
+	del := streamRequest(t, srv, http.MethodDelete, token, streamToken, nil)
+	del.URL.RawQuery = url.Values{"operationId": {"sub"}}.Encode()
+	if got := status(t, del); got != http.StatusOK {
+		t.Fatalf("DELETE = %d, want 200", got)
+	}
+	if event := stream.Next(t); event.Event != "complete" || string(event.Data) != `{"id":"sub"}` {
+		t.Fatalf("event = %v, want complete of sub", event)
+	}
+
+	// ストリームを切断すると予約も破棄される
+	stream.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for start("after", `{ me { id } }`, nil) != http.StatusNotFound {
+		if time.Now().After(deadline) {
+			t.Fatal("stream was not removed after disconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSingleConnectionUnknownToken(t *testing.T) {
+	srv := servertest.New(t, servertest.Options{})
+	req := streamRequest(t, srv, http.MethodPost, "", "unknown", map[string]interface{}{
+		"query":      `{ rooms { id } }`,
+		"extensions": map[string]interface{}{"operationId": "op"},
+	})
+	if got := status(t, req); got != http.StatusNotFound {
+		t.Errorf("POST = %d, want 404", got)
+	}
+}
+
+// readComment はレスポンスからコメント行（ハートビート）が届くまで読む
+func readComment(t *testing.T, body io.Reader) {
+	t.Helper()
+	found := make(chan bool, 1)
+	go func() {
+		scanner := bufio.NewScanner(body)
+		for scanner.Scan() {
+			if strings.HasPrefix(scanner.Text(), ":") {
+				found <- true
+				return
+			}
+		}
+		found <- false
+	}()
+	select {
+	case ok := <-found:
+		if !ok {
+			t.Fatal("stream closed before a heartbeat")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a heartbeat")
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	srv := servertest.New(t, servertest.Options{Server: server.Config{KeepAliveInterval: 10 * time.Millisecond}})
+	token := srv.Register(t, "alice")
+
+	t.Run("distinct connection", func(t *testing.T) {
+		// イベントが無い間もハートビートを送る
+		stream := srv.Subscribe(t, token, messageAdded, nil, nil)
+		readComment(t, stream.Response.Body)
+	})
+
+	t.Run("single connection", func(t *testing.T) {
+		get := streamRequest(t, srv, http.MethodGet, token, reserve(t, srv), nil)
+		get.Header.Set("Accept", "text/event-stream")
+		res, err := http.DefaultClient.Do(get)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		readComment(t, res.Body)
+	})
+}
+
+func TestLastEventIDReplay(t *testing.T) {
+	srv := servertest.New(t, servertest.Options{})
+	token := srv.Register(t, "alice")
+	for _, content := range []string{"a", "b", "c"} {
+		send(t, srv, token, content)
+	}
+
+	// Last-Event-ID より後のメッセージを、メッセージの連番をIDとして再送する
+	stream := srv.Subscribe(t, token, messageAdded, nil, http.Header{"Last-Event-ID": {"1"}})
+	for _, want := range []struct{ id, content string }{{"2", "b"}, {"3", "c"}} {
+		var payload struct {
+			Data struct {
+				MessageAdded struct{ Content string } `json:"messageAdded"`
+			} `json:"data"`
+		}
+		event := stream.NextData(t, &payload)
+		if event.ID != want.id || payload.Data.MessageAdded.Content != want.content {
+			t.Errorf("event = %v, want id %s with %q", event, want.id, want.content)
+		}
+	}
+}
+
+func TestGET(t *testing.T) {
+	srv := servertest.New(t, servertest.Options{})
+	token := srv.Register(t, "alice")
+
+	get := func(query string) *servertest.Stream {
+		t.Helper()
+		u := srv.GraphQLURL() + "?" + url.Values{"query": {query}}.Encode()
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", "text/event-stream")
+		req.Header.Set("Authorization", "Bearer "+token)
+		return servertest.Open(t, req)
+	}
+
+	expectEvents(t, get(`{ me { username } }`), `{"data":{"me":{"username":"alice"}}}`)
+
+	// ミューテーションはGETでは実行しない
+	expectEvents(t, get(`mutation { updateNickname(nickname: "Alice") { id } }`),
+		`{"errors":[{"message":"mutations are not allowed over GET"}]}`)
+	var me struct {
+		Me struct{ Nickname string } `json:"me"`
+	}
+	srv.MustDo(t, token, `{ me { nickname } }`, nil, &me)
+	if me.Me.Nickname != "alice" {
+		t.Errorf("nickname = %q, want unchanged", me.Me.Nickname)
+	}
+}
@@ -0,0 +1,244 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kajidog/graphql-sse-test/apps/backend/auth"
+	"github.com/kajidog/graphql-sse-test/apps/backend/graph/model"
+	"github.com/kajidog/graphql-sse-test/apps/backend/pubsub"
+	"github.com/kajidog/graphql-sse-test/apps/backend/store"
+)
+
+// DefaultRefreshTokenTTL はリフレッシュトークンの有効期間のデフォルト値
+const DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+
+// ErrInvalidRefreshToken はリフレッシュトークンが不正・期限切れ・失効済み
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+// AuthService はログインとセッション（アクセストークン・リフレッシュトークン）を管理
+//
+// アクセストークンは短命で、期限が切れたらリフレッシュトークンで再発行する。
+// リフレッシュトークンは使うたびに新しいものに置き換わり（ローテーション）、
+// 置き換え済みのトークンが使われた場合は漏洩とみなしてセッションを失効させる。
+type AuthService interface {
+	Register(username, password, nickname string) (*model.AuthPayload, error)
+	Login(username, password string) (*model.AuthPayload, error)
+	Refresh(refreshToken string) (*model.AuthPayload, error)
+	Logout(userID, sessionID string) error
+	LogoutAll(userID string) error
+	IsRevoked(sessionID string) bool
+	WatchSession(sessionID string) (context.Context, context.CancelFunc)
+}
+
+type authService struct {
+	store      store.Store
+	pubsub     pubsub.PubSub
+	users      UserService
+	tokens     *auth.TokenManager
+	refreshTTL time.Duration
+	// セッションの作成・リフレッシュ・失効を直列化する
+	// （同じリフレッシュトークンで二重に発行しない、失効したセッションをリフレッシュで上書きしない）
+	mu sync.Mutex
+}
+
+// NewAuthService は新しいAuthServiceを作成
+//
+// refreshTTL が0以下の場合は DefaultRefreshTokenTTL を使う
+func NewAuthService(s store.Store, ps pubsub.PubSub, users UserService, tokens *auth.TokenManager, refreshTTL time.Duration) AuthService {
+	if refreshTTL <= 0 {
+		refreshTTL = DefaultRefreshTokenTTL
+	}
+	return &authService{store: s, pubsub: ps, users: users, tokens: tokens, refreshTTL: refreshTTL}
+}
+
+// Register はユーザーを登録し、そのままログインした新しいセッションのトークンを発行
+func (s *authService) Register(username, password, nickname string) (*model.AuthPayload, error) {
+	user, err := s.users.Register(username, password, nickname)
+	if err != nil {
+		return nil, err
+	}
+	return s.startSession(user)
+}
+
+// Login はユーザー名とパスワードを照合し、新しいセッションのトークンを発行
+func (s *authService) Login(username, password string) (*model.AuthPayload, error) {
+	user, err := s.users.Authenticate(username, password)
+	if err != nil {
+		return nil, err
+	}
+	return s.startSession(user)
+}
+
+// startSession はユーザーの新しいセッションを作成してトークンを発行（利用停止中のユーザーは ErrAccountBanned）
+func (s *authService) startSession(user *model.User) (*model.AuthPayload, error) {
+	// 利用停止（LogoutAll）と並行しても、停止後に有効なセッションが残らないようにする
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := requireNotBanned(s.store, user.ID); err != nil {
+		return nil, err
+	}
+	session := &model.Session{
+		ID:        uuid.New().String(),
+		UserID:    user.ID,
+		CreatedAt: time.Now().Format(time.RFC3339),
+	}
+	return s.issue(session, user)
+}
+
+// Refresh はリフレッシュトークンを検証し、アクセストークンと新しいリフレッシュトークンを発行
+func (s *authService) Refresh(refreshToken string) (*model.AuthPayload, error) {
+	sessionID, secret, ok := strings.Cut(refreshToken, ".")
+	if !ok || sessionID == "" || secret == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.store.GetSession(sessionID)
+	if !ok || session.Revoked() {
+		return nil, ErrInvalidRefreshToken
+	}
+	if expiresAt, err := time.Parse(time.RFC3339, session.ExpiresAt); err != nil || !time.Now().Before(expiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if subtle.ConstantTimeCompare([]byte(hashRefreshSecret(secret)), []byte(session.RefreshTokenHash)) != 1 {
+		// 置き換え済みのトークンの再利用は漏洩の可能性があるため、セッションごと失効させる
+		if err := s.revoke(session); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidRefreshToken
+	}
+
+	user, ok := s.users.GetUser(session.UserID)
+	if !ok {
+		return nil, ErrInvalidRefreshToken
+	}
+	updated := *session
+	return s.issue(&updated, user)
+}
+
+// Logout はセッションを失効させる（本人のセッションのみ）
+func (s *authService) Logout(userID, sessionID string) error {
+	if sessionID == "" {
+		return fmt.Errorf("no session to log out")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.store.GetSession(sessionID)
+	if !ok || session.UserID != userID {
+		return fmt.Errorf("session not found")
+	}
+	return s.revoke(session)
+}
+
+// LogoutAll はユーザーの全セッションを失効させる
+func (s *authService) LogoutAll(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, session := range s.store.ListUserSessions(userID) {
+		if err := s.revoke(session); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsRevoked はセッションが失効済みかどうか（存在しないセッションも失効済みとみなす）
+func (s *authService) IsRevoked(sessionID string) bool {
+	session, ok := s.store.GetSession(sessionID)
+	return !ok || session.Revoked()
+}
+
+// WatchSession はセッションが失効すると auth.ErrSessionRevoked を理由に終了するコンテキストを返す
+//
+// 監視が不要になったら返された CancelFunc を呼ぶこと
+func (s *authService) WatchSession(sessionID string) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	sub := s.pubsub.Subscribe(ctx, sessionRevokedTopic(sessionID))
+	go func() {
+		select {
+		case _, ok := <-sub.Events():
+			if ok {
+				cancel(auth.ErrSessionRevoked)
+			}
+		case <-ctx.Done():
+		}
+	}()
+	// 購読を始める前に失効していた場合
+	if s.IsRevoked(sessionID) {
+		cancel(auth.ErrSessionRevoked)
+	}
+	return ctx, func() { cancel(context.Canceled) }
+}
+
+// issue はセッションのリフレッシュトークンを新しくして保存し、アクセストークンとともに返す（ロックを取得済みであること）
+func (s *authService) issue(session *model.Session, user *model.User) (*model.AuthPayload, error) {
+	secret, err := newRefreshSecret()
+	if err != nil {
+		return nil, err
+	}
+	session.RefreshTokenHash = hashRefreshSecret(secret)
+	session.ExpiresAt = time.Now().Add(s.refreshTTL).Format(time.RFC3339)
+	if err := s.store.SaveSession(session); err != nil {
+		return nil, err
+	}
+
+	token, expiresAt, err := s.tokens.Issue(user.ID, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &model.AuthPayload{
+		Token:        token,
+		ExpiresAt:    expiresAt.Format(time.RFC3339),
+		RefreshToken: session.ID + "." + secret,
+		User:         user,
+	}, nil
+}
+
+// revoke はセッションを失効させ、そのセッションの購読に通知（ロックを取得済みであること）
+func (s *authService) revoke(session *model.Session) error {
+	if session.Revoked() {
+		return nil
+	}
+	revokedAt := time.Now().Format(time.RFC3339)
+	revoked := *session
+	revoked.RevokedAt = &revokedAt
+	if err := s.store.SaveSession(&revoked); err != nil {
+		return err
+	}
+	s.pubsub.Publish(sessionRevokedTopic(session.ID), session.ID)
+	return nil
+}
+
+// newRefreshSecret はリフレッシュトークンの秘密部分（256ビットの乱数）を作成
+func newRefreshSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate refresh token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashRefreshSecret はストアに保存するリフレッシュトークンのハッシュ
+//
+// 十分な長さの乱数なので、パスワードと違いソルトやストレッチングは不要
+func hashRefreshSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,96 @@
+package service_test
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kajidog/graphql-sse-test/apps/backend/auth"
+	"github.com/kajidog/graphql-sse-test/apps/backend/graph/model"
+	"github.com/kajidog/graphql-sse-test/apps/backend/pubsub"
+	"github.com/kajidog/graphql-sse-test/apps/backend/service"
+	"github.com/kajidog/graphql-sse-test/apps/backend/store"
+)
+
+// pausingStore は有効なセッションの保存（リフレッシュ）を1回だけ resume が閉じられるまで止める
+type pausingStore struct {
+	store.Store
+	armed  atomic.Bool
+	paused chan struct{}
+	resume chan struct{}
+}
+
+func (s *pausingStore) SaveSession(session *model.Session) error {
+	if session.RevokedAt == nil && s.armed.CompareAndSwap(true, false) {
+		close(s.paused)
+		<-s.resume
+	}
+	return s.Store.SaveSession(session)
+}
+
+func TestLogoutDuringRefresh(t *testing.T) {
+	tests := map[string]func(authService service.AuthService, userID, sessionID string) error{
+		"Logout": func(authService service.AuthService, userID, sessionID string) error {
+			return authService.Logout(userID, sessionID)
+		},
+		"LogoutAll": func(authService service.AuthService, userID, _ string) error {
+			return authService.LogoutAll(userID)
+		},
+	}
+	for name, logout := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := &pausingStore{Store: store.NewMemoryStore(), paused: make(chan struct{}), resume: make(chan struct{})}
+			ps := pubsub.NewMemoryPubSub()
+			if err := service.NewRoomService(s, ps).EnsureDefaultRoom(); err != nil {
+				t.Fatal(err)
+			}
+			tokens, err := auth.NewTokenManager(auth.Config{Secret: []byte(strings.Repeat("k", 32))})
+			if err != nil {
+				t.Fatal(err)
+			}
+			authService := service.NewAuthService(s, ps, service.NewUserService(s), tokens, 0)
+			registered, err := authService.Register("alice", "password123", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			userID := registered.User.ID
+			sessionID, _, _ := strings.Cut(registered.RefreshToken, ".")
+
+			// リフレッシュが失効前のセッションを読み、保存する直前で止まっている間にログアウトする
+			s.armed.Store(true)
+			refreshed := make(chan *model.AuthPayload, 1)
+			go func() {
+				payload, _ := authService.Refresh(registered.RefreshToken)
+				refreshed <- payload
+			}()
+			<-s.paused
+			loggedOut := make(chan error, 1)
+			go func() { loggedOut <- logout(authService, userID, sessionID) }()
+			var logoutErr error
+			select {
+			case logoutErr = <-loggedOut:
+				t.Error("logout finished while a refresh of the session was in progress")
+				close(s.resume)
+			case <-time.After(100 * time.Millisecond):
+				close(s.resume)
+				logoutErr = <-loggedOut
+			}
+			if logoutErr != nil {
+				t.Fatal(logoutErr)
+			}
+
+			payload := <-refreshed
+			if !authService.IsRevoked(sessionID) {
+				t.Fatal("session is active after logout")
+			}
+			if payload == nil {
+				t.Fatal("refresh started before logout failed")
+			}
+			if _, err := authService.Refresh(payload.RefreshToken); !errors.Is(err, service.ErrInvalidRefreshToken) {
+				t.Errorf("Refresh after logout = %v, want ErrInvalidRefreshToken", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+
+	"github.com/kajidog/graphql-sse-test/apps/backend/graph/model"
+	"github.com/kajidog/graphql-sse-test/apps/backend/pubsub"
+	"github.com/kajidog/graphql-sse-test/apps/backend/store"
+)
+
+// EventSubscription はルームのイベントの購読
+type EventSubscription struct {
+	// Events は発生順にイベントを受け取るチャンネル（購読終了時に閉じられる）
+	Events <-chan model.ChatEvent
+
+	sub *pubsub.Subscription
+	// err は購読側で打ち切った理由（Events が閉じられる前に設定される）
+	err error
+}
+
+// Err は購読が打ち切られた理由を返す（通常の終了ではnil）
+//
+// Events が閉じられた後に呼ぶこと
+func (s *EventSubscription) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.sub.Err()
+}
+
+// SubscribeEvents はルームの全イベント（メッセージの追加・編集・削除、メンバーの参加・退出）を1つの購読で配信
+//
+// lastSeq が0より大きい場合は、それより後に追加されたメッセージを MessageAdded として再送してからライブ配信に切り替える。
+// 編集・削除・参加・退出は再送しない（切断中の変更はクエリで取り直す）。
+// バッファ溢れでイベントが捨てられた場合は補完できないため、購読を打ち切る。
+// ユーザーがルームから抜けると ErrNotRoomMember で購読を終了する。
+func (s *messageService) SubscribeEvents(ctx context.Context, userID, roomID string, lastSeq int64, opts ...pubsub.SubscribeOption) (*EventSubscription, error) {
+	if err := requireNotBanned(s.store, userID); err != nil {
+		return nil, err
+	}
+	if _, err := requireMember(s.store, roomID, userID); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	// 保存・配信の予約と排他にすることで、再送分とライブ配信の境界に隙間ができない
+	// （購読の開始前に保存され、開始後に配信されたメッセージは連番で除く）
+	s.mu.Lock()
+	sub := s.pubsub.Subscribe(ctx, roomEventsTopic(roomID), s.options(opts)...)
+	var missed []*model.Message
+	if lastSeq > 0 {
+		missed = s.store.ListMessages(store.MessageRange{RoomID: roomID, After: lastSeq})
+	} else if latest := s.store.ListMessages(store.MessageRange{Limit: 1, FromEnd: true}); len(latest) > 0 {
+		lastSeq = latest[0].Seq
+	}
+	s.mu.Unlock()
+
+	out := make(chan model.ChatEvent)
+	es := &EventSubscription{Events: out, sub: sub}
+
+	go func() {
+		defer close(out)
+		defer cancel()
+
+		send := func(event model.ChatEvent) bool {
+			select {
+			case out <- event:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		last := lastSeq
+		for _, msg := range missed {
+			if !send(&model.MessageAdded{Message: msg}) {
+				return
+			}
+			last = msg.Seq
+		}
+
+		for {
+			select {
+			case event, ok := <-sub.Events():
+				if !ok || event.Err != nil {
+					return
+				}
+				if sub.Dropped() > 0 {
+					es.err = pubsub.ErrSlowConsumer
+					return
+				}
+				chatEvent, err := s.chatEvent(roomID, userID, event)
+				if err != nil {
+					es.err = err
+					return
+				}
+				if chatEvent == nil {
+					continue
+				}
+				if added, ok := chatEvent.(*model.MessageAdded); ok {
+					if added.Message.Seq <= last {
+						// 再送済み
+						continue
+					}
+					last = added.Message.Seq
+				}
+				if !send(chatEvent) {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return es, nil
+}
+
+// chatEvent はルームのトピックに届いたイベントを購読者向けのイベントに変換
+//
+// 購読者自身が退出した場合は ErrNotRoomMember を返す。対象外のイベントは nil を返す
+func (s *messageService) chatEvent(roomID, userID string, event pubsub.Event) (model.ChatEvent, error) {
+	switch {
+	case event.Topic == roomMessageAddedTopic(roomID):
+		if msg, ok := event.Payload.(*model.Message); ok {
+			return &model.MessageAdded{Message: msg}, nil
+		}
+	case event.Topic == roomMessageUpdatedTopic(roomID):
+		if msg, ok := event.Payload.(*model.Message); ok {
+			return &model.MessageEdited{Message: msg}, nil
+		}
+	case event.Topic == roomMessageDeletedTopic(roomID):
+		if msg, ok := event.Payload.(*model.Message); ok {
+			return &model.MessageDeleted{Message: msg}, nil
+		}
+	case pubsub.MatchTopic(roomMemberJoinedTopic(roomID, pubsub.WildcardSegment), event.Topic):
+		room, user, ok := s.roomAndUser(roomID, event.Payload)
+		if ok {
+			return &model.UserJoined{Room: room, User: user}, nil
+		}
+	case pubsub.MatchTopic(roomMemberLeftTopic(roomID, pubsub.WildcardSegment), event.Topic):
+		if event.Payload == userID {
+			return nil, ErrNotRoomMember
+		}
+		room, user, ok := s.roomAndUser(roomID, event.Payload)
+		if ok {
+			return &model.UserLeft{Room: room, User: user}, nil
+		}
+	}
+	return nil, nil
+}
+
+// roomAndUser はメンバーのイベントのルームとユーザー（ペイロードはユーザーID）を取得
+func (s *messageService) roomAndUser(roomID string, payload interface{}) (*model.Room, *model.User, bool) {
+	userID, ok := payload.(string)
+	if !ok {
+		return nil, nil, false
+	}
+	room, ok := s.store.GetRoom(roomID)
+	if !ok {
+		return nil, nil, false
+	}
+	user, ok := s.store.GetUser(userID)
+	if !ok {
+		return nil, nil, false
+	}
+	return room, user, true
+}
@@ -1,7 +1,11 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -11,56 +15,435 @@ import (
 )
 
 // MessageService はメッセージ関連のビジネスロジックを提供
+//
+// 全ての操作はルーム単位で、ルームのメンバーであることを確認する
 type MessageService interface {
-	SendMessage(userID, content string) (*model.Message, error)
-	GetMessages() []*model.Message
-	Subscribe(id string) chan *model.Message
-	Unsubscribe(id string)
+	SendMessage(userID, roomID, content string) (*model.Message, error)
+	GetMessage(id string) (*model.Message, bool)
+	GetMessages(userID, roomID string) ([]*model.Message, error)
+	ListMessages(userID, roomID string, args PageArgs) (*model.MessageConnection, error)
+	Subscribe(ctx context.Context, userID, roomID string, lastSeq int64, opts ...pubsub.SubscribeOption) (*MessageSubscription, error)
+	SubscribeDirect(ctx context.Context, userID string, lastSeq int64, opts ...pubsub.SubscribeOption) (*MessageSubscription, error)
+	EditMessage(userID, id, content string) (*model.Message, error)
+	DeleteMessage(userID, id string) (*model.Message, error)
+	TakedownMessage(id string) (*model.Message, error)
+	ListEdits(messageID string) []*model.MessageEdit
+	SubscribeUpdated(ctx context.Context, userID, roomID string, opts ...pubsub.SubscribeOption) (*MessageSubscription, error)
+	SubscribeDeleted(ctx context.Context, userID, roomID string, opts ...pubsub.SubscribeOption) (*MessageSubscription, error)
+	SubscribeEvents(ctx context.Context, userID, roomID string, lastSeq int64, opts ...pubsub.SubscribeOption) (*EventSubscription, error)
+}
+
+// ErrMessageNotFound はメッセージが存在しない
+var ErrMessageNotFound = store.ErrMessageNotFound
+
+// ErrMessageDeleted は削除済みのメッセージを編集しようとした
+var ErrMessageDeleted = store.ErrMessageDeleted
+
+// MessageSubscription はメッセージの購読
+type MessageSubscription struct {
+	// Messages は連番順にメッセージを受け取るチャンネル（購読終了時に閉じられる）
+	Messages <-chan *model.Message
+
+	sub *pubsub.Subscription
+	// err は購読側で打ち切った理由（Messages が閉じられる前に設定される）
+	err error
+}
+
+// Err は購読が打ち切られた理由を返す（通常の終了ではnil）
+//
+// Messages が閉じられた後に呼ぶこと
+func (s *MessageSubscription) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.sub.Err()
+}
+
+// Dropped はバッファ溢れで捨てられたイベント数を返す（捨てられた分はストアから補完される）
+func (s *MessageSubscription) Dropped() uint64 {
+	return s.sub.Dropped()
 }
 
 type messageService struct {
 	store  store.Store
 	pubsub pubsub.PubSub
+	// 保存した順に配信する（配信はロックの外で行う）
+	publisher *orderedPublisher
+	// 購読ごとのバッファサイズ・溢れたときの動作のデフォルト
+	subscribeOptions []pubsub.SubscribeOption
+	// 保存と配信の予約を直列化し、連番順に配信されることを保証する
+	mu sync.Mutex
 }
 
 // NewMessageService は新しいMessageServiceを作成
-func NewMessageService(s store.Store, ps pubsub.PubSub) MessageService {
+//
+// opts は購読のデフォルトの設定で、購読ごとに指定した設定で上書きできる
+func NewMessageService(s store.Store, ps pubsub.PubSub, opts ...pubsub.SubscribeOption) MessageService {
 	return &messageService{
-		store:  s,
-		pubsub: ps,
+		store:            s,
+		pubsub:           ps,
+		publisher:        newOrderedPublisher(ps),
+		subscribeOptions: opts,
 	}
 }
 
-// SendMessage はメッセージを送信し、全サブスクライバーに配信
-func (s *messageService) SendMessage(userID, content string) (*model.Message, error) {
-	user, exists := s.store.GetUser(userID)
-	if !exists {
+// options はデフォルトの購読の設定に購読ごとの設定を重ねる
+func (s *messageService) options(opts []pubsub.SubscribeOption) []pubsub.SubscribeOption {
+	return append(append([]pubsub.SubscribeOption{}, s.subscribeOptions...), opts...)
+}
+
+// SendMessage はメッセージを送信し、ルームのサブスクライバーに配信
+func (s *messageService) SendMessage(userID, roomID, content string) (*model.Message, error) {
+	if _, exists := s.store.GetUser(userID); !exists {
 		return nil, fmt.Errorf("user not found")
 	}
+	if err := requireCanPost(s.store, userID); err != nil {
+		return nil, err
+	}
+	room, err := requireMember(s.store, roomID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if room.Archived() {
+		return nil, ErrRoomArchived
+	}
 
 	msg := &model.Message{
 		ID:        uuid.New().String(),
-		User:      user,
+		RoomID:    roomID,
+		UserID:    userID,
 		Content:   content,
 		CreatedAt: time.Now().Format(time.RFC3339),
 	}
-	s.store.SaveMessage(msg)
-	s.pubsub.Publish(msg)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.store.SaveMessage(msg); err != nil {
+		return nil, err
+	}
+	s.publisher.Publish(roomMessageAddedTopic(roomID), msg)
+	if room.IsDirect() {
+		// ダイレクトメッセージは参加者それぞれにも配信
+		for _, id := range s.store.ListRoomMembers(roomID) {
+			s.publisher.Publish(userDirectMessageTopic(id), msg)
+		}
+	}
 
 	return msg, nil
 }
 
-// GetMessages は全メッセージを取得
-func (s *messageService) GetMessages() []*model.Message {
-	return s.store.GetMessages()
+// GetMessage はIDでメッセージを取得（ルームのメンバーかどうかは確認しない）
+func (s *messageService) GetMessage(id string) (*model.Message, bool) {
+	return s.store.GetMessage(id)
+}
+
+// GetMessages はルームの全メッセージを取得
+func (s *messageService) GetMessages(userID, roomID string) ([]*model.Message, error) {
+	if _, err := requireMember(s.store, roomID, userID); err != nil {
+		return nil, err
+	}
+	return s.store.ListMessages(store.MessageRange{RoomID: roomID}), nil
+}
+
+// ListMessages はルームのメッセージを連番順にページ単位で取得
+func (s *messageService) ListMessages(userID, roomID string, args PageArgs) (*model.MessageConnection, error) {
+	if _, err := requireMember(s.store, roomID, userID); err != nil {
+		return nil, err
+	}
+	r, limit, err := args.messageRange()
+	if err != nil {
+		return nil, err
+	}
+	r.RoomID = roomID
+	messages := s.store.ListMessages(r)
+	return newMessageConnection(messages, r, limit, s.store.CountMessages(roomID)), nil
+}
+
+// EditMessage はメッセージの本文を編集し、ルームのサブスクライバーに配信
+//
+// 編集できるのは送信者かルームの作成者（モデレーター）のみ。削除済みのメッセージは編集できない。
+// 発言停止中のユーザーは編集できない。
+func (s *messageService) EditMessage(userID, id, content string) (*model.Message, error) {
+	if err := requireCanPost(s.store, userID); err != nil {
+		return nil, err
+	}
+	if _, err := s.modifiableMessage(userID, id); err != nil {
+		return nil, err
+	}
+
+	// 削除と並行した場合も、削除済みかどうかはストアが更新時に確かめる（ErrMessageDeleted）
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	edited, err := s.store.EditMessage(id, content, time.Now().Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	s.publisher.Publish(roomMessageUpdatedTopic(edited.RoomID), edited)
+	return edited, nil
+}
+
+// DeleteMessage はメッセージを削除して墓標にし、ルームのサブスクライバーに配信
+//
+// 権限は EditMessage と同じ。削除済みのメッセージはそのまま返す。
+func (s *messageService) DeleteMessage(userID, id string) (*model.Message, error) {
+	msg, err := s.modifiableMessage(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Deleted() {
+		return msg, nil
+	}
+	return s.delete(id)
+}
+
+// TakedownMessage はモデレーションとしてメッセージを削除し、ルームのサブスクライバーに配信
+//
+// ルームのメンバーでなくても、アーカイブ済みのルームでも削除できる（権限の確認は呼び出し側で行う）
+func (s *messageService) TakedownMessage(id string) (*model.Message, error) {
+	msg, ok := s.store.GetMessage(id)
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	if msg.Deleted() {
+		return nil, fmt.Errorf("message is already deleted")
+	}
+	return s.delete(id)
+}
+
+// delete はメッセージを墓標にして削除イベントを配信
+func (s *messageService) delete(id string) (*model.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deleted, err := s.store.DeleteMessage(id, time.Now().Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	s.publisher.Publish(roomMessageDeletedTopic(deleted.RoomID), deleted)
+	return deleted, nil
 }
 
-// Subscribe はサブスクリプションを開始
-func (s *messageService) Subscribe(id string) chan *model.Message {
-	return s.pubsub.Subscribe(id)
+// ListEdits はメッセージの編集履歴を古い順に取得
+func (s *messageService) ListEdits(messageID string) []*model.MessageEdit {
+	return s.store.ListMessageEdits(messageID)
 }
 
-// Unsubscribe はサブスクリプションを終了
-func (s *messageService) Unsubscribe(id string) {
-	s.pubsub.Unsubscribe(id)
+// modifiableMessage はユーザーが編集・削除できるメッセージを取得
+func (s *messageService) modifiableMessage(userID, id string) (*model.Message, error) {
+	msg, ok := s.store.GetMessage(id)
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	room, err := requireMember(s.store, msg.RoomID, userID)
+	if errors.Is(err, ErrRoomNotFound) {
+		// 見えないルームのメッセージは存在しないものとして扱う
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if room.Archived() {
+		return nil, ErrRoomArchived
+	}
+	// ルームの作成者と MODERATOR 以上のロールのユーザーはモデレーターとして他人のメッセージも扱える
+	if msg.UserID != userID && room.CreatedBy != userID && !hasRole(s.store, userID, model.RoleModerator) {
+		return nil, fmt.Errorf("only the author or a moderator can modify the message")
+	}
+	return msg, nil
+}
+
+// Subscribe はルームの購読を開始し、ctxが終了するまでメッセージを連番順に配信
+//
+// lastSeq が0より大きい場合は、それより後のメッセージをストアから再送してからライブ配信に切り替える。
+// 連番で重複を除き、バッファ溢れで捨てられた分はストアから補完するため、重複も抜けも発生しない。
+// ユーザーがルームから抜けると ErrNotRoomMember で購読を終了する。利用停止中のユーザーは購読できない。
+func (s *messageService) Subscribe(ctx context.Context, userID, roomID string, lastSeq int64, opts ...pubsub.SubscribeOption) (*MessageSubscription, error) {
+	if err := requireNotBanned(s.store, userID); err != nil {
+		return nil, err
+	}
+	if _, err := requireMember(s.store, roomID, userID); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	left := s.pubsub.Subscribe(ctx, roomMemberLeftTopic(roomID, userID))
+	after := func(seq int64) []*model.Message {
+		return s.store.ListMessages(store.MessageRange{RoomID: roomID, After: seq})
+	}
+	return s.stream(ctx, cancel, roomMessageAddedTopic(roomID), lastSeq, after, left.Events(), s.options(opts)), nil
+}
+
+// SubscribeDirect はユーザーが参加している全てのダイレクトメッセージの購読を開始
+//
+// 再送・補完の動作は Subscribe と同じ
+func (s *messageService) SubscribeDirect(ctx context.Context, userID string, lastSeq int64, opts ...pubsub.SubscribeOption) (*MessageSubscription, error) {
+	if _, exists := s.store.GetUser(userID); !exists {
+		return nil, fmt.Errorf("user not found")
+	}
+	if err := requireNotBanned(s.store, userID); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	after := func(seq int64) []*model.Message {
+		var messages []*model.Message
+		for _, room := range s.store.ListUserRooms(userID) {
+			if room.IsDirect() {
+				messages = append(messages, s.store.ListMessages(store.MessageRange{RoomID: room.ID, After: seq})...)
+			}
+		}
+		sort.Slice(messages, func(i, j int) bool { return messages[i].Seq < messages[j].Seq })
+		return messages
+	}
+	return s.stream(ctx, cancel, userDirectMessageTopic(userID), lastSeq, after, nil, s.options(opts)), nil
+}
+
+// SubscribeUpdated はルームのメッセージ編集イベントの購読を開始
+//
+// 編集は再送せず、購読開始後のイベントのみ配信する（切断中の変更はクエリで取り直す）。
+// バッファ溢れでイベントが捨てられた場合は補完できないため、購読を打ち切る。
+func (s *messageService) SubscribeUpdated(ctx context.Context, userID, roomID string, opts ...pubsub.SubscribeOption) (*MessageSubscription, error) {
+	return s.watch(ctx, userID, roomID, roomMessageUpdatedTopic(roomID), s.options(opts))
+}
+
+// SubscribeDeleted はルームのメッセージ削除イベントの購読を開始
+//
+// 動作は SubscribeUpdated と同じ
+func (s *messageService) SubscribeDeleted(ctx context.Context, userID, roomID string, opts ...pubsub.SubscribeOption) (*MessageSubscription, error) {
+	return s.watch(ctx, userID, roomID, roomMessageDeletedTopic(roomID), s.options(opts))
+}
+
+// watch はルームのトピックを購読し、届いたメッセージをそのまま配信する
+func (s *messageService) watch(ctx context.Context, userID, roomID, topic string, opts []pubsub.SubscribeOption) (*MessageSubscription, error) {
+	if err := requireNotBanned(s.store, userID); err != nil {
+		return nil, err
+	}
+	if _, err := requireMember(s.store, roomID, userID); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	left := s.pubsub.Subscribe(ctx, roomMemberLeftTopic(roomID, userID))
+	live, sub := pubsub.SubscribeTyped[*model.Message](ctx, s.pubsub, topic, opts...)
+
+	out := make(chan *model.Message)
+	ms := &MessageSubscription{Messages: out, sub: sub}
+
+	go func() {
+		defer close(out)
+		defer cancel()
+
+		for {
+			select {
+			case msg, ok := <-live:
+				if !ok {
+					return
+				}
+				if sub.Dropped() > 0 {
+					ms.err = pubsub.ErrSlowConsumer
+					return
+				}
+				select {
+				case out <- msg:
+				case <-ctx.Done():
+					return
+				}
+			case _, ok := <-left.Events():
+				if ok {
+					ms.err = ErrNotRoomMember
+				}
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ms, nil
+}
+
+// stream はトピックを購読し、再送・重複除去・欠落の補完をしながら連番順に配信する
+//
+// after は指定した連番より後の購読対象のメッセージをストアから取得する。
+// left にイベントが届くと ErrNotRoomMember で購読を終了する（nilの場合は無視）。
+func (s *messageService) stream(
+	ctx context.Context,
+	cancel context.CancelFunc,
+	topic string,
+	lastSeq int64,
+	after func(seq int64) []*model.Message,
+	left <-chan pubsub.Event,
+	opts []pubsub.SubscribeOption,
+) *MessageSubscription {
+	// 保存・配信の予約と排他にすることで、再送分とライブ配信の境界に隙間ができない
+	// （購読の開始前に保存され、開始後に配信されたものは連番で除く）
+	s.mu.Lock()
+	live, sub := pubsub.SubscribeTyped[*model.Message](ctx, s.pubsub, topic, opts...)
+	var missed []*model.Message
+	if lastSeq > 0 {
+		missed = after(lastSeq)
+	} else if latest := s.store.ListMessages(store.MessageRange{Limit: 1, FromEnd: true}); len(latest) > 0 {
+		// 新規購読では購読開始時点の最新メッセージを起点にする
+		lastSeq = latest[0].Seq
+	}
+	s.mu.Unlock()
+
+	out := make(chan *model.Message)
+	ms := &MessageSubscription{Messages: out, sub: sub}
+
+	go func() {
+		defer close(out)
+		defer cancel()
+
+		last := lastSeq
+		send := func(msg *model.Message) bool {
+			select {
+			case out <- msg:
+				last = msg.Seq
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for _, msg := range missed {
+			if !send(msg) {
+				return
+			}
+		}
+
+		var dropped uint64
+		for {
+			select {
+			case msg, ok := <-live:
+				if !ok {
+					return
+				}
+				if d := sub.Dropped(); d != dropped {
+					// 捨てられた分（受け取ったものより新しい場合もある）をストアから補完
+					dropped = d
+					for _, m := range after(last) {
+						if !send(m) {
+							return
+						}
+					}
+				}
+				if msg.Seq <= last {
+					// 再送済み
+					continue
+				}
+				if !send(msg) {
+					return
+				}
+			case _, ok := <-left:
+				if ok {
+					ms.err = ErrNotRoomMember
+				}
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ms
 }
@@ -0,0 +1,254 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kajidog/graphql-sse-test/apps/backend/auth"
+	"github.com/kajidog/graphql-sse-test/apps/backend/graph/model"
+	"github.com/kajidog/graphql-sse-test/apps/backend/pubsub"
+	"github.com/kajidog/graphql-sse-test/apps/backend/store"
+)
+
+var (
+	// ErrAccountBanned はユーザーが利用停止（BAN）中
+	ErrAccountBanned = auth.ErrAccountBanned
+	// ErrUserMuted はユーザーが発言停止（MUTE）中
+	ErrUserMuted = errors.New("user is muted")
+)
+
+// MaxModerationLogLimit は監査ログを一度に取得できる最大件数
+const MaxModerationLogLimit = 200
+
+// ModerationService はモデレーション（利用停止・発言停止・メッセージの削除）を提供
+//
+// 全ての操作は監査ログに記録する
+type ModerationService interface {
+	Ban(actorID, userID string, reason *string, duration time.Duration) (*model.ModerationAction, error)
+	Unban(actorID, userID string, reason *string) (*model.ModerationAction, error)
+	Mute(actorID, userID string, reason *string, duration time.Duration) (*model.ModerationAction, error)
+	Unmute(actorID, userID string, reason *string) (*model.ModerationAction, error)
+	TakedownMessage(actorID, messageID string, reason *string) (*model.ModerationAction, error)
+	IsBanned(userID string) bool
+	ListActions(targetUserID string, limit int) ([]*model.ModerationAction, error)
+	WatchBan(userID string) (context.Context, context.CancelFunc)
+}
+
+type moderationService struct {
+	store    store.Store
+	pubsub   pubsub.PubSub
+	auth     AuthService
+	messages MessageService
+}
+
+// NewModerationService は新しいModerationServiceを作成
+func NewModerationService(s store.Store, ps pubsub.PubSub, auths AuthService, messages MessageService) ModerationService {
+	return &moderationService{store: s, pubsub: ps, auth: auths, messages: messages}
+}
+
+// Ban はユーザーを利用停止にし、全てのセッションを失効させる
+//
+// duration が0の場合は解除するまで続く。開いている購読は利用停止の通知で終了する
+func (s *moderationService) Ban(actorID, userID string, reason *string, duration time.Duration) (*model.ModerationAction, error) {
+	action, err := s.sanction(actorID, userID, model.SanctionBan, reason, duration)
+	if err != nil {
+		return nil, err
+	}
+	// セッションの失効より先に通知し、購読の終了理由を利用停止にする
+	s.pubsub.Publish(userBannedTopic(userID), userID)
+	if err := s.auth.LogoutAll(userID); err != nil {
+		return nil, err
+	}
+	return action, nil
+}
+
+// Unban はユーザーの利用停止を解除
+func (s *moderationService) Unban(actorID, userID string, reason *string) (*model.ModerationAction, error) {
+	return s.lift(actorID, userID, model.SanctionBan, reason)
+}
+
+// Mute はユーザーを発言停止にする（閲覧はできる）
+//
+// duration が0の場合は解除するまで続く
+func (s *moderationService) Mute(actorID, userID string, reason *string, duration time.Duration) (*model.ModerationAction, error) {
+	return s.sanction(actorID, userID, model.SanctionMute, reason, duration)
+}
+
+// Unmute はユーザーの発言停止を解除
+func (s *moderationService) Unmute(actorID, userID string, reason *string) (*model.ModerationAction, error) {
+	return s.lift(actorID, userID, model.SanctionMute, reason)
+}
+
+// TakedownMessage はメッセージを削除して墓標にする（ルームのメンバーでなくても削除できる）
+func (s *moderationService) TakedownMessage(actorID, messageID string, reason *string) (*model.ModerationAction, error) {
+	msg, err := s.messages.TakedownMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+	return s.record(&model.ModerationAction{
+		Action:       model.ModerationActionKindTakedown,
+		ModeratorID:  actorID,
+		TargetUserID: msg.UserID,
+		MessageID:    &msg.ID,
+		Reason:       reason,
+	})
+}
+
+// IsBanned はユーザーが利用停止中かどうか
+func (s *moderationService) IsBanned(userID string) bool {
+	_, banned := activeSanction(s.store, userID, model.SanctionBan, time.Now())
+	return banned
+}
+
+// ListActions は監査ログを新しい順に取得（targetUserID が空の場合は全ユーザー）
+func (s *moderationService) ListActions(targetUserID string, limit int) ([]*model.ModerationAction, error) {
+	if limit <= 0 || limit > MaxModerationLogLimit {
+		return nil, fmt.Errorf("limit must be between 1 and %d", MaxModerationLogLimit)
+	}
+	return s.store.ListModerationActions(targetUserID, limit), nil
+}
+
+// WatchBan はユーザーが利用停止になると auth.ErrAccountBanned を理由に終了するコンテキストを返す
+//
+// 監視が不要になったら返された CancelFunc を呼ぶこと
+func (s *moderationService) WatchBan(userID string) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	sub := s.pubsub.Subscribe(ctx, userBannedTopic(userID))
+	go func() {
+		select {
+		case _, ok := <-sub.Events():
+			if ok {
+				cancel(auth.ErrAccountBanned)
+			}
+		case <-ctx.Done():
+		}
+	}()
+	// 購読を始める前に利用停止になっていた場合
+	if s.IsBanned(userID) {
+		cancel(auth.ErrAccountBanned)
+	}
+	return ctx, func() { cancel(context.Canceled) }
+}
+
+// sanction はユーザーに制裁を科して記録する（既にある同じ種類の制裁は置き換える）
+func (s *moderationService) sanction(actorID, userID string, kind model.SanctionKind, reason *string, duration time.Duration) (*model.ModerationAction, error) {
+	if duration < 0 {
+		return nil, fmt.Errorf("duration must not be negative")
+	}
+	if err := s.requireOutranks(actorID, userID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	sanction := &model.Sanction{
+		UserID:    userID,
+		Kind:      kind,
+		CreatedBy: actorID,
+		CreatedAt: now.Format(time.RFC3339),
+	}
+	if reason != nil {
+		sanction.Reason = *reason
+	}
+	if duration > 0 {
+		expiresAt := now.Add(duration).Format(time.RFC3339)
+		sanction.ExpiresAt = &expiresAt
+	}
+	if err := s.store.SaveSanction(sanction); err != nil {
+		return nil, err
+	}
+
+	action := model.ModerationActionKindBan
+	if kind == model.SanctionMute {
+		action = model.ModerationActionKindMute
+	}
+	return s.record(&model.ModerationAction{
+		Action:       action,
+		ModeratorID:  actorID,
+		TargetUserID: userID,
+		Reason:       reason,
+		ExpiresAt:    sanction.ExpiresAt,
+	})
+}
+
+// lift はユーザーの制裁を解除して記録する
+func (s *moderationService) lift(actorID, userID string, kind model.SanctionKind, reason *string) (*model.ModerationAction, error) {
+	if err := s.requireOutranks(actorID, userID); err != nil {
+		return nil, err
+	}
+	if _, ok := activeSanction(s.store, userID, kind, time.Now()); !ok {
+		return nil, fmt.Errorf("user has no active %s", kind)
+	}
+	if err := s.store.DeleteSanction(userID, kind); err != nil {
+		return nil, err
+	}
+
+	action := model.ModerationActionKindUnban
+	if kind == model.SanctionMute {
+		action = model.ModerationActionKindUnmute
+	}
+	return s.record(&model.ModerationAction{
+		Action:       action,
+		ModeratorID:  actorID,
+		TargetUserID: userID,
+		Reason:       reason,
+	})
+}
+
+// requireOutranks は操作するユーザーが対象のユーザーより上位のロールであることを確認
+//
+// モデレーター同士や管理者に対しては制裁できない
+func (s *moderationService) requireOutranks(actorID, userID string) error {
+	actor, ok := s.store.GetUser(actorID)
+	if !ok {
+		return fmt.Errorf("user not found")
+	}
+	target, ok := s.store.GetUser(userID)
+	if !ok {
+		return fmt.Errorf("user not found: %s", userID)
+	}
+	if !actor.Role.Outranks(target.Role) {
+		return fmt.Errorf("cannot moderate a user with the %s role", target.Role)
+	}
+	return nil
+}
+
+// record は監査ログに操作を追加
+func (s *moderationService) record(action *model.ModerationAction) (*model.ModerationAction, error) {
+	action.ID = uuid.New().String()
+	action.CreatedAt = time.Now().Format(time.RFC3339)
+	if err := s.store.SaveModerationAction(action); err != nil {
+		return nil, err
+	}
+	return action, nil
+}
+
+// activeSanction はユーザーに now の時点で有効な制裁を取得
+func activeSanction(s store.Store, userID string, kind model.SanctionKind, now time.Time) (*model.Sanction, bool) {
+	sanction, ok := s.GetSanction(userID, kind)
+	if !ok || !sanction.ActiveAt(now) {
+		return nil, false
+	}
+	return sanction, true
+}
+
+// requireNotBanned はユーザーが利用停止中でないことを確認
+func requireNotBanned(s store.Store, userID string) error {
+	if _, banned := activeSanction(s, userID, model.SanctionBan, time.Now()); banned {
+		return ErrAccountBanned
+	}
+	return nil
+}
+
+// requireCanPost はユーザーが利用停止・発言停止中でないことを確認
+func requireCanPost(s store.Store, userID string) error {
+	if err := requireNotBanned(s, userID); err != nil {
+		return err
+	}
+	if _, muted := activeSanction(s, userID, model.SanctionMute, time.Now()); muted {
+		return ErrUserMuted
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kajidog/graphql-sse-test/apps/backend/graph/model"
+	"github.com/kajidog/graphql-sse-test/apps/backend/store"
+)
+
+const (
+	// DefaultPageSize は first / last が省略された場合の件数
+	DefaultPageSize = 50
+	// MaxPageSize は first / last に指定できる最大件数
+	MaxPageSize = 100
+
+	messageCursorPrefix = "message:"
+)
+
+// PageArgs はRelay Connectionのページ指定
+type PageArgs struct {
+	First  *int
+	After  *string
+	Last   *int
+	Before *string
+}
+
+// EncodeMessageCursor はメッセージの連番から不透明なカーソルを作成
+func EncodeMessageCursor(seq int64) string {
+	return base64.StdEncoding.EncodeToString([]byte(messageCursorPrefix + strconv.FormatInt(seq, 10)))
+}
+
+// decodeMessageCursor はカーソルからメッセージの連番を取り出す
+func decodeMessageCursor(cursor string) (int64, error) {
+	data, err := base64.StdEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(data), messageCursorPrefix) {
+		return 0, fmt.Errorf("invalid cursor: %s", cursor)
+	}
+	seq, err := strconv.ParseInt(strings.TrimPrefix(string(data), messageCursorPrefix), 10, 64)
+	if err != nil || seq <= 0 {
+		return 0, fmt.Errorf("invalid cursor: %s", cursor)
+	}
+	return seq, nil
+}
+
+// messageRange はページ指定をストアの範囲指定に変換
+//
+// 前後のページの有無を判定するため、Limitは要求件数より1件多くする
+func (args PageArgs) messageRange() (store.MessageRange, int, error) {
+	if args.First != nil && args.Last != nil {
+		return store.MessageRange{}, 0, fmt.Errorf("first and last cannot be used together")
+	}
+
+	var r store.MessageRange
+	if args.After != nil {
+		seq, err := decodeMessageCursor(*args.After)
+		if err != nil {
+			return store.MessageRange{}, 0, err
+		}
+		r.After = seq
+	}
+	if args.Before != nil {
+		seq, err := decodeMessageCursor(*args.Before)
+		if err != nil {
+			return store.MessageRange{}, 0, err
+		}
+		r.Before = seq
+	}
+
+	limit := DefaultPageSize
+	switch {
+	case args.First != nil:
+		limit = *args.First
+	case args.Last != nil:
+		limit = *args.Last
+		r.FromEnd = true
+	}
+	if limit < 0 {
+		return store.MessageRange{}, 0, fmt.Errorf("first and last must be non-negative")
+	}
+	if limit > MaxPageSize {
+		return store.MessageRange{}, 0, fmt.Errorf("first and last must not exceed %d", MaxPageSize)
+	}
+	r.Limit = limit + 1
+	return r, limit, nil
+}
+
+// newMessageConnection は取得したメッセージからConnectionを組み立てる
+//
+// messagesは要求件数より1件多く取得したもの。はみ出した1件で進行方向のページの有無を判定する。
+// 逆方向のページの有無は、カーソルが指定されていればその先に要素があるものとみなす。
+func newMessageConnection(messages []*model.Message, r store.MessageRange, limit, totalCount int) *model.MessageConnection {
+	pageInfo := &model.PageInfo{}
+	hasMore := len(messages) > limit
+	if r.FromEnd {
+		if hasMore {
+			messages = messages[len(messages)-limit:]
+		}
+		pageInfo.HasPreviousPage = hasMore
+		pageInfo.HasNextPage = r.Before > 0
+	} else {
+		if hasMore {
+			messages = messages[:limit]
+		}
+		pageInfo.HasNextPage = hasMore
+		pageInfo.HasPreviousPage = r.After > 0
+	}
+
+	edges := make([]*model.MessageEdge, len(messages))
+	for i, msg := range messages {
+		edges[i] = &model.MessageEdge{Cursor: EncodeMessageCursor(msg.Seq), Node: msg}
+	}
+	if len(edges) > 0 {
+		pageInfo.StartCursor = &edges[0].Cursor
+		pageInfo.EndCursor = &edges[len(edges)-1].Cursor
+	}
+
+	return &model.MessageConnection{
+		Edges:      edges,
+		PageInfo:   pageInfo,
+		TotalCount: totalCount,
+	}
+}
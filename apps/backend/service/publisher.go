@@ -0,0 +1,64 @@
+package service
+
+import (
+	"sync"
+
+	"github.com/kajidog/graphql-sse-test/apps/backend/pubsub"
+)
+
+// publication は配信待ちのイベント
+type publication struct {
+	topic   string
+	payload interface{}
+}
+
+// orderedPublisher は受け付けた順にイベントを配信する
+//
+// 配信は専用のゴルーチンで行うため、Block ポリシーの遅いサブスクライバーがいても
+// 呼び出し側（保存の排他ロックを持っている）は待たされない
+type orderedPublisher struct {
+	pubsub pubsub.PubSub
+
+	mu     sync.Mutex
+	queue  []publication
+	notify chan struct{}
+}
+
+func newOrderedPublisher(ps pubsub.PubSub) *orderedPublisher {
+	p := &orderedPublisher{
+		pubsub: ps,
+		notify: make(chan struct{}, 1),
+	}
+	go p.run()
+	return p
+}
+
+// Publish は配信を予約する（配信の完了は待たない）
+func (p *orderedPublisher) Publish(topic string, payload interface{}) {
+	p.mu.Lock()
+	p.queue = append(p.queue, publication{topic: topic, payload: payload})
+	p.mu.Unlock()
+
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+}
+
+// run は予約された順にイベントを配信する
+func (p *orderedPublisher) run() {
+	for range p.notify {
+		for {
+			p.mu.Lock()
+			queue := p.queue
+			p.queue = nil
+			p.mu.Unlock()
+			if len(queue) == 0 {
+				break
+			}
+			for _, pub := range queue {
+				p.pubsub.Publish(pub.topic, pub.payload)
+			}
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kajidog/graphql-sse-test/apps/backend/graph/model"
+	"github.com/kajidog/graphql-sse-test/apps/backend/pubsub"
+	"github.com/kajidog/graphql-sse-test/apps/backend/store"
+)
+
+var (
+	// ErrRoomNotFound はルームが存在しない
+	ErrRoomNotFound = errors.New("room not found")
+	// ErrNotRoomMember はルームのメンバーではない
+	ErrNotRoomMember = errors.New("not a member of the room")
+	// ErrRoomArchived はルームがアーカイブ済みで変更できない
+	ErrRoomArchived = errors.New("room is archived")
+)
+
+// MaxDirectParticipants はダイレクトメッセージの会話に参加できる最大人数（作成者を含む）
+const MaxDirectParticipants = 8
+
+// RoomService はルーム関連のビジネスロジックを提供
+type RoomService interface {
+	EnsureDefaultRoom() error
+	CreateRoom(userID, name string) (*model.Room, error)
+	CreateDirectRoom(userID string, participantIDs []string) (*model.Room, error)
+	GetRoom(id string) (*model.Room, bool)
+	FindRoom(userID, id string) (*model.Room, bool)
+	ListRooms(includeArchived bool) []*model.Room
+	ListDirectRooms(userID string) []*model.Room
+	JoinRoom(userID, roomID string) (*model.Room, error)
+	LeaveRoom(userID, roomID string) error
+	ArchiveRoom(userID, roomID string) (*model.Room, error)
+	Members(roomID string) []string
+}
+
+type roomService struct {
+	store  store.Store
+	pubsub pubsub.PubSub
+}
+
+// NewRoomService は新しいRoomServiceを作成
+func NewRoomService(s store.Store, ps pubsub.PubSub) RoomService {
+	return &roomService{store: s, pubsub: ps}
+}
+
+// EnsureDefaultRoom は既定のルームがなければ作成
+func (s *roomService) EnsureDefaultRoom() error {
+	if _, ok := s.store.GetRoom(model.DefaultRoomID); ok {
+		return nil
+	}
+	return s.store.SaveRoom(&model.Room{
+		ID:        model.DefaultRoomID,
+		Kind:      model.RoomKindChannel,
+		Name:      model.DefaultRoomID,
+		CreatedAt: time.Now().Format(time.RFC3339),
+	})
+}
+
+// CreateRoom はルームを作成し、作成者をメンバーに追加
+func (s *roomService) CreateRoom(userID, name string) (*model.Room, error) {
+	if _, exists := s.store.GetUser(userID); !exists {
+		return nil, fmt.Errorf("user not found")
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("room name must not be empty")
+	}
+
+	room := &model.Room{
+		ID:        uuid.New().String(),
+		Kind:      model.RoomKindChannel,
+		Name:      name,
+		CreatedBy: userID,
+		CreatedAt: time.Now().Format(time.RFC3339),
+	}
+	if err := s.store.SaveRoom(room); err != nil {
+		return nil, err
+	}
+	if err := s.store.AddRoomMember(room.ID, userID); err != nil {
+		return nil, err
+	}
+	return room, nil
+}
+
+// CreateDirectRoom は作成者と指定したユーザーのダイレクトメッセージの会話を作成
+//
+// 同じ参加者の会話が既にあればそれを返す
+func (s *roomService) CreateDirectRoom(userID string, participantIDs []string) (*model.Room, error) {
+	seen := map[string]bool{userID: true}
+	ids := []string{userID}
+	for _, id := range participantIDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) < 2 {
+		return nil, fmt.Errorf("direct room needs at least one other participant")
+	}
+	if len(ids) > MaxDirectParticipants {
+		return nil, fmt.Errorf("direct room can have at most %d participants", MaxDirectParticipants)
+	}
+
+	nicknames := make([]string, len(ids))
+	for i, id := range ids {
+		user, exists := s.store.GetUser(id)
+		if !exists {
+			return nil, fmt.Errorf("user not found: %s", id)
+		}
+		nicknames[i] = user.Nickname
+	}
+
+	roomID := directRoomID(ids)
+	if room, ok := s.store.GetRoom(roomID); ok {
+		return room, nil
+	}
+
+	room := &model.Room{
+		ID:        roomID,
+		Kind:      model.RoomKindDirect,
+		Name:      strings.Join(nicknames, ", "),
+		CreatedBy: userID,
+		CreatedAt: time.Now().Format(time.RFC3339),
+	}
+	if err := s.store.SaveRoom(room); err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if err := s.store.AddRoomMember(room.ID, id); err != nil {
+			return nil, err
+		}
+	}
+	return room, nil
+}
+
+// directRoomID は参加者の組み合わせから会話のIDを決める（参加者の順序によらない）
+func directRoomID(userIDs []string) string {
+	sorted := append([]string(nil), userIDs...)
+	sort.Strings(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+	return "dm-" + hex.EncodeToString(sum[:16])
+}
+
+// GetRoom はIDでルームを取得
+func (s *roomService) GetRoom(id string) (*model.Room, bool) {
+	return s.store.GetRoom(id)
+}
+
+// FindRoom はユーザーから見えるルームを取得（ダイレクトメッセージは参加者のみ）
+func (s *roomService) FindRoom(userID, id string) (*model.Room, bool) {
+	room, ok := s.store.GetRoom(id)
+	if !ok {
+		return nil, false
+	}
+	if room.IsDirect() && (userID == "" || !s.store.IsRoomMember(id, userID)) {
+		return nil, false
+	}
+	return room, true
+}
+
+// ListRooms はルーム一覧を取得（includeArchivedがfalseの場合はアーカイブ済みを除く）
+//
+// ダイレクトメッセージの会話は含まない
+func (s *roomService) ListRooms(includeArchived bool) []*model.Room {
+	rooms := s.store.ListRooms()
+	channels := make([]*model.Room, 0, len(rooms))
+	for _, r := range rooms {
+		if r.IsDirect() || (r.Archived() && !includeArchived) {
+			continue
+		}
+		channels = append(channels, r)
+	}
+	return channels
+}
+
+// ListDirectRooms はユーザーが参加しているダイレクトメッセージの会話を取得
+func (s *roomService) ListDirectRooms(userID string) []*model.Room {
+	rooms := s.store.ListUserRooms(userID)
+	direct := make([]*model.Room, 0, len(rooms))
+	for _, r := range rooms {
+		if r.IsDirect() {
+			direct = append(direct, r)
+		}
+	}
+	return direct
+}
+
+// JoinRoom はユーザーをルームに参加させる
+func (s *roomService) JoinRoom(userID, roomID string) (*model.Room, error) {
+	if _, exists := s.store.GetUser(userID); !exists {
+		return nil, fmt.Errorf("user not found")
+	}
+	room, ok := s.FindRoom(userID, roomID)
+	if !ok {
+		return nil, ErrRoomNotFound
+	}
+	if room.IsDirect() {
+		// 参加者は固定（FindRoomで見えている時点で参加済み）
+		return room, nil
+	}
+	if room.Archived() {
+		return nil, ErrRoomArchived
+	}
+	if s.store.IsRoomMember(roomID, userID) {
+		return room, nil
+	}
+	if err := s.store.AddRoomMember(roomID, userID); err != nil {
+		return nil, err
+	}
+	s.pubsub.Publish(roomMemberJoinedTopic(roomID, userID), userID)
+	return room, nil
+}
+
+// LeaveRoom はユーザーをルームから外し、そのユーザーの購読を終了させる
+func (s *roomService) LeaveRoom(userID, roomID string) error {
+	if roomID == model.DefaultRoomID {
+		return fmt.Errorf("cannot leave the default room")
+	}
+	room, err := requireMember(s.store, roomID, userID)
+	if err != nil {
+		return err
+	}
+	if room.IsDirect() {
+		return fmt.Errorf("cannot leave a direct room")
+	}
+	if err := s.store.RemoveRoomMember(roomID, userID); err != nil {
+		return err
+	}
+	s.pubsub.Publish(roomMemberLeftTopic(roomID, userID), userID)
+	return nil
+}
+
+// ArchiveRoom はルームをアーカイブして読み取り専用にする（作成者のみ）
+func (s *roomService) ArchiveRoom(userID, roomID string) (*model.Room, error) {
+	room, ok := s.FindRoom(userID, roomID)
+	if !ok {
+		return nil, ErrRoomNotFound
+	}
+	if room.IsDirect() {
+		return nil, fmt.Errorf("cannot archive a direct room")
+	}
+	if room.CreatedBy == "" || room.CreatedBy != userID {
+		return nil, fmt.Errorf("only the creator can archive the room")
+	}
+	if room.Archived() {
+		return room, nil
+	}
+
+	archivedAt := time.Now().Format(time.RFC3339)
+	archived := *room
+	archived.ArchivedAt = &archivedAt
+	if err := s.store.SaveRoom(&archived); err != nil {
+		return nil, err
+	}
+	return &archived, nil
+}
+
+// Members はルームのメンバーのユーザーIDを取得
+func (s *roomService) Members(roomID string) []string {
+	return s.store.ListRoomMembers(roomID)
+}
+
+// requireMember はルームが存在し、ユーザーがそのメンバーであることを確認
+//
+// ダイレクトメッセージの会話は参加者以外には存在自体を隠す
+func requireMember(s store.Store, roomID, userID string) (*model.Room, error) {
+	room, ok := s.GetRoom(roomID)
+	if !ok {
+		return nil, ErrRoomNotFound
+	}
+	if !s.IsRoomMember(roomID, userID) {
+		if room.IsDirect() {
+			return nil, ErrRoomNotFound
+		}
+		return nil, ErrNotRoomMember
+	}
+	return room, nil
+}
+
+// hasRole はユーザーが required 以上のロールを持つかどうか
+func hasRole(s store.Store, userID string, required model.Role) bool {
+	user, ok := s.GetUser(userID)
+	return ok && user.HasRole(required)
+}
@@ -0,0 +1,48 @@
+package service
+
+import "github.com/kajidog/graphql-sse-test/apps/backend/pubsub"
+
+// roomMessageAddedTopic はルームへのメッセージ追加イベントのトピック
+func roomMessageAddedTopic(roomID string) string {
+	return pubsub.Topic("room", roomID, "message", "added")
+}
+
+// roomMessageUpdatedTopic はルームのメッセージ編集イベントのトピック
+func roomMessageUpdatedTopic(roomID string) string {
+	return pubsub.Topic("room", roomID, "message", "updated")
+}
+
+// roomMessageDeletedTopic はルームのメッセージ削除イベントのトピック
+func roomMessageDeletedTopic(roomID string) string {
+	return pubsub.Topic("room", roomID, "message", "deleted")
+}
+
+// userDirectMessageTopic はユーザー宛てのダイレクトメッセージのトピック
+func userDirectMessageTopic(userID string) string {
+	return pubsub.Topic("user", userID, "direct", "received")
+}
+
+// roomMemberJoinedTopic はユーザーがルームに参加したイベントのトピック
+func roomMemberJoinedTopic(roomID, userID string) string {
+	return pubsub.Topic("room", roomID, "member", userID, "joined")
+}
+
+// roomEventsTopic はルームの全イベントに一致する購読パターン
+func roomEventsTopic(roomID string) string {
+	return pubsub.Topic("room", roomID, pubsub.WildcardRest)
+}
+
+// roomMemberLeftTopic はユーザーがルームから抜けたイベントのトピック
+func roomMemberLeftTopic(roomID, userID string) string {
+	return pubsub.Topic("room", roomID, "member", userID, "left")
+}
+
+// userBannedTopic はユーザーが利用停止（BAN）になったイベントのトピック
+func userBannedTopic(userID string) string {
+	return pubsub.Topic("user", userID, "banned")
+}
+
+// sessionRevokedTopic はセッションが失効したイベントのトピック
+func sessionRevokedTopic(sessionID string) string {
+	return pubsub.Topic("session", sessionID, "revoked")
+}
@@ -1,43 +1,400 @@
 package service
 
 import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
 	"github.com/google/uuid"
 	"github.com/kajidog/graphql-sse-test/apps/backend/graph/model"
 	"github.com/kajidog/graphql-sse-test/apps/backend/store"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// MinPasswordLength はパスワードの最短の長さ
+	MinPasswordLength = 8
+	// MaxPasswordLength はパスワードの最長のバイト数（bcryptは72バイトを超える部分を無視する）
+	MaxPasswordLength = 72
+	// MaxNicknameLength は表示名の最長の文字数
+	MaxNicknameLength = 32
+	// MaxLoginAttempts は連続してログインに失敗できる回数（超えるとロックする）
+	MaxLoginAttempts = 5
+	// LoginLockoutDuration はログインをロックする期間
+	LoginLockoutDuration = 15 * time.Minute
 )
 
+var (
+	// ErrInvalidCredentials はユーザー名かパスワードが正しくない（どちらが誤りかは区別しない）
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	// ErrAccountLocked はログインの失敗が続いたため一時的にロックされている
+	ErrAccountLocked = errors.New("account is temporarily locked due to repeated failed logins")
+	// ErrUsernameTaken はユーザー名が他のユーザーに使われている
+	ErrUsernameTaken = store.ErrUsernameTaken
+	// ErrPasswordAlreadySet はパスワードを設定済みのユーザーが setPassword を呼んだ
+	ErrPasswordAlreadySet = errors.New("password is already set")
+)
+
+// usernamePattern はユーザー名に使える文字（小文字化した後）
+var usernamePattern = regexp.MustCompile(`^[a-z0-9_]{3,32}$`)
+
+// externalUserNamespace は外部のIDプロバイダーの利用者のユーザーIDを導出する名前空間
+//
+// 導出したID（UUIDv5）は登録時のランダムなID（UUIDv4）と衝突しない
+var externalUserNamespace = uuid.MustParse("5b0e7a3c-2f4d-4c1e-9a6b-3d8f1e2c7a90")
+
+// externalUsernamePrefix は外部の利用者のユーザー名の接頭辞（登録できるユーザー名に含まれない文字を使う）
+const externalUsernamePrefix = "ext-"
+
+// dummyPasswordHash は存在しないユーザーのログインでも照合の時間をかけ、ユーザー名の有無を推測させない
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
 // UserService はユーザー関連のビジネスロジックを提供
 type UserService interface {
-	Login(nickname string) (*model.User, error)
+	Register(username, password, nickname string) (*model.User, error)
+	Authenticate(username, password string) (*model.User, error)
 	GetUser(id string) (*model.User, bool)
+	UpdateNickname(userID, nickname string) (*model.User, error)
+	SetPassword(userID, password string) (*model.User, error)
+	SetRole(actorID, userID string, role model.Role) (*model.User, error)
+	BootstrapAdmins(password string, usernames ...string) error
+	ProvisionExternalUser(issuer, subject, nickname string) (string, error)
 }
 
 type userService struct {
 	store store.Store
+	// 登録とログインの失敗回数の更新を直列化する
+	mu  sync.Mutex
+	now func() time.Time
 }
 
 // NewUserService は新しいUserServiceを作成
 func NewUserService(s store.Store) UserService {
-	return &userService{store: s}
+	return &userService{store: s, now: time.Now}
 }
 
-// Login は既存ユーザーを検索、なければ新規作成
-func (s *userService) Login(nickname string) (*model.User, error) {
-	// 既存ユーザーを検索
-	if user, ok := s.store.GetUserByNickname(nickname); ok {
-		return user, nil
+// Register はユーザー名とパスワードでユーザーを登録し、既定のルームに参加させる
+//
+// nickname を省略した場合はユーザー名を表示名にする。
+// 既存のユーザーと同じユーザー名は、パスワードの無いユーザー（ニックネームだけでログインしていた頃のユーザー）でも
+// ErrUsernameTaken になる（パスワードの無いユーザーは本人のセッションで SetPassword を呼ぶ）
+func (s *userService) Register(username, password, nickname string) (*model.User, error) {
+	username = normalizeUsername(username)
+	if !usernamePattern.MatchString(username) {
+		return nil, fmt.Errorf("username must be 3-32 characters of a-z, 0-9 or _")
+	}
+	if err := validatePassword(password); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(nickname) == "" {
+		nickname = username
+	}
+	nickname, err := validateNickname(nickname)
+	if err != nil {
+		return nil, err
 	}
 
-	// 新規ユーザーを作成
-	user := &model.User{
-		ID:       uuid.New().String(),
-		Nickname: nickname,
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("hash password: %w", err)
 	}
-	s.store.SaveUser(user)
-	return user, nil
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.store.GetUserByUsername(username); ok {
+		return nil, ErrUsernameTaken
+	}
+	user := &model.User{ID: uuid.New().String(), Username: username, Nickname: nickname, Role: model.RoleMember}
+	if err := s.store.SaveUser(user); err != nil {
+		return nil, err
+	}
+	credential := &model.Credential{
+		UserID:       user.ID,
+		PasswordHash: string(hash),
+		UpdatedAt:    s.now().Format(time.RFC3339),
+	}
+	if err := s.store.SaveCredential(credential); err != nil {
+		return nil, err
+	}
+
+	return s.joinDefaultRoom(user)
+}
+
+// Authenticate はユーザー名とパスワードを照合し、ユーザーを返す
+//
+// 連続して MaxLoginAttempts 回失敗すると LoginLockoutDuration の間ロックし、正しいパスワードでも ErrAccountLocked を返す
+func (s *userService) Authenticate(username, password string) (*model.User, error) {
+	user, ok := s.store.GetUserByUsername(normalizeUsername(username))
+	var credential *model.Credential
+	if ok {
+		credential, ok = s.store.GetCredential(user.ID)
+	}
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+
+	now := s.now()
+	if locked(credential, now) {
+		return nil, ErrAccountLocked
+	}
+
+	matched := bcrypt.CompareHashAndPassword([]byte(credential.PasswordHash), []byte(password)) == nil
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 照合中に更新されている場合があるため、最新の失敗回数に対して更新する。
+	// 並行した照合でロックされた場合は、失敗回数を数えたりロックを解いたりしない
+	current, ok := s.store.GetCredential(user.ID)
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	if locked(current, now) {
+		return nil, ErrAccountLocked
+	}
+	updated := *current
+	if matched {
+		if updated.FailedAttempts == 0 && updated.LockedUntil == nil {
+			return s.joinDefaultRoom(user)
+		}
+		updated.FailedAttempts = 0
+		updated.LockedUntil = nil
+	} else {
+		updated.FailedAttempts++
+		if updated.FailedAttempts >= MaxLoginAttempts {
+			lockedUntil := now.Add(LoginLockoutDuration).Format(time.RFC3339)
+			updated.FailedAttempts = 0
+			updated.LockedUntil = &lockedUntil
+		}
+	}
+	updated.UpdatedAt = now.Format(time.RFC3339)
+	if err := s.store.SaveCredential(&updated); err != nil {
+		return nil, err
+	}
+
+	if !matched {
+		if updated.LockedUntil != nil {
+			return nil, ErrAccountLocked
+		}
+		return nil, ErrInvalidCredentials
+	}
+	return s.joinDefaultRoom(user)
+}
+
+// locked は now の時点でログインがロックされているかを返す
+func locked(credential *model.Credential, now time.Time) bool {
+	if credential.LockedUntil == nil {
+		return false
+	}
+	lockedUntil, err := time.Parse(time.RFC3339, *credential.LockedUntil)
+	return err == nil && now.Before(lockedUntil)
 }
 
 // GetUser はIDでユーザーを取得
 func (s *userService) GetUser(id string) (*model.User, bool) {
 	return s.store.GetUser(id)
 }
+
+// UpdateNickname はユーザーの表示名を変更（ユーザー名は変わらない）
+func (s *userService) UpdateNickname(userID, nickname string) (*model.User, error) {
+	nickname, err := validateNickname(nickname)
+	if err != nil {
+		return nil, err
+	}
+	user, ok := s.store.GetUser(userID)
+	if !ok {
+		return nil, fmt.Errorf("user not found")
+	}
+	updated := *user
+	updated.Nickname = nickname
+	if err := s.store.SaveUser(&updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// SetPassword はパスワードの無いユーザーにパスワードを設定し、ユーザー名でログインできるようにする
+//
+// 本人のセッションからのみ呼ぶ。パスワードを設定済みのユーザーは ErrPasswordAlreadySet、
+// 登録できないユーザー名のユーザー（外部の利用者や、移行時にユーザー名を付けられなかったユーザー）はエラー
+func (s *userService) SetPassword(userID, password string) (*model.User, error) {
+	if err := validatePassword(password); err != nil {
+		return nil, err
+	}
+	user, ok := s.store.GetUser(userID)
+	if !ok {
+		return nil, fmt.Errorf("user not found")
+	}
+	if !usernamePattern.MatchString(user.Username) {
+		return nil, fmt.Errorf("this account cannot log in with a password")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("hash password: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, hasPassword := s.store.GetCredential(userID); hasPassword {
+		return nil, ErrPasswordAlreadySet
+	}
+	credential := &model.Credential{
+		UserID:       userID,
+		PasswordHash: string(hash),
+		UpdatedAt:    s.now().Format(time.RFC3339),
+	}
+	if err := s.store.SaveCredential(credential); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// SetRole はユーザーのロールを変更（自分自身のロールは変更できない）
+func (s *userService) SetRole(actorID, userID string, role model.Role) (*model.User, error) {
+	if !role.IsValid() {
+		return nil, fmt.Errorf("invalid role: %s", role)
+	}
+	if actorID == userID {
+		return nil, fmt.Errorf("cannot change your own role")
+	}
+	user, ok := s.store.GetUser(userID)
+	if !ok {
+		return nil, fmt.Errorf("user not found")
+	}
+	updated := *user
+	updated.Role = role
+	if err := s.store.SaveUser(&updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// ProvisionExternalUser は外部のIDプロバイダーの利用者（発行者と subject の組）に対応するユーザーのIDを返す
+//
+// ユーザーIDは発行者と subject から導出し、初回はユーザーを作成して既定のルームに参加させる。
+// 作成したユーザーはパスワードを持たないため、ユーザー名とパスワードではログインできない
+func (s *userService) ProvisionExternalUser(issuer, subject, nickname string) (string, error) {
+	id := uuid.NewSHA1(externalUserNamespace, []byte(issuer+"\x00"+subject)).String()
+	if _, ok := s.store.GetUser(id); ok {
+		return id, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.store.GetUser(id); ok {
+		return id, nil
+	}
+	nickname, err := validateNickname(nickname)
+	if err != nil {
+		nickname = "external user"
+	}
+	user := &model.User{ID: id, Username: externalUsernamePrefix + id, Nickname: nickname, Role: model.RoleMember}
+	if err := s.store.SaveUser(user); err != nil {
+		return "", err
+	}
+	if _, err := s.joinDefaultRoom(user); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// BootstrapAdmins は起動時に、指定したユーザー名のユーザーを ADMIN にする
+//
+// 登録済みのユーザーはロールだけを変える。登録されていないユーザー名は、password が指定されていれば
+// そのパスワードで ADMIN のユーザーとして作成し、指定されていなければエラーにする
+// （登録前のユーザー名を ADMIN に予約すると、後から登録した人が ADMIN になってしまうため）
+func (s *userService) BootstrapAdmins(password string, usernames ...string) error {
+	var hash []byte
+	if password != "" {
+		if err := validatePassword(password); err != nil {
+			return fmt.Errorf("admin password: %w", err)
+		}
+		var err error
+		if hash, err = bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost); err != nil {
+			return fmt.Errorf("hash password: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, username := range usernames {
+		username = normalizeUsername(username)
+		if user, ok := s.store.GetUserByUsername(username); ok {
+			if user.Role == model.RoleAdmin {
+				continue
+			}
+			updated := *user
+			updated.Role = model.RoleAdmin
+			if err := s.store.SaveUser(&updated); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if hash == nil {
+			return fmt.Errorf("admin user not found: %s (register it first or set an admin password)", username)
+		}
+		if !usernamePattern.MatchString(username) {
+			return fmt.Errorf("invalid admin username: %s", username)
+		}
+		user := &model.User{ID: uuid.New().String(), Username: username, Nickname: username, Role: model.RoleAdmin}
+		if err := s.store.SaveUser(user); err != nil {
+			return err
+		}
+		credential := &model.Credential{
+			UserID:       user.ID,
+			PasswordHash: string(hash),
+			UpdatedAt:    s.now().Format(time.RFC3339),
+		}
+		if err := s.store.SaveCredential(credential); err != nil {
+			return err
+		}
+		if _, err := s.joinDefaultRoom(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// joinDefaultRoom はユーザーを既定のルームに参加させる（ルーム導入前のユーザーも含む）
+func (s *userService) joinDefaultRoom(user *model.User) (*model.User, error) {
+	if err := s.store.AddRoomMember(model.DefaultRoomID, user.ID); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// normalizeUsername はユーザー名の大文字・小文字を区別しないように小文字にする
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+func validatePassword(password string) error {
+	if utf8.RuneCountInString(password) < MinPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+	if len(password) > MaxPasswordLength {
+		return fmt.Errorf("password must be at most %d bytes", MaxPasswordLength)
+	}
+	return nil
+}
+
+func validateNickname(nickname string) (string, error) {
+	nickname = strings.TrimSpace(nickname)
+	if nickname == "" {
+		return "", fmt.Errorf("nickname must not be empty")
+	}
+	if utf8.RuneCountInString(nickname) > MaxNicknameLength {
+		return "", fmt.Errorf("nickname must be at most %d characters", MaxNicknameLength)
+	}
+	return nickname, nil
+}
@@ -0,0 +1,79 @@
+package service_test
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/kajidog/graphql-sse-test/apps/backend/graph/model"
+	"github.com/kajidog/graphql-sse-test/apps/backend/pubsub"
+	"github.com/kajidog/graphql-sse-test/apps/backend/service"
+	"github.com/kajidog/graphql-sse-test/apps/backend/store"
+)
+
+// credentialPausingStore は認証情報の取得を1回だけ、読み取った後で resume が閉じられるまで止める
+type credentialPausingStore struct {
+	store.Store
+	armed  atomic.Bool
+	paused chan struct{}
+	resume chan struct{}
+}
+
+func (s *credentialPausingStore) GetCredential(userID string) (*model.Credential, bool) {
+	credential, ok := s.Store.GetCredential(userID)
+	if s.armed.CompareAndSwap(true, false) {
+		close(s.paused)
+		<-s.resume
+	}
+	return credential, ok
+}
+
+// TestAuthenticateConcurrentGuess は、ロック前に照合を始めたログインが、並行した失敗でロックされた後に
+// 完了しても、ロックを解いたり失敗回数を数えたりしないことを確認
+func TestAuthenticateConcurrentGuess(t *testing.T) {
+	tests := map[string]string{
+		"correct password": "password123",
+		"wrong password":   "wrong-password",
+	}
+	for name, password := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := &credentialPausingStore{Store: store.NewMemoryStore(), paused: make(chan struct{}), resume: make(chan struct{})}
+			if err := service.NewRoomService(s, pubsub.NewMemoryPubSub()).EnsureDefaultRoom(); err != nil {
+				t.Fatal(err)
+			}
+			users := service.NewUserService(s)
+			user, err := users.Register("alice", "password123", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < service.MaxLoginAttempts-1; i++ {
+				if _, err := users.Authenticate("alice", "wrong-password"); !errors.Is(err, service.ErrInvalidCredentials) {
+					t.Fatalf("attempt %d: err = %v, want ErrInvalidCredentials", i, err)
+				}
+			}
+
+			// ロック前の認証情報を読んだところで止め、その間に最後の失敗でロックさせる
+			s.armed.Store(true)
+			done := make(chan error, 1)
+			go func() {
+				_, err := users.Authenticate("alice", password)
+				done <- err
+			}()
+			<-s.paused
+			if _, err := users.Authenticate("alice", "wrong-password"); !errors.Is(err, service.ErrAccountLocked) {
+				t.Fatalf("last attempt: err = %v, want ErrAccountLocked", err)
+			}
+			locked, _ := s.Store.GetCredential(user.ID)
+			close(s.resume)
+
+			if err := <-done; !errors.Is(err, service.ErrAccountLocked) {
+				t.Fatalf("concurrent attempt: err = %v, want ErrAccountLocked", err)
+			}
+			credential, _ := s.Store.GetCredential(user.ID)
+			if credential.LockedUntil == nil || *credential.LockedUntil != *locked.LockedUntil || credential.FailedAttempts != 0 {
+				t.Fatalf("credential = locked until %v, %d failed attempts, want unchanged lock (%s) and 0 attempts",
+					credential.LockedUntil, credential.FailedAttempts, *locked.LockedUntil)
+			}
+		})
+	}
+}
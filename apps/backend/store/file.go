@@ -0,0 +1,662 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/kajidog/graphql-sse-test/apps/backend/graph/model"
+)
+
+// DefaultCompactThreshold はスナップショットを作成するまでのログ件数のデフォルト値
+const DefaultCompactThreshold = 1000
+
+const (
+	snapshotFileName = "snapshot.json"
+	logFilePattern   = "wal-%d.log"
+
+	opSaveUser         = "saveUser"
+	opSaveRoom         = "saveRoom"
+	opAddRoomMember    = "addRoomMember"
+	opRemoveRoomMember = "removeRoomMember"
+	opSaveMessage      = "saveMessage"
+	opEditMessage      = "editMessage"
+	opDeleteMessage    = "deleteMessage"
+	opSaveSession      = "saveSession"
+	opSaveCredential   = "saveCredential"
+	opSaveSanction     = "saveSanction"
+	opDeleteSanction   = "deleteSanction"
+	opSaveModeration   = "saveModerationAction"
+)
+
+// logRecord は追記ログの1行
+type logRecord struct {
+	Op      string         `json:"op"`
+	User    *model.User    `json:"user,omitempty"`
+	Room    *model.Room    `json:"room,omitempty"`
+	RoomID  string         `json:"roomId,omitempty"`
+	UserID  string         `json:"userId,omitempty"`
+	Message *model.Message `json:"message,omitempty"`
+	Session *model.Session `json:"session,omitempty"`
+	// Credential はパスワードのハッシュを含むため、データディレクトリの権限に注意すること
+	Credential *model.Credential `json:"credential,omitempty"`
+	// saveSanction では制裁、deleteSanction では UserID と Kind のみ
+	Sanction         *model.Sanction         `json:"sanction,omitempty"`
+	ModerationAction *model.ModerationAction `json:"moderationAction,omitempty"`
+	// editMessage / deleteMessage の対象と内容
+	MessageID string `json:"messageId,omitempty"`
+	Content   string `json:"content,omitempty"`
+	At        string `json:"at,omitempty"`
+}
+
+// snapshot はある時点の全データ
+//
+// Generation は続けて適用するログファイルの世代、Members はルームIDごとのメンバーのユーザーID、
+// Edits はメッセージIDごとの編集履歴
+type snapshot struct {
+	Generation  int                             `json:"generation"`
+	Users       []*model.User                   `json:"users"`
+	Rooms       []*model.Room                   `json:"rooms"`
+	Members     map[string][]string             `json:"members"`
+	Messages    []*model.Message                `json:"messages"`
+	Edits       map[string][]*model.MessageEdit `json:"edits,omitempty"`
+	Sessions    []*model.Session                `json:"sessions,omitempty"`
+	Credentials []*model.Credential             `json:"credentials,omitempty"`
+	Sanctions   []*model.Sanction               `json:"sanctions,omitempty"`
+	// ModerationActions は監査ログ（古い順）
+	ModerationActions []*model.ModerationAction `json:"moderationActions,omitempty"`
+}
+
+// FileStore はローカルファイルに永続化するストレージの実装
+//
+// 書き込みは追記ログ（wal-<世代>.log）にfsyncしてからメモリへ反映し、読み込みはメモリから行う。
+// ログが一定件数を超えるとスナップショットを書き出し、新しい世代のログに切り替える（コンパクション）。
+// 起動時はスナップショットを読み込み、その世代のログを再生して復元する。
+type FileStore struct {
+	*MemoryStore
+
+	dir              string
+	compactThreshold int
+
+	mu         sync.Mutex
+	generation int
+	log        *os.File
+	logRecords int
+	// nextCompaction はコンパクションを行うログ件数（失敗した場合は次の閾値まで延ばす）
+	nextCompaction int
+}
+
+// NewFileStore はdirのデータを復元してFileStoreを作成
+//
+// compactThresholdが0以下の場合はDefaultCompactThresholdを使う
+func NewFileStore(dir string, compactThreshold int) (*FileStore, error) {
+	if compactThreshold <= 0 {
+		compactThreshold = DefaultCompactThreshold
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create data directory: %w", err)
+	}
+
+	s := &FileStore{
+		MemoryStore:      NewMemoryStore(),
+		dir:              dir,
+		compactThreshold: compactThreshold,
+		nextCompaction:   compactThreshold,
+	}
+	if err := s.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := s.replayLog(); err != nil {
+		return nil, err
+	}
+	s.removeStaleLogs()
+
+	return s, nil
+}
+
+// SaveUser はユーザーをログに書き込んでから保存
+func (s *FileStore) SaveUser(user *model.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if other, ok := s.MemoryStore.GetUserByUsername(user.Username); ok && other.ID != user.ID {
+		return ErrUsernameTaken
+	}
+	if err := s.appendLog(logRecord{Op: opSaveUser, User: user}); err != nil {
+		return err
+	}
+	s.apply(logRecord{Op: opSaveUser, User: user})
+	s.compactIfNeeded()
+	return nil
+}
+
+// SaveRoom はルームをログに書き込んでから保存
+func (s *FileStore) SaveRoom(room *model.Room) error {
+	return s.write(logRecord{Op: opSaveRoom, Room: room})
+}
+
+// AddRoomMember はメンバーの追加をログに書き込んでから反映
+func (s *FileStore) AddRoomMember(roomID, userID string) error {
+	return s.write(logRecord{Op: opAddRoomMember, RoomID: roomID, UserID: userID})
+}
+
+// RemoveRoomMember はメンバーの削除をログに書き込んでから反映
+func (s *FileStore) RemoveRoomMember(roomID, userID string) error {
+	return s.write(logRecord{Op: opRemoveRoomMember, RoomID: roomID, UserID: userID})
+}
+
+// SaveMessage はメッセージをログに書き込んでから保存
+func (s *FileStore) SaveMessage(msg *model.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.appendLog(logRecord{Op: opSaveMessage, Message: msg}); err != nil {
+		return err
+	}
+	s.MemoryStore.SaveMessage(msg)
+	s.compactIfNeeded()
+	return nil
+}
+
+// EditMessage は編集をログに書き込んでから反映
+func (s *FileStore) EditMessage(id, content, editedAt string) (*model.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msg, ok := s.MemoryStore.GetMessage(id)
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	if msg.Deleted() {
+		return nil, ErrMessageDeleted
+	}
+	if err := s.appendLog(logRecord{Op: opEditMessage, MessageID: id, Content: content, At: editedAt}); err != nil {
+		return nil, err
+	}
+	msg, err := s.MemoryStore.EditMessage(id, content, editedAt)
+	if err != nil {
+		return nil, err
+	}
+	s.compactIfNeeded()
+	return msg, nil
+}
+
+// DeleteMessage は削除をログに書き込んでから反映
+func (s *FileStore) DeleteMessage(id, deletedAt string) (*model.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.MemoryStore.GetMessage(id); !ok {
+		return nil, ErrMessageNotFound
+	}
+	if err := s.appendLog(logRecord{Op: opDeleteMessage, MessageID: id, At: deletedAt}); err != nil {
+		return nil, err
+	}
+	msg, err := s.MemoryStore.DeleteMessage(id, deletedAt)
+	if err != nil {
+		return nil, err
+	}
+	s.compactIfNeeded()
+	return msg, nil
+}
+
+// SaveSession はセッションをログに書き込んでから保存
+func (s *FileStore) SaveSession(session *model.Session) error {
+	return s.write(logRecord{Op: opSaveSession, Session: session})
+}
+
+// SaveCredential は認証情報をログに書き込んでから保存
+func (s *FileStore) SaveCredential(credential *model.Credential) error {
+	return s.write(logRecord{Op: opSaveCredential, Credential: credential})
+}
+
+// SaveSanction は制裁をログに書き込んでから保存
+func (s *FileStore) SaveSanction(sanction *model.Sanction) error {
+	return s.write(logRecord{Op: opSaveSanction, Sanction: sanction})
+}
+
+// DeleteSanction は制裁の解除をログに書き込んでから反映
+func (s *FileStore) DeleteSanction(userID string, kind model.SanctionKind) error {
+	return s.write(logRecord{Op: opDeleteSanction, Sanction: &model.Sanction{UserID: userID, Kind: kind}})
+}
+
+// SaveModerationAction はモデレーション操作をログに書き込んでから監査ログに追加
+func (s *FileStore) SaveModerationAction(action *model.ModerationAction) error {
+	return s.write(logRecord{Op: opSaveModeration, ModerationAction: action})
+}
+
+// write はレコードをログに書き込んでからメモリへ反映
+func (s *FileStore) write(rec logRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.appendLog(rec); err != nil {
+		return err
+	}
+	s.apply(rec)
+	s.compactIfNeeded()
+	return nil
+}
+
+// Compact はスナップショットを書き出し、ログを新しい世代に切り替える
+func (s *FileStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.compact()
+}
+
+// Close はログファイルを閉じる
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.log == nil {
+		return nil
+	}
+	err := s.log.Close()
+	s.log = nil
+	return err
+}
+
+func (s *FileStore) logPath(generation int) string {
+	return filepath.Join(s.dir, fmt.Sprintf(logFilePattern, generation))
+}
+
+// loadSnapshot はスナップショットがあれば読み込む
+func (s *FileStore) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(s.dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("decode snapshot: %w", err)
+	}
+	for _, u := range snap.Users {
+		s.apply(logRecord{Op: opSaveUser, User: u})
+	}
+	for _, r := range snap.Rooms {
+		s.apply(logRecord{Op: opSaveRoom, Room: r})
+	}
+	for roomID, userIDs := range snap.Members {
+		for _, userID := range userIDs {
+			s.MemoryStore.AddRoomMember(roomID, userID)
+		}
+	}
+	for _, m := range snap.Messages {
+		s.apply(logRecord{Op: opSaveMessage, Message: m})
+	}
+	for messageID, edits := range snap.Edits {
+		s.MemoryStore.edits[messageID] = edits
+	}
+	for _, session := range snap.Sessions {
+		s.MemoryStore.SaveSession(session)
+	}
+	for _, credential := range snap.Credentials {
+		s.MemoryStore.SaveCredential(credential)
+	}
+	for _, sanction := range snap.Sanctions {
+		s.MemoryStore.SaveSanction(sanction)
+	}
+	for _, action := range snap.ModerationActions {
+		s.MemoryStore.SaveModerationAction(action)
+	}
+	s.generation = snap.Generation
+	return nil
+}
+
+// replayLog は現在の世代のログを再生し、追記用に開く
+//
+// 書き込み途中でクラッシュした末尾の不完全な行（改行で終わっていない行）だけを切り捨てる。
+// 改行で終わっているのに読めない行は途中の破損で、以降の記録を失わないようエラーにする
+func (s *FileStore) replayLog() error {
+	f, err := os.OpenFile(s.logPath(s.generation), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("open log: %w", err)
+	}
+
+	var valid int64
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// 改行で終わっていない行は書き込み途中
+			break
+		}
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("read log: %w", err)
+		}
+
+		var rec logRecord
+		if err := json.Unmarshal(bytes.TrimSpace(line), &rec); err != nil {
+			f.Close()
+			return fmt.Errorf("corrupt log %s at offset %d: %w", s.logPath(s.generation), valid, err)
+		}
+		s.apply(rec)
+		valid += int64(len(line))
+		s.logRecords++
+	}
+
+	if err := f.Truncate(valid); err != nil {
+		f.Close()
+		return fmt.Errorf("truncate log: %w", err)
+	}
+	if _, err := f.Seek(valid, io.SeekStart); err != nil {
+		f.Close()
+		return fmt.Errorf("seek log: %w", err)
+	}
+	s.log = f
+	return nil
+}
+
+// legacyUsernamePattern はユーザー名に使える文字（service の登録時の検証と同じ）
+var legacyUsernamePattern = regexp.MustCompile(`^[a-z0-9_]{3,32}$`)
+
+// legacyUsername はユーザー名導入前のユーザーのユーザー名（SQLストアの移行と同じ規則）
+//
+// ニックネームを小文字にしたものを使い、ユーザー名に使えない場合や他のユーザーと重複する場合は
+// LegacyUsernamePrefix にユーザーIDを付けたものにする
+func (s *FileStore) legacyUsername(user *model.User) string {
+	username := strings.ToLower(strings.TrimSpace(user.Nickname))
+	if !legacyUsernamePattern.MatchString(username) {
+		return LegacyUsernamePrefix + user.ID
+	}
+	if other, ok := s.MemoryStore.GetUserByUsername(username); ok && other.ID != user.ID {
+		return LegacyUsernamePrefix + user.ID
+	}
+	return username
+}
+
+func (s *FileStore) apply(rec logRecord) {
+	switch rec.Op {
+	case opSaveUser:
+		if rec.User != nil {
+			// ユーザー名導入前のユーザーはニックネームからユーザー名を決める
+			if rec.User.Username == "" {
+				rec.User.Username = s.legacyUsername(rec.User)
+			}
+			// ロール導入前のユーザーは一般のメンバー
+			if rec.User.Role == "" {
+				rec.User.Role = model.RoleMember
+			}
+			s.MemoryStore.SaveUser(rec.User)
+		}
+	case opSaveRoom:
+		if rec.Room != nil {
+			// 種別導入前のルームは通常のルーム
+			if rec.Room.Kind == "" {
+				rec.Room.Kind = model.RoomKindChannel
+			}
+			s.MemoryStore.SaveRoom(rec.Room)
+		}
+	case opAddRoomMember:
+		s.MemoryStore.AddRoomMember(rec.RoomID, rec.UserID)
+	case opRemoveRoomMember:
+		s.MemoryStore.RemoveRoomMember(rec.RoomID, rec.UserID)
+	case opSaveMessage:
+		if rec.Message != nil {
+			// ルーム導入前のメッセージは既定のルームに属する
+			if rec.Message.RoomID == "" {
+				rec.Message.RoomID = model.DefaultRoomID
+			}
+			s.MemoryStore.SaveMessage(rec.Message)
+		}
+	case opEditMessage:
+		s.MemoryStore.EditMessage(rec.MessageID, rec.Content, rec.At)
+	case opDeleteMessage:
+		s.MemoryStore.DeleteMessage(rec.MessageID, rec.At)
+	case opSaveSession:
+		if rec.Session != nil {
+			s.MemoryStore.SaveSession(rec.Session)
+		}
+	case opSaveCredential:
+		if rec.Credential != nil {
+			s.MemoryStore.SaveCredential(rec.Credential)
+		}
+	case opSaveSanction:
+		if rec.Sanction != nil {
+			s.MemoryStore.SaveSanction(rec.Sanction)
+		}
+	case opDeleteSanction:
+		if rec.Sanction != nil {
+			s.MemoryStore.DeleteSanction(rec.Sanction.UserID, rec.Sanction.Kind)
+		}
+	case opSaveModeration:
+		if rec.ModerationAction != nil {
+			s.MemoryStore.SaveModerationAction(rec.ModerationAction)
+		}
+	}
+}
+
+func (s *FileStore) appendLog(rec logRecord) error {
+	if s.log == nil {
+		return errors.New("file store is closed")
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if _, err := s.log.Write(data); err != nil {
+		return fmt.Errorf("write log: %w", err)
+	}
+	if err := s.log.Sync(); err != nil {
+		return fmt.Errorf("sync log: %w", err)
+	}
+	s.logRecords++
+	return nil
+}
+
+// compactIfNeeded はログが閾値に達していればコンパクションする
+//
+// 書き込みはログに永続化済みのため、失敗しても書き込みのエラーにはせず、記録して次の閾値で再試行する
+func (s *FileStore) compactIfNeeded() {
+	if s.logRecords < s.nextCompaction {
+		return
+	}
+	if err := s.compact(); err != nil {
+		s.nextCompaction = s.logRecords + s.compactThreshold
+		log.Printf("[FileStore] compaction failed (retrying at %d log records): %v", s.nextCompaction, err)
+	}
+}
+
+// compact は次の世代のスナップショットと空のログを作成してから切り替える
+//
+// スナップショットのリネームが完了するまでは旧世代のスナップショットとログが有効なため、
+// 途中でクラッシュしても復元できる
+func (s *FileStore) compact() error {
+	next := s.generation + 1
+
+	nextLog, err := os.OpenFile(s.logPath(next), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("create log: %w", err)
+	}
+
+	snap := snapshot{
+		Generation:        next,
+		Users:             s.users(),
+		Rooms:             s.MemoryStore.ListRooms(),
+		Members:           s.members(),
+		Messages:          s.MemoryStore.GetMessages(),
+		Edits:             s.edits(),
+		Sessions:          s.sessions(),
+		Credentials:       s.credentials(),
+		Sanctions:         s.sanctions(),
+		ModerationActions: s.moderationActions(),
+	}
+	// リネーム後にディレクトリのfsyncだけが失敗した場合は、新しい世代のスナップショットが
+	// 既に見えているため切り替える。リネームが永続化されていない場合に備えて旧世代のログは残す（次回の起動時に削除される）
+	err = writeFileAtomic(filepath.Join(s.dir, snapshotFileName), snap)
+	keepPrevLog := errors.Is(err, errSyncDir)
+	if err != nil && !keepPrevLog {
+		nextLog.Close()
+		os.Remove(s.logPath(next))
+		return err
+	}
+	if keepPrevLog {
+		log.Printf("[FileStore] %v", err)
+	}
+
+	if s.log != nil {
+		s.log.Close()
+	}
+	prev := s.generation
+	s.log = nextLog
+	s.generation = next
+	s.logRecords = 0
+	s.nextCompaction = s.compactThreshold
+	if !keepPrevLog {
+		os.Remove(s.logPath(prev))
+	}
+	return nil
+}
+
+// users はスナップショット用に全ユーザーを取得
+func (s *FileStore) users() []*model.User {
+	s.MemoryStore.mu.RLock()
+	defer s.MemoryStore.mu.RUnlock()
+	users := make([]*model.User, 0, len(s.MemoryStore.users))
+	for _, u := range s.MemoryStore.users {
+		users = append(users, u)
+	}
+	return users
+}
+
+// members はスナップショット用に全ルームのメンバーを取得
+func (s *FileStore) members() map[string][]string {
+	s.MemoryStore.mu.RLock()
+	roomIDs := make([]string, 0, len(s.MemoryStore.members))
+	for roomID := range s.MemoryStore.members {
+		roomIDs = append(roomIDs, roomID)
+	}
+	s.MemoryStore.mu.RUnlock()
+
+	members := make(map[string][]string, len(roomIDs))
+	for _, roomID := range roomIDs {
+		members[roomID] = s.MemoryStore.ListRoomMembers(roomID)
+	}
+	return members
+}
+
+// edits はスナップショット用に全メッセージの編集履歴を取得
+func (s *FileStore) edits() map[string][]*model.MessageEdit {
+	s.MemoryStore.mu.RLock()
+	defer s.MemoryStore.mu.RUnlock()
+	edits := make(map[string][]*model.MessageEdit, len(s.MemoryStore.edits))
+	for messageID, e := range s.MemoryStore.edits {
+		edits[messageID] = e
+	}
+	return edits
+}
+
+// sessions はスナップショット用に全セッションを取得
+func (s *FileStore) sessions() []*model.Session {
+	s.MemoryStore.mu.RLock()
+	defer s.MemoryStore.mu.RUnlock()
+	sessions := make([]*model.Session, 0, len(s.MemoryStore.sessions))
+	for _, session := range s.MemoryStore.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// credentials はスナップショット用に全ユーザーの認証情報を取得
+func (s *FileStore) credentials() []*model.Credential {
+	s.MemoryStore.mu.RLock()
+	defer s.MemoryStore.mu.RUnlock()
+	credentials := make([]*model.Credential, 0, len(s.MemoryStore.credentials))
+	for _, credential := range s.MemoryStore.credentials {
+		credentials = append(credentials, credential)
+	}
+	return credentials
+}
+
+// sanctions はスナップショット用に全ての制裁を取得
+func (s *FileStore) sanctions() []*model.Sanction {
+	s.MemoryStore.mu.RLock()
+	defer s.MemoryStore.mu.RUnlock()
+	sanctions := make([]*model.Sanction, 0, len(s.MemoryStore.sanctions))
+	for _, sanction := range s.MemoryStore.sanctions {
+		sanctions = append(sanctions, sanction)
+	}
+	return sanctions
+}
+
+// moderationActions はスナップショット用に監査ログを古い順に取得
+func (s *FileStore) moderationActions() []*model.ModerationAction {
+	s.MemoryStore.mu.RLock()
+	defer s.MemoryStore.mu.RUnlock()
+	actions := make([]*model.ModerationAction, len(s.MemoryStore.moderationActions))
+	copy(actions, s.MemoryStore.moderationActions)
+	return actions
+}
+
+// removeStaleLogs はスナップショットに取り込み済みの古い世代のログを削除
+func (s *FileStore) removeStaleLogs() {
+	matches, _ := filepath.Glob(filepath.Join(s.dir, "wal-*.log"))
+	current := s.logPath(s.generation)
+	for _, path := range matches {
+		var generation int
+		name := filepath.Base(path)
+		if _, err := fmt.Sscanf(strings.TrimSuffix(name, ".log"), "wal-%d", &generation); err != nil {
+			continue
+		}
+		if path != current && generation < s.generation {
+			os.Remove(path)
+		}
+	}
+}
+
+// errSyncDir はリネームの後、ディレクトリのfsyncに失敗した（ファイルは置き換わっている）
+var errSyncDir = errors.New("sync directory")
+
+// writeFileAtomic は一時ファイルに書き込んでからリネームし、ディレクトリをfsyncしてリネームを永続化する
+func writeFileAtomic(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("rename snapshot: %w", err)
+	}
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("%w: %v", errSyncDir, err)
+	}
+	return nil
+}
+
+// syncDir はディレクトリをfsyncする
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,133 @@
+package store_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kajidog/graphql-sse-test/apps/backend/graph/model"
+	"github.com/kajidog/graphql-sse-test/apps/backend/store"
+	"github.com/kajidog/graphql-sse-test/apps/backend/store/storetest"
+)
+
+// openFileStore はテスト終了時に閉じられるファイルストアを開く
+func openFileStore(t *testing.T, dir string, compactThreshold int) *store.FileStore {
+	t.Helper()
+	s, err := store.NewFileStore(dir, compactThreshold)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestFileStore(t *testing.T) {
+	// 閾値を小さくしてスイートの途中でもコンパクションを起こす
+	storetest.Run(t, func(t *testing.T) store.Store { return openFileStore(t, t.TempDir(), 2) })
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+	s := openFileStore(t, dir, 3)
+	s.SaveRoom(&model.Room{ID: "r"})
+	s.AddRoomMember("r", "u")
+	s.AddRoomMember("r", "v")
+	s.RemoveRoomMember("r", "v")
+	s.SaveMessage(&model.Message{ID: "m", RoomID: "r"})
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	s = openFileStore(t, dir, 3)
+	if !s.IsRoomMember("r", "u") {
+		t.Error("member u was lost on reopen")
+	}
+	if s.IsRoomMember("r", "v") {
+		t.Error("removed member v came back on reopen")
+	}
+	if got := s.CountMessages("r"); got != 1 {
+		t.Errorf("CountMessages = %d, want 1", got)
+	}
+}
+
+func TestFileStoreCompactionFailure(t *testing.T) {
+	dir := t.TempDir()
+	s := openFileStore(t, dir, 2)
+	// スナップショットの位置に空でないディレクトリを置き、リネームを失敗させる
+	blocker := filepath.Join(dir, "snapshot.json")
+	if err := os.MkdirAll(filepath.Join(blocker, "x"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	// コンパクションに失敗しても書き込みは成功する
+	if err := s.SaveRoom(&model.Room{ID: "r"}); err != nil {
+		t.Fatalf("SaveRoom: %v", err)
+	}
+	if err := s.AddRoomMember("r", "u"); err != nil {
+		t.Fatalf("AddRoomMember at the threshold: %v", err)
+	}
+	if logs, _ := filepath.Glob(filepath.Join(dir, "wal-*.log")); len(logs) != 1 {
+		t.Errorf("log files after failed compaction = %v, want 1", logs)
+	}
+
+	// 次の閾値（さらに2件後）で再試行する
+	if err := os.RemoveAll(blocker); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddRoomMember("r", "v"); err != nil {
+		t.Fatalf("AddRoomMember: %v", err)
+	}
+	if _, err := os.Stat(blocker); !os.IsNotExist(err) {
+		t.Fatalf("compaction retried before the next threshold: %v", err)
+	}
+	if err := s.SaveMessage(&model.Message{ID: "m", RoomID: "r"}); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+	if _, err := os.Stat(blocker); err != nil {
+		t.Fatalf("snapshot after retry: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	s = openFileStore(t, dir, 2)
+	if !s.IsRoomMember("r", "u") || !s.IsRoomMember("r", "v") {
+		t.Error("members were lost on reopen")
+	}
+	if got := s.CountMessages("r"); got != 1 {
+		t.Errorf("CountMessages = %d, want 1", got)
+	}
+}
+
+func TestFileStoreCorruptLog(t *testing.T) {
+	dir := t.TempDir()
+	s := openFileStore(t, dir, 100)
+	s.SaveRoom(&model.Room{ID: "r"})
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	logs, err := filepath.Glob(filepath.Join(dir, "wal-*.log"))
+	if err != nil || len(logs) != 1 {
+		t.Fatalf("log files = %v, %v", logs, err)
+	}
+	f, err := os.OpenFile(logs[0], os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 改行で終わる壊れたレコードの後ろに有効なレコードがある
+	if _, err := f.WriteString("{broken\n" + `{"op":"noop"}` + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	s, err = store.NewFileStore(dir, 100)
+	if err == nil {
+		s.Close()
+		t.Fatal("NewFileStore succeeded on a corrupt log")
+	}
+	if !strings.Contains(err.Error(), "corrupt log") {
+		t.Errorf("NewFileStore error = %v, want a corrupt log error", err)
+	}
+}
@@ -1,6 +1,8 @@
 package store
 
 import (
+	"errors"
+	"sort"
 	"sync"
 
 	"github.com/kajidog/graphql-sse-test/apps/backend/graph/model"
@@ -9,24 +11,102 @@ import (
 // Store はデータストレージのインターフェース
 type Store interface {
 	GetUser(id string) (*model.User, bool)
-	GetUserByNickname(nickname string) (*model.User, bool)
-	SaveUser(user *model.User)
+	GetUserByUsername(username string) (*model.User, bool)
+	// SaveUser は他のユーザーが同じ Username を使っている場合 ErrUsernameTaken を返す
+	SaveUser(user *model.User) error
+	GetCredential(userID string) (*model.Credential, bool)
+	SaveCredential(credential *model.Credential) error
+	GetSanction(userID string, kind model.SanctionKind) (*model.Sanction, bool)
+	SaveSanction(sanction *model.Sanction) error
+	DeleteSanction(userID string, kind model.SanctionKind) error
+	// ListModerationActions は新しい順に最大 limit 件取得（targetUserID が空の場合は全ユーザー）
+	ListModerationActions(targetUserID string, limit int) []*model.ModerationAction
+	SaveModerationAction(action *model.ModerationAction) error
+
+	GetRoom(id string) (*model.Room, bool)
+	ListRooms() []*model.Room
+	ListUserRooms(userID string) []*model.Room
+	SaveRoom(room *model.Room) error
+	AddRoomMember(roomID, userID string) error
+	RemoveRoomMember(roomID, userID string) error
+	IsRoomMember(roomID, userID string) bool
+	ListRoomMembers(roomID string) []string
+
+	GetMessage(id string) (*model.Message, bool)
 	GetMessages() []*model.Message
-	SaveMessage(msg *model.Message)
+	GetMessagesAfter(seq int64) []*model.Message
+	ListMessages(r MessageRange) []*model.Message
+	CountMessages(roomID string) int
+	SaveMessage(msg *model.Message) error
+	EditMessage(id, content, editedAt string) (*model.Message, error)
+	DeleteMessage(id, deletedAt string) (*model.Message, error)
+	ListMessageEdits(messageID string) []*model.MessageEdit
+
+	GetSession(id string) (*model.Session, bool)
+	ListUserSessions(userID string) []*model.Session
+	SaveSession(session *model.Session) error
+}
+
+// ErrMessageNotFound はメッセージが存在しない
+var ErrMessageNotFound = errors.New("message not found")
+
+// ErrMessageDeleted は削除済みのメッセージを編集しようとした
+var ErrMessageDeleted = errors.New("cannot edit a deleted message")
+
+// ErrUsernameTaken はユーザー名が他のユーザーに使われている
+var ErrUsernameTaken = errors.New("username already taken")
+
+// LegacyUsernamePrefix はニックネームをユーザー名にできなかった、ユーザー名導入前のユーザーのユーザー名の接頭辞
+//
+// 後ろにユーザーIDを付ける。登録できるユーザー名に含まれない文字を使うため、登録・ログインのユーザー名と衝突しない
+const LegacyUsernamePrefix = "legacy-"
+
+// MessageRange はメッセージを連番で範囲指定する条件
+//
+// After と Before は境界を含まない。結果は常に連番の昇順で返す。
+type MessageRange struct {
+	// RoomID は対象のルーム（空の場合は全ルーム）
+	RoomID string
+	// After はこの連番より後のメッセージに限定する（0は先頭から）
+	After int64
+	// Before はこの連番より前のメッセージに限定する（0は末尾まで）
+	Before int64
+	// Limit は最大件数（0以下は無制限）
+	Limit int
+	// FromEnd がtrueの場合は範囲の末尾からLimit件を取る
+	FromEnd bool
 }
 
 // MemoryStore はインメモリストレージの実装
 type MemoryStore struct {
 	users    map[string]*model.User
+	rooms    map[string]*model.Room
+	members  map[string]map[string]struct{}
 	messages []*model.Message
-	mu       sync.RWMutex
+	// ルームごとのメッセージ（連番順）
+	roomMessages map[string][]*model.Message
+	// メッセージIDごとの編集履歴（古い順）
+	edits       map[string][]*model.MessageEdit
+	sessions    map[string]*model.Session
+	credentials map[string]*model.Credential
+	sanctions   map[sanctionKey]*model.Sanction
+	// モデレーション操作の監査ログ（古い順）
+	moderationActions []*model.ModerationAction
+	mu                sync.RWMutex
 }
 
 // NewMemoryStore は新しいMemoryStoreを作成
 func NewMemoryStore() *MemoryStore {
 	return &MemoryStore{
-		users:    make(map[string]*model.User),
-		messages: make([]*model.Message, 0),
+		users:        make(map[string]*model.User),
+		rooms:        make(map[string]*model.Room),
+		members:      make(map[string]map[string]struct{}),
+		messages:     make([]*model.Message, 0),
+		roomMessages: make(map[string][]*model.Message),
+		edits:        make(map[string][]*model.MessageEdit),
+		sessions:     make(map[string]*model.Session),
+		credentials:  make(map[string]*model.Credential),
+		sanctions:    make(map[sanctionKey]*model.Sanction),
 	}
 }
 
@@ -38,35 +118,391 @@ func (s *MemoryStore) GetUser(id string) (*model.User, bool) {
 	return user, ok
 }
 
-// GetUserByNickname はニックネームでユーザーを検索
-func (s *MemoryStore) GetUserByNickname(nickname string) (*model.User, bool) {
+// GetUserByUsername はユーザー名でユーザーを検索
+func (s *MemoryStore) GetUserByUsername(username string) (*model.User, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	return s.findUserByUsername(username)
+}
+
+// SaveUser はユーザーを保存
+func (s *MemoryStore) SaveUser(user *model.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if other, ok := s.findUserByUsername(user.Username); ok && other.ID != user.ID {
+		return ErrUsernameTaken
+	}
+	s.users[user.ID] = user
+	return nil
+}
+
+// findUserByUsername はユーザー名でユーザーを探す（ロックを取得済みであること）
+func (s *MemoryStore) findUserByUsername(username string) (*model.User, bool) {
+	if username == "" {
+		return nil, false
+	}
 	for _, u := range s.users {
-		if u.Nickname == nickname {
+		if u.Username == username {
 			return u, true
 		}
 	}
 	return nil, false
 }
 
-// SaveUser はユーザーを保存
-func (s *MemoryStore) SaveUser(user *model.User) {
+// GetCredential はユーザーの認証情報を取得
+func (s *MemoryStore) GetCredential(userID string) (*model.Credential, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	credential, ok := s.credentials[userID]
+	return credential, ok
+}
+
+// SaveCredential は認証情報を保存（既存の場合は更新）
+func (s *MemoryStore) SaveCredential(credential *model.Credential) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.users[user.ID] = user
+	s.credentials[credential.UserID] = credential
+	return nil
+}
+
+// GetRoom はIDでルームを取得
+func (s *MemoryStore) GetRoom(id string) (*model.Room, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	room, ok := s.rooms[id]
+	return room, ok
+}
+
+// ListRooms は全ルームを作成日時順に取得
+func (s *MemoryStore) ListRooms() []*model.Room {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rooms := make([]*model.Room, 0, len(s.rooms))
+	for _, r := range s.rooms {
+		rooms = append(rooms, r)
+	}
+	sortRooms(rooms)
+	return rooms
+}
+
+// sortRooms はルームを作成日時順（同時刻はID順）に並べる
+func sortRooms(rooms []*model.Room) {
+	sort.Slice(rooms, func(i, j int) bool {
+		if rooms[i].CreatedAt != rooms[j].CreatedAt {
+			return rooms[i].CreatedAt < rooms[j].CreatedAt
+		}
+		return rooms[i].ID < rooms[j].ID
+	})
+}
+
+// ListUserRooms はユーザーがメンバーになっているルームを作成日時順に取得
+func (s *MemoryStore) ListUserRooms(userID string) []*model.Room {
+	s.mu.RLock()
+	rooms := make([]*model.Room, 0)
+	for roomID, members := range s.members {
+		if _, ok := members[userID]; !ok {
+			continue
+		}
+		if r, ok := s.rooms[roomID]; ok {
+			rooms = append(rooms, r)
+		}
+	}
+	s.mu.RUnlock()
+	sortRooms(rooms)
+	return rooms
+}
+
+// SaveRoom はルームを保存（既存の場合は更新）
+func (s *MemoryStore) SaveRoom(room *model.Room) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rooms[room.ID] = room
+	return nil
+}
+
+// AddRoomMember はユーザーをルームのメンバーに追加
+func (s *MemoryStore) AddRoomMember(roomID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	members, ok := s.members[roomID]
+	if !ok {
+		members = make(map[string]struct{})
+		s.members[roomID] = members
+	}
+	members[userID] = struct{}{}
+	return nil
+}
+
+// RemoveRoomMember はユーザーをルームのメンバーから外す
+func (s *MemoryStore) RemoveRoomMember(roomID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.members[roomID], userID)
+	return nil
+}
+
+// IsRoomMember はユーザーがルームのメンバーかどうか
+func (s *MemoryStore) IsRoomMember(roomID, userID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.members[roomID][userID]
+	return ok
+}
+
+// ListRoomMembers はルームのメンバーのユーザーIDを取得
+func (s *MemoryStore) ListRoomMembers(roomID string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	userIDs := make([]string, 0, len(s.members[roomID]))
+	for id := range s.members[roomID] {
+		userIDs = append(userIDs, id)
+	}
+	sort.Strings(userIDs)
+	return userIDs
+}
+
+// GetMessage はIDでメッセージを取得
+func (s *MemoryStore) GetMessage(id string) (*model.Message, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.findMessage(id)
 }
 
 // GetMessages は全メッセージを取得
 func (s *MemoryStore) GetMessages() []*model.Message {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.messages
+	// 編集・削除で要素が差し替えられるため、コピーを返す
+	messages := make([]*model.Message, len(s.messages))
+	copy(messages, s.messages)
+	return messages
 }
 
-// SaveMessage はメッセージを保存
-func (s *MemoryStore) SaveMessage(msg *model.Message) {
+// GetMessagesAfter は指定した連番より後のメッセージを取得
+func (s *MemoryStore) GetMessagesAfter(seq int64) []*model.Message {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if seq < 0 {
+		seq = 0
+	}
+	if seq >= int64(len(s.messages)) {
+		return nil
+	}
+	// 連番は1始まりで、messages[i].Seq == i+1
+	messages := make([]*model.Message, len(s.messages)-int(seq))
+	copy(messages, s.messages[seq:])
+	return messages
+}
+
+// ListMessages は範囲内のメッセージを取得
+func (s *MemoryStore) ListMessages(r MessageRange) []*model.Message {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	all := s.messages
+	if r.RoomID != "" {
+		all = s.roomMessages[r.RoomID]
+	}
+
+	start := sort.Search(len(all), func(i int) bool { return all[i].Seq > r.After })
+	end := len(all)
+	if r.Before > 0 {
+		end = sort.Search(len(all), func(i int) bool { return all[i].Seq >= r.Before })
+	}
+	if start >= end {
+		return nil
+	}
+	if r.Limit > 0 && end-start > r.Limit {
+		if r.FromEnd {
+			start = end - r.Limit
+		} else {
+			end = start + r.Limit
+		}
+	}
+
+	messages := make([]*model.Message, end-start)
+	copy(messages, all[start:end])
+	return messages
+}
+
+// CountMessages はメッセージの件数を取得（roomIDが空の場合は全ルーム）
+func (s *MemoryStore) CountMessages(roomID string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if roomID != "" {
+		return len(s.roomMessages[roomID])
+	}
+	return len(s.messages)
+}
+
+// SaveMessage はメッセージに連番を採番して保存
+func (s *MemoryStore) SaveMessage(msg *model.Message) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	msg.Seq = int64(len(s.messages)) + 1
 	s.messages = append(s.messages, msg)
+	s.roomMessages[msg.RoomID] = append(s.roomMessages[msg.RoomID], msg)
+	return nil
+}
+
+// EditMessage は本文を更新し、更新前の本文を編集履歴に追加（削除済みの場合は ErrMessageDeleted）
+func (s *MemoryStore) EditMessage(id, content, editedAt string) (*model.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msg, ok := s.findMessage(id)
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	if msg.Deleted() {
+		return nil, ErrMessageDeleted
+	}
+
+	s.edits[id] = append(s.edits[id], &model.MessageEdit{Content: msg.Content, EditedAt: editedAt})
+	edited := *msg
+	edited.Content = content
+	edited.EditedAt = &editedAt
+	s.replaceMessage(&edited)
+	return &edited, nil
+}
+
+// DeleteMessage は本文と編集履歴を消して墓標にする
+func (s *MemoryStore) DeleteMessage(id, deletedAt string) (*model.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msg, ok := s.findMessage(id)
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+
+	delete(s.edits, id)
+	deleted := *msg
+	deleted.Content = ""
+	deleted.DeletedAt = &deletedAt
+	s.replaceMessage(&deleted)
+	return &deleted, nil
+}
+
+// ListMessageEdits はメッセージの編集履歴を古い順に取得
+func (s *MemoryStore) ListMessageEdits(messageID string) []*model.MessageEdit {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	edits := make([]*model.MessageEdit, len(s.edits[messageID]))
+	copy(edits, s.edits[messageID])
+	return edits
+}
+
+// GetSession はIDでセッションを取得
+func (s *MemoryStore) GetSession(id string) (*model.Session, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	session, ok := s.sessions[id]
+	return session, ok
+}
+
+// ListUserSessions はユーザーのセッションを作成日時順に取得
+func (s *MemoryStore) ListUserSessions(userID string) []*model.Session {
+	s.mu.RLock()
+	sessions := make([]*model.Session, 0)
+	for _, session := range s.sessions {
+		if session.UserID == userID {
+			sessions = append(sessions, session)
+		}
+	}
+	s.mu.RUnlock()
+	sortSessions(sessions)
+	return sessions
+}
+
+// sortSessions はセッションを作成日時順（同時刻はID順）に並べる
+func sortSessions(sessions []*model.Session) {
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].CreatedAt != sessions[j].CreatedAt {
+			return sessions[i].CreatedAt < sessions[j].CreatedAt
+		}
+		return sessions[i].ID < sessions[j].ID
+	})
+}
+
+// SaveSession はセッションを保存（既存の場合は更新）
+func (s *MemoryStore) SaveSession(session *model.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[session.ID] = session
+	return nil
+}
+
+// sanctionKey は制裁のキー（ユーザーごと・種類ごとに1つ）
+type sanctionKey struct {
+	userID string
+	kind   model.SanctionKind
+}
+
+// GetSanction はユーザーに科されている制裁を取得（期限切れも含む）
+func (s *MemoryStore) GetSanction(userID string, kind model.SanctionKind) (*model.Sanction, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sanction, ok := s.sanctions[sanctionKey{userID, kind}]
+	return sanction, ok
+}
+
+// SaveSanction は制裁を保存（同じ種類の制裁があれば置き換える）
+func (s *MemoryStore) SaveSanction(sanction *model.Sanction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sanctions[sanctionKey{sanction.UserID, sanction.Kind}] = sanction
+	return nil
+}
+
+// DeleteSanction は制裁を解除（無い場合は何もしない）
+func (s *MemoryStore) DeleteSanction(userID string, kind model.SanctionKind) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sanctions, sanctionKey{userID, kind})
+	return nil
+}
+
+// ListModerationActions はモデレーション操作を新しい順に最大 limit 件取得
+func (s *MemoryStore) ListModerationActions(targetUserID string, limit int) []*model.ModerationAction {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	actions := make([]*model.ModerationAction, 0)
+	for i := len(s.moderationActions) - 1; i >= 0 && len(actions) < limit; i-- {
+		if a := s.moderationActions[i]; targetUserID == "" || a.TargetUserID == targetUserID {
+			actions = append(actions, a)
+		}
+	}
+	return actions
+}
+
+// SaveModerationAction はモデレーション操作を監査ログに追加
+func (s *MemoryStore) SaveModerationAction(action *model.ModerationAction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.moderationActions = append(s.moderationActions, action)
+	return nil
+}
+
+// findMessage はIDでメッセージを探す（ロックを取得済みであること）
+//
+// IDの索引は持たず、新しいものから探す（編集・削除は最近のメッセージが対象になりやすい）
+func (s *MemoryStore) findMessage(id string) (*model.Message, bool) {
+	for i := len(s.messages) - 1; i >= 0; i-- {
+		if s.messages[i].ID == id {
+			return s.messages[i], true
+		}
+	}
+	return nil, false
+}
+
+// replaceMessage は同じ連番のメッセージを置き換える（ロックを取得済みであること）
+//
+// 取得済みのメッセージを書き換えないよう、更新はコピーを差し替えて行う
+func (s *MemoryStore) replaceMessage(msg *model.Message) {
+	// 連番は1始まりで、messages[i].Seq == i+1
+	s.messages[msg.Seq-1] = msg
+	room := s.roomMessages[msg.RoomID]
+	i := sort.Search(len(room), func(i int) bool { return room[i].Seq >= msg.Seq })
+	if i < len(room) && room[i].Seq == msg.Seq {
+		room[i] = msg
+	}
 }
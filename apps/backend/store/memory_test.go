@@ -0,0 +1,12 @@
+package store_test
+
+import (
+	"testing"
+
+	"github.com/kajidog/graphql-sse-test/apps/backend/store"
+	"github.com/kajidog/graphql-sse-test/apps/backend/store/storetest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store { return store.NewMemoryStore() })
+}
@@ -0,0 +1,232 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// migration はスキーマの1バージョン分の変更
+type migration struct {
+	version    int
+	name       string
+	statements []string
+}
+
+// migrations はSQLストアのスキーマ変更履歴（追記のみ。適用済みのものは変更しない）
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "create users and messages",
+		statements: []string{
+			`CREATE TABLE users (
+				id       TEXT PRIMARY KEY,
+				nickname TEXT NOT NULL
+			)`,
+			// ログイン時のニックネーム検索用
+			`CREATE INDEX idx_users_nickname ON users (nickname)`,
+			// seq は送信順の連番（主キーなので ORDER BY seq / seq > ? はインデックスで解決される）
+			`CREATE TABLE messages (
+				seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+				id         TEXT NOT NULL UNIQUE,
+				user_id    TEXT NOT NULL REFERENCES users (id),
+				content    TEXT NOT NULL,
+				created_at TEXT NOT NULL
+			)`,
+			`CREATE INDEX idx_messages_user_id ON messages (user_id, seq)`,
+		},
+	},
+	{
+		version: 2,
+		name:    "add rooms",
+		statements: []string{
+			`CREATE TABLE rooms (
+				id          TEXT PRIMARY KEY,
+				name        TEXT NOT NULL,
+				created_by  TEXT REFERENCES users (id),
+				created_at  TEXT NOT NULL,
+				archived_at TEXT
+			)`,
+			`CREATE TABLE room_members (
+				room_id TEXT NOT NULL REFERENCES rooms (id),
+				user_id TEXT NOT NULL REFERENCES users (id),
+				PRIMARY KEY (room_id, user_id)
+			)`,
+			// 既存のメッセージとユーザーは既定のルーム（model.DefaultRoomID）に移す
+			`INSERT INTO rooms (id, name, created_at)
+				SELECT 'general', 'general', strftime('%Y-%m-%dT%H:%M:%SZ', 'now')
+				WHERE EXISTS (SELECT 1 FROM users)`,
+			`INSERT INTO room_members (room_id, user_id) SELECT 'general', id FROM users`,
+			// 外部キー付きの列は既定値付きで追加できないため、room_id は参照制約なし
+			`ALTER TABLE messages ADD COLUMN room_id TEXT NOT NULL DEFAULT 'general'`,
+			`CREATE INDEX idx_messages_room_id ON messages (room_id, seq)`,
+		},
+	},
+	{
+		version: 3,
+		name:    "add room kind",
+		statements: []string{
+			`ALTER TABLE rooms ADD COLUMN kind TEXT NOT NULL DEFAULT 'CHANNEL'`,
+			// ユーザーの参加ルーム一覧用
+			`CREATE INDEX idx_room_members_user_id ON room_members (user_id)`,
+		},
+	},
+	{
+		version: 4,
+		name:    "add message edits",
+		statements: []string{
+			`ALTER TABLE messages ADD COLUMN edited_at TEXT`,
+			`ALTER TABLE messages ADD COLUMN deleted_at TEXT`,
+			// 編集前の本文の履歴（id順が編集順）
+			`CREATE TABLE message_edits (
+				id         INTEGER PRIMARY KEY AUTOINCREMENT,
+				message_id TEXT NOT NULL REFERENCES messages (id),
+				content    TEXT NOT NULL,
+				edited_at  TEXT NOT NULL
+			)`,
+			`CREATE INDEX idx_message_edits_message_id ON message_edits (message_id, id)`,
+		},
+	},
+	{
+		version: 5,
+		name:    "add sessions",
+		statements: []string{
+			`CREATE TABLE sessions (
+				id                 TEXT PRIMARY KEY,
+				user_id            TEXT NOT NULL REFERENCES users (id),
+				refresh_token_hash TEXT NOT NULL,
+				created_at         TEXT NOT NULL,
+				expires_at         TEXT NOT NULL,
+				revoked_at         TEXT
+			)`,
+			// ユーザーの全セッションのログアウト用
+			`CREATE INDEX idx_sessions_user_id ON sessions (user_id)`,
+		},
+	},
+	{
+		version: 6,
+		name:    "add usernames and credentials",
+		statements: []string{
+			// 既存のユーザーはニックネームをユーザー名にする（ニックネームはログイン時に一意だった）
+			`ALTER TABLE users ADD COLUMN username TEXT NOT NULL DEFAULT ''`,
+			`UPDATE users SET username = nickname`,
+			`CREATE UNIQUE INDEX idx_users_username ON users (username)`,
+			// ニックネームは表示名になり、検索しない
+			`DROP INDEX IF EXISTS idx_users_nickname`,
+			`CREATE TABLE credentials (
+				user_id         TEXT PRIMARY KEY REFERENCES users (id),
+				password_hash   TEXT NOT NULL,
+				failed_attempts INTEGER NOT NULL DEFAULT 0,
+				locked_until    TEXT,
+				updated_at      TEXT NOT NULL
+			)`,
+		},
+	},
+	{
+		version: 7,
+		name:    "add user roles",
+		statements: []string{
+			`ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'MEMBER'`,
+		},
+	},
+	{
+		version: 8,
+		name:    "add moderation",
+		statements: []string{
+			`CREATE TABLE sanctions (
+				user_id    TEXT NOT NULL REFERENCES users (id),
+				kind       TEXT NOT NULL,
+				reason     TEXT NOT NULL,
+				created_by TEXT NOT NULL REFERENCES users (id),
+				created_at TEXT NOT NULL,
+				expires_at TEXT,
+				PRIMARY KEY (user_id, kind)
+			)`,
+			// seq は追加順の連番（新しい順の取得に使う）
+			`CREATE TABLE moderation_actions (
+				seq            INTEGER PRIMARY KEY AUTOINCREMENT,
+				id             TEXT NOT NULL UNIQUE,
+				action         TEXT NOT NULL,
+				moderator_id   TEXT NOT NULL REFERENCES users (id),
+				target_user_id TEXT NOT NULL REFERENCES users (id),
+				message_id     TEXT,
+				reason         TEXT,
+				expires_at     TEXT,
+				created_at     TEXT NOT NULL
+			)`,
+			`CREATE INDEX idx_moderation_actions_target ON moderation_actions (target_user_id, seq)`,
+		},
+	},
+	{
+		version: 9,
+		name:    "normalize legacy usernames",
+		statements: []string{
+			// v6 でニックネームをそのまま使ったユーザー名（パスワードの無いユーザーのもの）を登録時と同じ形式にする。
+			// ユーザー名に使えない文字を含むもの・小文字にするとパスワードのあるユーザーや先に作られたユーザーと
+			// 重複するものは、登録できないユーザー名（legacy-<ID>）にして区別する（外部の利用者の ext-<ID> は対象外）
+			`UPDATE users SET username = '` + LegacyUsernamePrefix + `' || id
+			 WHERE NOT EXISTS (SELECT 1 FROM credentials WHERE credentials.user_id = users.id)
+			   AND username NOT GLOB '` + LegacyUsernamePrefix + `*'
+			   AND username NOT GLOB 'ext-*'
+			   AND (length(lower(trim(username))) NOT BETWEEN 3 AND 32
+			    OR lower(trim(username)) GLOB '*[^a-z0-9_]*'
+			    OR EXISTS (SELECT 1 FROM users other
+			               WHERE other.id <> users.id
+			                 AND lower(trim(other.username)) = lower(trim(users.username))
+			                 AND (other.rowid < users.rowid
+			                  OR EXISTS (SELECT 1 FROM credentials WHERE credentials.user_id = other.id))))`,
+			`UPDATE users SET username = lower(trim(username))
+			 WHERE NOT EXISTS (SELECT 1 FROM credentials WHERE credentials.user_id = users.id)
+			   AND username NOT GLOB '` + LegacyUsernamePrefix + `*'
+			   AND username NOT GLOB 'ext-*'`,
+		},
+	},
+}
+
+// migrate は未適用のマイグレーションを順に適用
+func migrate(db *sql.DB) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at TEXT NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	var current int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return fmt.Errorf("read schema version: %w", err)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := applyMigration(db, m); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+	}
+	return nil
+}
+
+// applyMigration は1つのマイグレーションをトランザクション内で適用
+func applyMigration(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range m.statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+		m.version, m.name, time.Now().UTC().Format(time.RFC3339),
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,633 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/kajidog/graphql-sse-test/apps/backend/graph/model"
+
+	// SQLiteドライバー（cgo不要）
+	_ "modernc.org/sqlite"
+)
+
+// SQLStore は database/sql を使ったストレージの実装
+//
+// クエリは "?" プレースホルダーと ON CONFLICT / RETURNING を使う（SQLiteで動作確認）。
+// Store インターフェースの読み込みメソッドはエラーを返さないため、読み込みエラーはログに出して空の結果を返す。
+type SQLStore struct {
+	db *sql.DB
+}
+
+// NewSQLStore はマイグレーションを適用してSQLStoreを作成
+func NewSQLStore(db *sql.DB) (*SQLStore, error) {
+	if err := migrate(db); err != nil {
+		return nil, err
+	}
+	return &SQLStore{db: db}, nil
+}
+
+// OpenSQLite はSQLiteデータベースを開いてSQLStoreを作成
+//
+// pathに ":memory:" を指定するとインメモリデータベースになる
+func OpenSQLite(path string) (*SQLStore, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite: %w", err)
+	}
+	// SQLiteは書き込みが直列化されるため接続を1本にする（":memory:" は接続ごとに別DBになる）
+	db.SetMaxOpenConns(1)
+
+	s, err := NewSQLStore(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close はデータベース接続を閉じる
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+// GetUser はIDでユーザーを取得
+func (s *SQLStore) GetUser(id string) (*model.User, bool) {
+	return s.queryUser(`SELECT id, username, nickname, role FROM users WHERE id = ?`, id)
+}
+
+// GetUserByUsername はユーザー名でユーザーを検索
+func (s *SQLStore) GetUserByUsername(username string) (*model.User, bool) {
+	return s.queryUser(`SELECT id, username, nickname, role FROM users WHERE username = ?`, username)
+}
+
+func (s *SQLStore) queryUser(query string, args ...interface{}) (*model.User, bool) {
+	var u model.User
+	err := s.db.QueryRow(query, args...).Scan(&u.ID, &u.Username, &u.Nickname, &u.Role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("[SQLStore] query user: %v", err)
+		return nil, false
+	}
+	return &u, true
+}
+
+// SaveUser はユーザーを保存（既存の場合は更新）
+func (s *SQLStore) SaveUser(user *model.User) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		var otherID string
+		err := tx.QueryRow(`SELECT id FROM users WHERE username = ? AND id <> ?`, user.Username, user.ID).Scan(&otherID)
+		if err == nil {
+			return ErrUsernameTaken
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("check username: %w", err)
+		}
+
+		_, err = tx.Exec(
+			`INSERT INTO users (id, username, nickname, role) VALUES (?, ?, ?, ?)
+			 ON CONFLICT (id) DO UPDATE SET username = excluded.username, nickname = excluded.nickname, role = excluded.role`,
+			user.ID, user.Username, user.Nickname, user.Role,
+		)
+		if err != nil {
+			return fmt.Errorf("save user: %w", err)
+		}
+		return nil
+	})
+}
+
+// GetCredential はユーザーの認証情報を取得
+func (s *SQLStore) GetCredential(userID string) (*model.Credential, bool) {
+	var (
+		c           model.Credential
+		lockedUntil sql.NullString
+	)
+	err := s.db.QueryRow(
+		`SELECT user_id, password_hash, failed_attempts, locked_until, updated_at FROM credentials WHERE user_id = ?`, userID,
+	).Scan(&c.UserID, &c.PasswordHash, &c.FailedAttempts, &lockedUntil, &c.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("[SQLStore] query credential: %v", err)
+		return nil, false
+	}
+	if lockedUntil.Valid {
+		c.LockedUntil = &lockedUntil.String
+	}
+	return &c, true
+}
+
+// SaveCredential は認証情報を保存（既存の場合は更新）
+func (s *SQLStore) SaveCredential(credential *model.Credential) error {
+	_, err := s.db.Exec(
+		`INSERT INTO credentials (user_id, password_hash, failed_attempts, locked_until, updated_at) VALUES (?, ?, ?, ?, ?)
+		 ON CONFLICT (user_id) DO UPDATE SET password_hash = excluded.password_hash, failed_attempts = excluded.failed_attempts, locked_until = excluded.locked_until, updated_at = excluded.updated_at`,
+		credential.UserID, credential.PasswordHash, credential.FailedAttempts, credential.LockedUntil, credential.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("save credential: %w", err)
+	}
+	return nil
+}
+
+// GetRoom はIDでルームを取得
+func (s *SQLStore) GetRoom(id string) (*model.Room, bool) {
+	rooms := s.queryRooms(`SELECT `+roomColumns+` FROM rooms WHERE id = ?`, id)
+	if len(rooms) == 0 {
+		return nil, false
+	}
+	return rooms[0], true
+}
+
+// ListRooms は全ルームを作成日時順に取得
+func (s *SQLStore) ListRooms() []*model.Room {
+	return s.queryRooms(`SELECT ` + roomColumns + ` FROM rooms ORDER BY created_at, id`)
+}
+
+// ListUserRooms はユーザーがメンバーになっているルームを作成日時順に取得
+func (s *SQLStore) ListUserRooms(userID string) []*model.Room {
+	return s.queryRooms(`SELECT `+roomColumns+` FROM rooms
+		WHERE id IN (SELECT room_id FROM room_members WHERE user_id = ?)
+		ORDER BY created_at, id`, userID)
+}
+
+const roomColumns = `id, kind, name, COALESCE(created_by, ''), created_at, archived_at`
+
+func (s *SQLStore) queryRooms(query string, args ...interface{}) []*model.Room {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		log.Printf("[SQLStore] query rooms: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	rooms := make([]*model.Room, 0)
+	for rows.Next() {
+		var (
+			r          model.Room
+			archivedAt sql.NullString
+		)
+		if err := rows.Scan(&r.ID, &r.Kind, &r.Name, &r.CreatedBy, &r.CreatedAt, &archivedAt); err != nil {
+			log.Printf("[SQLStore] scan room: %v", err)
+			return nil
+		}
+		if archivedAt.Valid {
+			r.ArchivedAt = &archivedAt.String
+		}
+		rooms = append(rooms, &r)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("[SQLStore] query rooms: %v", err)
+		return nil
+	}
+	return rooms
+}
+
+// SaveRoom はルームを保存（既存の場合は更新）
+func (s *SQLStore) SaveRoom(room *model.Room) error {
+	var createdBy sql.NullString
+	if room.CreatedBy != "" {
+		createdBy = sql.NullString{String: room.CreatedBy, Valid: true}
+	}
+	_, err := s.db.Exec(
+		`INSERT INTO rooms (id, kind, name, created_by, created_at, archived_at) VALUES (?, ?, ?, ?, ?, ?)
+		 ON CONFLICT (id) DO UPDATE SET name = excluded.name, archived_at = excluded.archived_at`,
+		room.ID, room.Kind, room.Name, createdBy, room.CreatedAt, room.ArchivedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("save room: %w", err)
+	}
+	return nil
+}
+
+// AddRoomMember はユーザーをルームのメンバーに追加
+func (s *SQLStore) AddRoomMember(roomID, userID string) error {
+	_, err := s.db.Exec(
+		`INSERT INTO room_members (room_id, user_id) VALUES (?, ?) ON CONFLICT DO NOTHING`,
+		roomID, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("add room member: %w", err)
+	}
+	return nil
+}
+
+// RemoveRoomMember はユーザーをルームのメンバーから外す
+func (s *SQLStore) RemoveRoomMember(roomID, userID string) error {
+	if _, err := s.db.Exec(`DELETE FROM room_members WHERE room_id = ? AND user_id = ?`, roomID, userID); err != nil {
+		return fmt.Errorf("remove room member: %w", err)
+	}
+	return nil
+}
+
+// IsRoomMember はユーザーがルームのメンバーかどうか
+func (s *SQLStore) IsRoomMember(roomID, userID string) bool {
+	var exists int
+	err := s.db.QueryRow(`SELECT 1 FROM room_members WHERE room_id = ? AND user_id = ?`, roomID, userID).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false
+	}
+	if err != nil {
+		log.Printf("[SQLStore] query room member: %v", err)
+		return false
+	}
+	return true
+}
+
+// ListRoomMembers はルームのメンバーのユーザーIDを取得
+func (s *SQLStore) ListRoomMembers(roomID string) []string {
+	rows, err := s.db.Query(`SELECT user_id FROM room_members WHERE room_id = ? ORDER BY user_id`, roomID)
+	if err != nil {
+		log.Printf("[SQLStore] query room members: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	userIDs := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			log.Printf("[SQLStore] scan room member: %v", err)
+			return nil
+		}
+		userIDs = append(userIDs, id)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("[SQLStore] query room members: %v", err)
+		return nil
+	}
+	return userIDs
+}
+
+const messageColumns = `seq, id, room_id, user_id, content, created_at, edited_at, deleted_at`
+
+// GetMessage はIDでメッセージを取得
+func (s *SQLStore) GetMessage(id string) (*model.Message, bool) {
+	messages := s.queryMessages(`SELECT `+messageColumns+` FROM messages WHERE id = ?`, id)
+	if len(messages) == 0 {
+		return nil, false
+	}
+	return messages[0], true
+}
+
+// GetMessages は全メッセージを送信順に取得
+func (s *SQLStore) GetMessages() []*model.Message {
+	return s.queryMessages(`SELECT ` + messageColumns + ` FROM messages ORDER BY seq`)
+}
+
+// GetMessagesAfter は指定した連番より後のメッセージを取得
+func (s *SQLStore) GetMessagesAfter(seq int64) []*model.Message {
+	return s.queryMessages(`SELECT `+messageColumns+` FROM messages WHERE seq > ? ORDER BY seq`, seq)
+}
+
+// ListMessages は範囲内のメッセージを取得
+func (s *SQLStore) ListMessages(r MessageRange) []*model.Message {
+	var (
+		where []string
+		args  []interface{}
+	)
+	if r.RoomID != "" {
+		where = append(where, "room_id = ?")
+		args = append(args, r.RoomID)
+	}
+	if r.After > 0 {
+		where = append(where, "seq > ?")
+		args = append(args, r.After)
+	}
+	if r.Before > 0 {
+		where = append(where, "seq < ?")
+		args = append(args, r.Before)
+	}
+
+	query := `SELECT ` + messageColumns + ` FROM messages`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	// 末尾から取る場合は降順でLIMITをかけてから昇順に戻す
+	if r.FromEnd {
+		query += " ORDER BY seq DESC"
+	} else {
+		query += " ORDER BY seq"
+	}
+	if r.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, r.Limit)
+	}
+
+	messages := s.queryMessages(query, args...)
+	if r.FromEnd {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+	return messages
+}
+
+// CountMessages はメッセージの件数を取得（roomIDが空の場合は全ルーム）
+func (s *SQLStore) CountMessages(roomID string) int {
+	query, args := `SELECT COUNT(*) FROM messages`, []interface{}{}
+	if roomID != "" {
+		query += ` WHERE room_id = ?`
+		args = append(args, roomID)
+	}
+	var count int
+	if err := s.db.QueryRow(query, args...).Scan(&count); err != nil {
+		log.Printf("[SQLStore] count messages: %v", err)
+		return 0
+	}
+	return count
+}
+
+func (s *SQLStore) queryMessages(query string, args ...interface{}) []*model.Message {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		log.Printf("[SQLStore] query messages: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	messages := make([]*model.Message, 0)
+	for rows.Next() {
+		var (
+			m                   model.Message
+			editedAt, deletedAt sql.NullString
+		)
+		if err := rows.Scan(&m.Seq, &m.ID, &m.RoomID, &m.UserID, &m.Content, &m.CreatedAt, &editedAt, &deletedAt); err != nil {
+			log.Printf("[SQLStore] scan message: %v", err)
+			return nil
+		}
+		if editedAt.Valid {
+			m.EditedAt = &editedAt.String
+		}
+		if deletedAt.Valid {
+			m.DeletedAt = &deletedAt.String
+		}
+		messages = append(messages, &m)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("[SQLStore] query messages: %v", err)
+		return nil
+	}
+	return messages
+}
+
+// SaveMessage はメッセージを保存し、採番された連番を設定
+func (s *SQLStore) SaveMessage(msg *model.Message) error {
+	err := s.db.QueryRow(
+		`INSERT INTO messages (id, room_id, user_id, content, created_at) VALUES (?, ?, ?, ?, ?) RETURNING seq`,
+		msg.ID, msg.RoomID, msg.UserID, msg.Content, msg.CreatedAt,
+	).Scan(&msg.Seq)
+	if err != nil {
+		return fmt.Errorf("save message: %w", err)
+	}
+	return nil
+}
+
+// EditMessage は本文を更新し、更新前の本文を編集履歴に追加（削除済みの場合は ErrMessageDeleted）
+func (s *SQLStore) EditMessage(id, content, editedAt string) (*model.Message, error) {
+	err := s.inTx(func(tx *sql.Tx) error {
+		var deletedAt sql.NullString
+		if err := tx.QueryRow(`SELECT deleted_at FROM messages WHERE id = ?`, id).Scan(&deletedAt); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrMessageNotFound
+			}
+			return err
+		}
+		if deletedAt.Valid {
+			return ErrMessageDeleted
+		}
+		if _, err := tx.Exec(
+			`INSERT INTO message_edits (message_id, content, edited_at) SELECT id, content, ? FROM messages WHERE id = ?`,
+			editedAt, id,
+		); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`UPDATE messages SET content = ?, edited_at = ? WHERE id = ?`, content, editedAt, id)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("edit message: %w", err)
+	}
+	msg, _ := s.GetMessage(id)
+	return msg, nil
+}
+
+// DeleteMessage は本文と編集履歴を消して墓標にする
+func (s *SQLStore) DeleteMessage(id, deletedAt string) (*model.Message, error) {
+	err := s.inTx(func(tx *sql.Tx) error {
+		res, err := tx.Exec(`UPDATE messages SET content = '', deleted_at = ? WHERE id = ?`, deletedAt, id)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrMessageNotFound
+		}
+		_, err = tx.Exec(`DELETE FROM message_edits WHERE message_id = ?`, id)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("delete message: %w", err)
+	}
+	msg, _ := s.GetMessage(id)
+	return msg, nil
+}
+
+// ListMessageEdits はメッセージの編集履歴を古い順に取得
+func (s *SQLStore) ListMessageEdits(messageID string) []*model.MessageEdit {
+	rows, err := s.db.Query(`SELECT content, edited_at FROM message_edits WHERE message_id = ? ORDER BY id`, messageID)
+	if err != nil {
+		log.Printf("[SQLStore] query message edits: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	edits := make([]*model.MessageEdit, 0)
+	for rows.Next() {
+		var e model.MessageEdit
+		if err := rows.Scan(&e.Content, &e.EditedAt); err != nil {
+			log.Printf("[SQLStore] scan message edit: %v", err)
+			return nil
+		}
+		edits = append(edits, &e)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("[SQLStore] query message edits: %v", err)
+		return nil
+	}
+	return edits
+}
+
+const sessionColumns = `id, user_id, refresh_token_hash, created_at, expires_at, revoked_at`
+
+// GetSession はIDでセッションを取得
+func (s *SQLStore) GetSession(id string) (*model.Session, bool) {
+	sessions := s.querySessions(`SELECT `+sessionColumns+` FROM sessions WHERE id = ?`, id)
+	if len(sessions) == 0 {
+		return nil, false
+	}
+	return sessions[0], true
+}
+
+// ListUserSessions はユーザーのセッションを作成日時順に取得
+func (s *SQLStore) ListUserSessions(userID string) []*model.Session {
+	return s.querySessions(`SELECT `+sessionColumns+` FROM sessions WHERE user_id = ? ORDER BY created_at, id`, userID)
+}
+
+func (s *SQLStore) querySessions(query string, args ...interface{}) []*model.Session {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		log.Printf("[SQLStore] query sessions: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	sessions := make([]*model.Session, 0)
+	for rows.Next() {
+		var (
+			session   model.Session
+			revokedAt sql.NullString
+		)
+		if err := rows.Scan(&session.ID, &session.UserID, &session.RefreshTokenHash, &session.CreatedAt, &session.ExpiresAt, &revokedAt); err != nil {
+			log.Printf("[SQLStore] scan session: %v", err)
+			return nil
+		}
+		if revokedAt.Valid {
+			session.RevokedAt = &revokedAt.String
+		}
+		sessions = append(sessions, &session)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("[SQLStore] query sessions: %v", err)
+		return nil
+	}
+	return sessions
+}
+
+// SaveSession はセッションを保存（既存の場合は更新）
+func (s *SQLStore) SaveSession(session *model.Session) error {
+	_, err := s.db.Exec(
+		`INSERT INTO sessions (id, user_id, refresh_token_hash, created_at, expires_at, revoked_at) VALUES (?, ?, ?, ?, ?, ?)
+		 ON CONFLICT (id) DO UPDATE SET refresh_token_hash = excluded.refresh_token_hash, expires_at = excluded.expires_at, revoked_at = excluded.revoked_at`,
+		session.ID, session.UserID, session.RefreshTokenHash, session.CreatedAt, session.ExpiresAt, session.RevokedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("save session: %w", err)
+	}
+	return nil
+}
+
+// GetSanction はユーザーに科されている制裁を取得（期限切れも含む）
+func (s *SQLStore) GetSanction(userID string, kind model.SanctionKind) (*model.Sanction, bool) {
+	var (
+		sanction  model.Sanction
+		expiresAt sql.NullString
+	)
+	err := s.db.QueryRow(
+		`SELECT user_id, kind, reason, created_by, created_at, expires_at FROM sanctions WHERE user_id = ? AND kind = ?`, userID, kind,
+	).Scan(&sanction.UserID, &sanction.Kind, &sanction.Reason, &sanction.CreatedBy, &sanction.CreatedAt, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("[SQLStore] query sanction: %v", err)
+		return nil, false
+	}
+	if expiresAt.Valid {
+		sanction.ExpiresAt = &expiresAt.String
+	}
+	return &sanction, true
+}
+
+// SaveSanction は制裁を保存（同じ種類の制裁があれば置き換える）
+func (s *SQLStore) SaveSanction(sanction *model.Sanction) error {
+	_, err := s.db.Exec(
+		`INSERT INTO sanctions (user_id, kind, reason, created_by, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)
+		 ON CONFLICT (user_id, kind) DO UPDATE SET reason = excluded.reason, created_by = excluded.created_by, created_at = excluded.created_at, expires_at = excluded.expires_at`,
+		sanction.UserID, sanction.Kind, sanction.Reason, sanction.CreatedBy, sanction.CreatedAt, sanction.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("save sanction: %w", err)
+	}
+	return nil
+}
+
+// DeleteSanction は制裁を解除（無い場合は何もしない）
+func (s *SQLStore) DeleteSanction(userID string, kind model.SanctionKind) error {
+	if _, err := s.db.Exec(`DELETE FROM sanctions WHERE user_id = ? AND kind = ?`, userID, kind); err != nil {
+		return fmt.Errorf("delete sanction: %w", err)
+	}
+	return nil
+}
+
+// ListModerationActions はモデレーション操作を新しい順に最大 limit 件取得
+func (s *SQLStore) ListModerationActions(targetUserID string, limit int) []*model.ModerationAction {
+	query := `SELECT id, action, moderator_id, target_user_id, message_id, reason, expires_at, created_at FROM moderation_actions`
+	args := []interface{}{}
+	if targetUserID != "" {
+		query += ` WHERE target_user_id = ?`
+		args = append(args, targetUserID)
+	}
+	query += ` ORDER BY seq DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		log.Printf("[SQLStore] query moderation actions: %v", err)
+		return []*model.ModerationAction{}
+	}
+	defer rows.Close()
+
+	actions := make([]*model.ModerationAction, 0)
+	for rows.Next() {
+		var (
+			a                            model.ModerationAction
+			messageID, reason, expiresAt sql.NullString
+		)
+		if err := rows.Scan(&a.ID, &a.Action, &a.ModeratorID, &a.TargetUserID, &messageID, &reason, &expiresAt, &a.CreatedAt); err != nil {
+			log.Printf("[SQLStore] scan moderation action: %v", err)
+			return []*model.ModerationAction{}
+		}
+		if messageID.Valid {
+			a.MessageID = &messageID.String
+		}
+		if reason.Valid {
+			a.Reason = &reason.String
+		}
+		if expiresAt.Valid {
+			a.ExpiresAt = &expiresAt.String
+		}
+		actions = append(actions, &a)
+	}
+	return actions
+}
+
+// SaveModerationAction はモデレーション操作を監査ログに追加
+func (s *SQLStore) SaveModerationAction(action *model.ModerationAction) error {
+	_, err := s.db.Exec(
+		`INSERT INTO moderation_actions (id, action, moderator_id, target_user_id, message_id, reason, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		action.ID, action.Action, action.ModeratorID, action.TargetUserID, action.MessageID, action.Reason, action.ExpiresAt, action.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("save moderation action: %w", err)
+	}
+	return nil
+}
+
+// inTx はfnをトランザクション内で実行し、エラーがなければコミット
+func (s *SQLStore) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,138 @@
+package store_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/kajidog/graphql-sse-test/apps/backend/store"
+	"github.com/kajidog/graphql-sse-test/apps/backend/store/storetest"
+)
+
+// openSQLite はテスト終了時に閉じられるSQLiteストアを開く
+func openSQLite(t *testing.T, path string) *store.SQLStore {
+	t.Helper()
+	s, err := store.OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("OpenSQLite: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestSQLStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		return openSQLite(t, filepath.Join(t.TempDir(), "chat.db"))
+	})
+}
+
+func TestSQLStoreMigratesV1(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat.db")
+	db, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ルーム導入前（v1）のスキーマとデータ
+	for _, q := range []string{
+		`CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY, name TEXT NOT NULL, applied_at TEXT NOT NULL)`,
+		`INSERT INTO schema_migrations VALUES (1, 'create users and messages', '2024-01-01T00:00:00Z')`,
+		`CREATE TABLE users (id TEXT PRIMARY KEY, nickname TEXT NOT NULL)`,
+		`CREATE TABLE messages (seq INTEGER PRIMARY KEY AUTOINCREMENT, id TEXT NOT NULL UNIQUE, user_id TEXT NOT NULL REFERENCES users (id), content TEXT NOT NULL, created_at TEXT NOT NULL)`,
+		`INSERT INTO users VALUES ('u1', ' Alice ')`,
+		// 小文字にすると u1 と重複する
+		`INSERT INTO users VALUES ('u2', 'alice')`,
+		// ユーザー名に使えない文字を含む
+		`INSERT INTO users VALUES ('u3', 'Bob Smith')`,
+		`INSERT INTO users VALUES ('u4', 'carol_99')`,
+		`INSERT INTO messages (id, user_id, content, created_at) VALUES ('m1', 'u1', 'hi', '2024-01-01T00:00:00Z')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+	db.Close()
+
+	s := openSQLite(t, path)
+	if !s.IsRoomMember("general", "u1") {
+		t.Error("existing user was not added to the general room")
+	}
+	if got := s.CountMessages("general"); got != 1 {
+		t.Errorf("CountMessages(general) = %d, want 1", got)
+	}
+	if _, ok := s.GetRoom("general"); !ok {
+		t.Error("general room was not created")
+	}
+
+	// ユーザー名はニックネームを小文字にしたもの（できない場合は legacy-<ID>）
+	for id, want := range map[string]string{
+		"u1": "alice",
+		"u2": store.LegacyUsernamePrefix + "u2",
+		"u3": store.LegacyUsernamePrefix + "u3",
+		"u4": "carol_99",
+	} {
+		user, ok := s.GetUser(id)
+		if !ok {
+			t.Errorf("user %s was lost", id)
+			continue
+		}
+		if user.Username != want {
+			t.Errorf("user %s username = %q, want %q", id, user.Username, want)
+		}
+	}
+	if user, ok := s.GetUserByUsername("alice"); !ok || user.ID != "u1" {
+		t.Errorf("GetUserByUsername(alice) = %v, %v, want u1", user, ok)
+	}
+}
+
+func TestSQLStoreMigratesV8(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat.db")
+	s, err := store.OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("OpenSQLite: %v", err)
+	}
+	s.Close()
+
+	// v6 でニックネームをそのままユーザー名にした（v9 適用前の）データ
+	db, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`DELETE FROM schema_migrations WHERE version = 9`,
+		`INSERT INTO users (id, nickname, username) VALUES ('u1', 'Carol ', 'Carol ')`,
+		// 小文字にすると u1 と重複する
+		`INSERT INTO users (id, nickname, username) VALUES ('u2', 'CAROL', 'CAROL')`,
+		// 小文字にすると後から登録したパスワードのあるユーザー（u4）と重複する
+		`INSERT INTO users (id, nickname, username) VALUES ('u3', 'Dave', 'Dave')`,
+		`INSERT INTO users (id, nickname, username) VALUES ('u4', 'Dave', 'dave')`,
+		`INSERT INTO credentials (user_id, password_hash, updated_at) VALUES ('u4', 'hash', '2024-01-01T00:00:00Z')`,
+		// ユーザー名に使えない文字を含む
+		`INSERT INTO users (id, nickname, username) VALUES ('u5', 'Bob Smith', 'Bob Smith')`,
+		// 外部の利用者はそのまま
+		`INSERT INTO users (id, nickname, username) VALUES ('u6', 'Eve', 'ext-u6')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+	db.Close()
+
+	s = openSQLite(t, path)
+	for id, want := range map[string]string{
+		"u1": "carol",
+		"u2": store.LegacyUsernamePrefix + "u2",
+		"u3": store.LegacyUsernamePrefix + "u3",
+		"u4": "dave",
+		"u5": store.LegacyUsernamePrefix + "u5",
+		"u6": "ext-u6",
+	} {
+		user, ok := s.GetUser(id)
+		if !ok {
+			t.Errorf("user %s was lost", id)
+			continue
+		}
+		if user.Username != want {
+			t.Errorf("user %s username = %q, want %q", id, user.Username, want)
+		}
+	}
+}
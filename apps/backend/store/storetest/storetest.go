@@ -0,0 +1,678 @@
+// Package storetest は store.Store の実装が満たすべき振る舞いを検証する共通テストスイート
+//
+// 各実装のテストから次のように呼び出す
+//
+//	func TestMemoryStore(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) store.Store { return store.NewMemoryStore() })
+//	}
+package storetest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kajidog/graphql-sse-test/apps/backend/graph/model"
+	"github.com/kajidog/graphql-sse-test/apps/backend/store"
+)
+
+// Factory はサブテストごとに空のストアを作成する
+type Factory func(t *testing.T) store.Store
+
+// Run は全てのケースをサブテストとして実行
+func Run(t *testing.T, newStore Factory) {
+	t.Run("GetUserNotFound", func(t *testing.T) { testGetUserNotFound(t, newStore(t)) })
+	t.Run("SaveAndGetUser", func(t *testing.T) { testSaveAndGetUser(t, newStore(t)) })
+	t.Run("SaveUserUpdates", func(t *testing.T) { testSaveUserUpdates(t, newStore(t)) })
+	t.Run("GetUserByUsername", func(t *testing.T) { testGetUserByUsername(t, newStore(t)) })
+	t.Run("UsernameTaken", func(t *testing.T) { testUsernameTaken(t, newStore(t)) })
+	t.Run("SaveAndGetRoom", func(t *testing.T) { testSaveAndGetRoom(t, newStore(t)) })
+	t.Run("ListRooms", func(t *testing.T) { testListRooms(t, newStore(t)) })
+	t.Run("RoomMembers", func(t *testing.T) { testRoomMembers(t, newStore(t)) })
+	t.Run("ListUserRooms", func(t *testing.T) { testListUserRooms(t, newStore(t)) })
+	t.Run("MessagesEmpty", func(t *testing.T) { testMessagesEmpty(t, newStore(t)) })
+	t.Run("SaveMessageAssignsSeq", func(t *testing.T) { testSaveMessageAssignsSeq(t, newStore(t)) })
+	t.Run("GetMessagesAfter", func(t *testing.T) { testGetMessagesAfter(t, newStore(t)) })
+	t.Run("ListMessages", func(t *testing.T) { testListMessages(t, newStore(t)) })
+	t.Run("CountMessages", func(t *testing.T) { testCountMessages(t, newStore(t)) })
+	t.Run("MessagesByRoom", func(t *testing.T) { testMessagesByRoom(t, newStore(t)) })
+	t.Run("GetMessage", func(t *testing.T) { testGetMessage(t, newStore(t)) })
+	t.Run("EditMessage", func(t *testing.T) { testEditMessage(t, newStore(t)) })
+	t.Run("DeleteMessage", func(t *testing.T) { testDeleteMessage(t, newStore(t)) })
+	t.Run("Sessions", func(t *testing.T) { testSessions(t, newStore(t)) })
+	t.Run("Credentials", func(t *testing.T) { testCredentials(t, newStore(t)) })
+	t.Run("Sanctions", func(t *testing.T) { testSanctions(t, newStore(t)) })
+	t.Run("ModerationActions", func(t *testing.T) { testModerationActions(t, newStore(t)) })
+}
+
+// saveUser はユーザー名と表示名が同じユーザーを保存
+func saveUser(t *testing.T, s store.Store, id, username string) *model.User {
+	t.Helper()
+	u := &model.User{ID: id, Username: username, Nickname: username, Role: model.RoleMember}
+	if err := s.SaveUser(u); err != nil {
+		t.Fatalf("SaveUser(%q): %v", id, err)
+	}
+	return u
+}
+
+func saveRoom(t *testing.T, s store.Store, id, createdBy, createdAt string) *model.Room {
+	t.Helper()
+	r := &model.Room{ID: id, Kind: model.RoomKindChannel, Name: "room " + id, CreatedBy: createdBy, CreatedAt: createdAt}
+	if err := s.SaveRoom(r); err != nil {
+		t.Fatalf("SaveRoom(%q): %v", id, err)
+	}
+	return r
+}
+
+func saveMessages(t *testing.T, s store.Store, roomID, userID string, n int) []*model.Message {
+	t.Helper()
+	messages := make([]*model.Message, n)
+	for i := range messages {
+		msg := &model.Message{
+			ID:        fmt.Sprintf("%s-msg-%d", roomID, i+1),
+			RoomID:    roomID,
+			UserID:    userID,
+			Content:   fmt.Sprintf("content %d", i+1),
+			CreatedAt: time.Date(2024, 1, 1, 0, 0, i, 0, time.UTC).Format(time.RFC3339),
+		}
+		if err := s.SaveMessage(msg); err != nil {
+			t.Fatalf("SaveMessage(%q): %v", msg.ID, err)
+		}
+		messages[i] = msg
+	}
+	return messages
+}
+
+func assertMessages(t *testing.T, got, want []*model.Message) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(got), len(want))
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.ID != w.ID || g.RoomID != w.RoomID || g.UserID != w.UserID || g.Content != w.Content || g.CreatedAt != w.CreatedAt || g.Seq != w.Seq {
+			t.Errorf("message[%d] = %+v, want %+v", i, g, w)
+		}
+	}
+}
+
+func testGetUserNotFound(t *testing.T, s store.Store) {
+	if u, ok := s.GetUser("missing"); ok || u != nil {
+		t.Errorf("GetUser(missing) = %v, %v; want nil, false", u, ok)
+	}
+	if u, ok := s.GetUserByUsername("missing"); ok || u != nil {
+		t.Errorf("GetUserByUsername(missing) = %v, %v; want nil, false", u, ok)
+	}
+}
+
+func testSaveAndGetUser(t *testing.T, s store.Store) {
+	saveUser(t, s, "u1", "alice")
+	u, ok := s.GetUser("u1")
+	if !ok || u.ID != "u1" || u.Username != "alice" || u.Nickname != "alice" || u.Role != model.RoleMember {
+		t.Errorf("GetUser(u1) = %v, %v", u, ok)
+	}
+}
+
+func testSaveUserUpdates(t *testing.T, s store.Store) {
+	saveUser(t, s, "u1", "alice")
+	if err := s.SaveUser(&model.User{ID: "u1", Username: "alice", Nickname: "Alice", Role: model.RoleModerator}); err != nil {
+		t.Fatalf("SaveUser(update): %v", err)
+	}
+	u, ok := s.GetUser("u1")
+	if !ok || u.Nickname != "Alice" || u.Role != model.RoleModerator {
+		t.Errorf("GetUser(u1) after update = %v, %v", u, ok)
+	}
+	if u, ok := s.GetUserByUsername("alice"); !ok || u.Nickname != "Alice" {
+		t.Errorf("GetUserByUsername(alice) after update = %v, %v", u, ok)
+	}
+}
+
+func testGetUserByUsername(t *testing.T, s store.Store) {
+	saveUser(t, s, "u1", "alice")
+	saveUser(t, s, "u2", "bob")
+	u, ok := s.GetUserByUsername("bob")
+	if !ok || u.ID != "u2" {
+		t.Errorf("GetUserByUsername(bob) = %v, %v", u, ok)
+	}
+}
+
+func testUsernameTaken(t *testing.T, s store.Store) {
+	saveUser(t, s, "u1", "alice")
+	err := s.SaveUser(&model.User{ID: "u2", Username: "alice", Nickname: "other", Role: model.RoleMember})
+	if !errors.Is(err, store.ErrUsernameTaken) {
+		t.Errorf("SaveUser(duplicate username) = %v, want ErrUsernameTaken", err)
+	}
+	if _, ok := s.GetUser("u2"); ok {
+		t.Error("user with a duplicate username was saved")
+	}
+	if u, _ := s.GetUserByUsername("alice"); u.ID != "u1" {
+		t.Errorf("GetUserByUsername(alice) = %v, want u1", u)
+	}
+}
+
+func testMessagesEmpty(t *testing.T, s store.Store) {
+	if got := s.GetMessages(); len(got) != 0 {
+		t.Errorf("GetMessages() on empty store = %d messages", len(got))
+	}
+	if got := s.GetMessagesAfter(0); len(got) != 0 {
+		t.Errorf("GetMessagesAfter(0) on empty store = %d messages", len(got))
+	}
+}
+
+func testSaveMessageAssignsSeq(t *testing.T, s store.Store) {
+	saveUser(t, s, "u1", "alice")
+	saveRoom(t, s, "r1", "u1", "2024-01-01T00:00:00Z")
+	messages := saveMessages(t, s, "r1", "u1", 3)
+	for i, m := range messages {
+		if m.Seq <= 0 {
+			t.Fatalf("message[%d].Seq = %d; want > 0", i, m.Seq)
+		}
+		if i > 0 && m.Seq <= messages[i-1].Seq {
+			t.Fatalf("Seq not increasing: %d after %d", m.Seq, messages[i-1].Seq)
+		}
+	}
+	assertMessages(t, s.GetMessages(), messages)
+}
+
+func testGetMessagesAfter(t *testing.T, s store.Store) {
+	saveUser(t, s, "u1", "alice")
+	saveRoom(t, s, "r1", "u1", "2024-01-01T00:00:00Z")
+	messages := saveMessages(t, s, "r1", "u1", 5)
+
+	assertMessages(t, s.GetMessagesAfter(0), messages)
+	assertMessages(t, s.GetMessagesAfter(messages[1].Seq), messages[2:])
+	assertMessages(t, s.GetMessagesAfter(messages[4].Seq), nil)
+	assertMessages(t, s.GetMessagesAfter(messages[4].Seq+100), nil)
+}
+
+func testListMessages(t *testing.T, s store.Store) {
+	saveUser(t, s, "u1", "alice")
+	saveRoom(t, s, "r1", "u1", "2024-01-01T00:00:00Z")
+	messages := saveMessages(t, s, "r1", "u1", 5)
+	seq := func(i int) int64 { return messages[i].Seq }
+
+	tests := []struct {
+		name string
+		r    store.MessageRange
+		want []*model.Message
+	}{
+		{"All", store.MessageRange{}, messages},
+		{"First", store.MessageRange{Limit: 2}, messages[:2]},
+		{"Last", store.MessageRange{Limit: 2, FromEnd: true}, messages[3:]},
+		{"After", store.MessageRange{After: seq(1), Limit: 2}, messages[2:4]},
+		{"Before", store.MessageRange{Before: seq(3), Limit: 2, FromEnd: true}, messages[1:3]},
+		{"Between", store.MessageRange{After: seq(0), Before: seq(4)}, messages[1:4]},
+		{"BetweenFromEnd", store.MessageRange{After: seq(0), Before: seq(4), Limit: 10, FromEnd: true}, messages[1:4]},
+		{"Empty", store.MessageRange{After: seq(2), Before: seq(3)}, nil},
+		{"PastEnd", store.MessageRange{After: seq(4)}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertMessages(t, s.ListMessages(tt.r), tt.want)
+		})
+	}
+}
+
+func testCountMessages(t *testing.T, s store.Store) {
+	if got := s.CountMessages(""); got != 0 {
+		t.Errorf("CountMessages() on empty store = %d", got)
+	}
+	saveUser(t, s, "u1", "alice")
+	saveRoom(t, s, "r1", "u1", "2024-01-01T00:00:00Z")
+	saveRoom(t, s, "r2", "u1", "2024-01-01T00:00:01Z")
+	saveMessages(t, s, "r1", "u1", 3)
+	saveMessages(t, s, "r2", "u1", 2)
+	if got := s.CountMessages(""); got != 5 {
+		t.Errorf("CountMessages(\"\") = %d, want 5", got)
+	}
+	if got := s.CountMessages("r1"); got != 3 {
+		t.Errorf("CountMessages(r1) = %d, want 3", got)
+	}
+	if got := s.CountMessages("missing"); got != 0 {
+		t.Errorf("CountMessages(missing) = %d, want 0", got)
+	}
+}
+
+func testSaveAndGetRoom(t *testing.T, s store.Store) {
+	if r, ok := s.GetRoom("missing"); ok || r != nil {
+		t.Errorf("GetRoom(missing) = %v, %v; want nil, false", r, ok)
+	}
+
+	saveUser(t, s, "u1", "alice")
+	room := saveRoom(t, s, "r1", "u1", "2024-01-01T00:00:00Z")
+	r, ok := s.GetRoom("r1")
+	if !ok || r.Kind != model.RoomKindChannel || r.Name != room.Name || r.CreatedBy != "u1" || r.CreatedAt != room.CreatedAt || r.Archived() {
+		t.Fatalf("GetRoom(r1) = %+v, %v", r, ok)
+	}
+
+	archivedAt := "2024-01-02T00:00:00Z"
+	if err := s.SaveRoom(&model.Room{ID: "r1", Name: "renamed", CreatedBy: "u1", CreatedAt: room.CreatedAt, ArchivedAt: &archivedAt}); err != nil {
+		t.Fatalf("SaveRoom(update): %v", err)
+	}
+	r, ok = s.GetRoom("r1")
+	if !ok || r.Name != "renamed" || r.ArchivedAt == nil || *r.ArchivedAt != archivedAt {
+		t.Errorf("GetRoom(r1) after update = %+v, %v", r, ok)
+	}
+
+	// 作成者のいないルーム（既定のルームなど）
+	saveRoom(t, s, "r2", "", "2024-01-01T00:00:00Z")
+	if r, ok := s.GetRoom("r2"); !ok || r.CreatedBy != "" {
+		t.Errorf("GetRoom(r2) = %+v, %v", r, ok)
+	}
+}
+
+func testListRooms(t *testing.T, s store.Store) {
+	if got := s.ListRooms(); len(got) != 0 {
+		t.Errorf("ListRooms() on empty store = %d rooms", len(got))
+	}
+	saveUser(t, s, "u1", "alice")
+	saveRoom(t, s, "b", "u1", "2024-01-01T00:00:02Z")
+	saveRoom(t, s, "a", "u1", "2024-01-01T00:00:01Z")
+	saveRoom(t, s, "c", "u1", "2024-01-01T00:00:03Z")
+
+	rooms := s.ListRooms()
+	var ids []string
+	for _, r := range rooms {
+		ids = append(ids, r.ID)
+	}
+	if fmt.Sprint(ids) != "[a b c]" {
+		t.Errorf("ListRooms() = %v, want [a b c]", ids)
+	}
+}
+
+func testRoomMembers(t *testing.T, s store.Store) {
+	saveUser(t, s, "u1", "alice")
+	saveUser(t, s, "u2", "bob")
+	saveRoom(t, s, "r1", "u1", "2024-01-01T00:00:00Z")
+	saveRoom(t, s, "r2", "u1", "2024-01-01T00:00:00Z")
+
+	if s.IsRoomMember("r1", "u1") {
+		t.Errorf("IsRoomMember before join = true")
+	}
+	for _, id := range []string{"u2", "u1", "u1"} {
+		if err := s.AddRoomMember("r1", id); err != nil {
+			t.Fatalf("AddRoomMember(r1, %s): %v", id, err)
+		}
+	}
+	if !s.IsRoomMember("r1", "u1") || !s.IsRoomMember("r1", "u2") {
+		t.Errorf("IsRoomMember after join = false")
+	}
+	if s.IsRoomMember("r2", "u1") {
+		t.Errorf("membership leaked to another room")
+	}
+	if got := fmt.Sprint(s.ListRoomMembers("r1")); got != "[u1 u2]" {
+		t.Errorf("ListRoomMembers(r1) = %s, want [u1 u2]", got)
+	}
+
+	if err := s.RemoveRoomMember("r1", "u1"); err != nil {
+		t.Fatalf("RemoveRoomMember: %v", err)
+	}
+	if s.IsRoomMember("r1", "u1") {
+		t.Errorf("IsRoomMember after leave = true")
+	}
+	if got := fmt.Sprint(s.ListRoomMembers("r1")); got != "[u2]" {
+		t.Errorf("ListRoomMembers(r1) after leave = %s, want [u2]", got)
+	}
+	// メンバーでないユーザーの削除はエラーにしない
+	if err := s.RemoveRoomMember("r2", "u1"); err != nil {
+		t.Errorf("RemoveRoomMember(non-member): %v", err)
+	}
+}
+
+func testListUserRooms(t *testing.T, s store.Store) {
+	saveUser(t, s, "u1", "alice")
+	saveUser(t, s, "u2", "bob")
+	saveRoom(t, s, "b", "u1", "2024-01-01T00:00:02Z")
+	saveRoom(t, s, "a", "u1", "2024-01-01T00:00:01Z")
+	saveRoom(t, s, "c", "u1", "2024-01-01T00:00:03Z")
+	dm := &model.Room{ID: "dm", Kind: model.RoomKindDirect, Name: "alice, bob", CreatedBy: "u1", CreatedAt: "2024-01-01T00:00:04Z"}
+	if err := s.SaveRoom(dm); err != nil {
+		t.Fatalf("SaveRoom(dm): %v", err)
+	}
+	for _, m := range [][2]string{{"b", "u1"}, {"a", "u1"}, {"c", "u2"}, {"dm", "u1"}, {"dm", "u2"}} {
+		if err := s.AddRoomMember(m[0], m[1]); err != nil {
+			t.Fatalf("AddRoomMember(%s, %s): %v", m[0], m[1], err)
+		}
+	}
+
+	var ids []string
+	for _, r := range s.ListUserRooms("u1") {
+		ids = append(ids, r.ID)
+	}
+	if fmt.Sprint(ids) != "[a b dm]" {
+		t.Errorf("ListUserRooms(u1) = %v, want [a b dm]", ids)
+	}
+	if rooms := s.ListUserRooms("u2"); len(rooms) != 2 || rooms[1].Kind != model.RoomKindDirect {
+		t.Errorf("ListUserRooms(u2) = %v", rooms)
+	}
+	if rooms := s.ListUserRooms("missing"); len(rooms) != 0 {
+		t.Errorf("ListUserRooms(missing) = %v", rooms)
+	}
+}
+
+func testMessagesByRoom(t *testing.T, s store.Store) {
+	saveUser(t, s, "u1", "alice")
+	saveRoom(t, s, "r1", "u1", "2024-01-01T00:00:00Z")
+	saveRoom(t, s, "r2", "u1", "2024-01-01T00:00:00Z")
+
+	// ルームをまたいで交互に保存
+	var all, r1, r2 []*model.Message
+	for i := 0; i < 6; i++ {
+		roomID := "r1"
+		if i%2 == 1 {
+			roomID = "r2"
+		}
+		msg := &model.Message{
+			ID:        fmt.Sprintf("msg-%d", i),
+			RoomID:    roomID,
+			UserID:    "u1",
+			Content:   fmt.Sprintf("content %d", i),
+			CreatedAt: time.Date(2024, 1, 1, 0, 0, i, 0, time.UTC).Format(time.RFC3339),
+		}
+		if err := s.SaveMessage(msg); err != nil {
+			t.Fatalf("SaveMessage(%q): %v", msg.ID, err)
+		}
+		all = append(all, msg)
+		if roomID == "r1" {
+			r1 = append(r1, msg)
+		} else {
+			r2 = append(r2, msg)
+		}
+	}
+
+	assertMessages(t, s.ListMessages(store.MessageRange{}), all)
+	assertMessages(t, s.ListMessages(store.MessageRange{RoomID: "r1"}), r1)
+	assertMessages(t, s.ListMessages(store.MessageRange{RoomID: "r2", Limit: 2}), r2[:2])
+	assertMessages(t, s.ListMessages(store.MessageRange{RoomID: "r2", Limit: 2, FromEnd: true}), r2[1:])
+	assertMessages(t, s.ListMessages(store.MessageRange{RoomID: "r1", After: r1[0].Seq}), r1[1:])
+	assertMessages(t, s.ListMessages(store.MessageRange{RoomID: "r1", Before: r1[2].Seq, FromEnd: true, Limit: 1}), r1[1:2])
+	assertMessages(t, s.ListMessages(store.MessageRange{RoomID: "missing"}), nil)
+}
+
+func testGetMessage(t *testing.T, s store.Store) {
+	saveUser(t, s, "u1", "alice")
+	saveRoom(t, s, "r1", "u1", "2024-01-01T00:00:00Z")
+	messages := saveMessages(t, s, "r1", "u1", 3)
+
+	got, ok := s.GetMessage(messages[1].ID)
+	if !ok {
+		t.Fatalf("GetMessage(%q) not found", messages[1].ID)
+	}
+	assertMessages(t, []*model.Message{got}, messages[1:2])
+	if _, ok := s.GetMessage("missing"); ok {
+		t.Error("GetMessage(missing) found")
+	}
+}
+
+func testEditMessage(t *testing.T, s store.Store) {
+	saveUser(t, s, "u1", "alice")
+	saveRoom(t, s, "r1", "u1", "2024-01-01T00:00:00Z")
+	messages := saveMessages(t, s, "r1", "u1", 2)
+	id := messages[0].ID
+
+	if _, err := s.EditMessage("missing", "x", "2024-01-02T00:00:00Z"); !errors.Is(err, store.ErrMessageNotFound) {
+		t.Errorf("EditMessage(missing) error = %v, want ErrMessageNotFound", err)
+	}
+
+	all := s.GetMessages()
+	if _, err := s.EditMessage(id, "edited 1", "2024-01-02T00:00:00Z"); err != nil {
+		t.Fatalf("EditMessage: %v", err)
+	}
+	edited, err := s.EditMessage(id, "edited 2", "2024-01-03T00:00:00Z")
+	if err != nil {
+		t.Fatalf("EditMessage: %v", err)
+	}
+	if edited.Content != "edited 2" || edited.EditedAt == nil || *edited.EditedAt != "2024-01-03T00:00:00Z" || edited.Seq != messages[0].Seq {
+		t.Errorf("EditMessage() = %+v", edited)
+	}
+
+	// 取得済みのメッセージは書き換えない
+	if messages[0].Content != "content 1" {
+		t.Errorf("original message mutated: %+v", messages[0])
+	}
+	if all[0].Content != "content 1" {
+		t.Errorf("GetMessages() result mutated: %+v", all[0])
+	}
+	got, _ := s.GetMessage(id)
+	if got.Content != "edited 2" || got.EditedAt == nil {
+		t.Errorf("GetMessage() after edit = %+v", got)
+	}
+	if listed := s.ListMessages(store.MessageRange{RoomID: "r1"}); listed[0].Content != "edited 2" {
+		t.Errorf("ListMessages() after edit = %+v", listed[0])
+	}
+
+	edits := s.ListMessageEdits(id)
+	want := []model.MessageEdit{
+		{Content: "content 1", EditedAt: "2024-01-02T00:00:00Z"},
+		{Content: "edited 1", EditedAt: "2024-01-03T00:00:00Z"},
+	}
+	if len(edits) != len(want) {
+		t.Fatalf("ListMessageEdits() = %d edits, want %d", len(edits), len(want))
+	}
+	for i := range want {
+		if *edits[i] != want[i] {
+			t.Errorf("edit[%d] = %+v, want %+v", i, edits[i], want[i])
+		}
+	}
+	if edits := s.ListMessageEdits(messages[1].ID); len(edits) != 0 {
+		t.Errorf("ListMessageEdits(unedited) = %v", edits)
+	}
+}
+
+func testDeleteMessage(t *testing.T, s store.Store) {
+	saveUser(t, s, "u1", "alice")
+	saveRoom(t, s, "r1", "u1", "2024-01-01T00:00:00Z")
+	messages := saveMessages(t, s, "r1", "u1", 2)
+	id := messages[0].ID
+
+	if _, err := s.DeleteMessage("missing", "2024-01-02T00:00:00Z"); !errors.Is(err, store.ErrMessageNotFound) {
+		t.Errorf("DeleteMessage(missing) error = %v, want ErrMessageNotFound", err)
+	}
+
+	if _, err := s.EditMessage(id, "edited", "2024-01-02T00:00:00Z"); err != nil {
+		t.Fatalf("EditMessage: %v", err)
+	}
+	deleted, err := s.DeleteMessage(id, "2024-01-03T00:00:00Z")
+	if err != nil {
+		t.Fatalf("DeleteMessage: %v", err)
+	}
+	if deleted.Content != "" || !deleted.Deleted() || *deleted.DeletedAt != "2024-01-03T00:00:00Z" {
+		t.Errorf("DeleteMessage() = %+v", deleted)
+	}
+
+	// 墓標として残り、件数や連番は変わらない
+	got, ok := s.GetMessage(id)
+	if !ok || !got.Deleted() || got.Content != "" || got.Seq != messages[0].Seq {
+		t.Errorf("GetMessage() after delete = %+v, %v", got, ok)
+	}
+	if n := s.CountMessages("r1"); n != 2 {
+		t.Errorf("CountMessages(r1) after delete = %d, want 2", n)
+	}
+	if edits := s.ListMessageEdits(id); len(edits) != 0 {
+		t.Errorf("ListMessageEdits() after delete = %v", edits)
+	}
+
+	// 削除済みのメッセージは編集できない
+	if _, err := s.EditMessage(id, "edited again", "2024-01-04T00:00:00Z"); !errors.Is(err, store.ErrMessageDeleted) {
+		t.Errorf("EditMessage(deleted) error = %v, want ErrMessageDeleted", err)
+	}
+	if got, _ := s.GetMessage(id); got.Content != "" || got.EditedAt == nil || *got.EditedAt != "2024-01-02T00:00:00Z" {
+		t.Errorf("GetMessage() after editing deleted = %+v", got)
+	}
+	if edits := s.ListMessageEdits(id); len(edits) != 0 {
+		t.Errorf("ListMessageEdits() after editing deleted = %v", edits)
+	}
+}
+
+func testSessions(t *testing.T, s store.Store) {
+	saveUser(t, s, "u1", "alice")
+	saveUser(t, s, "u2", "bob")
+	if _, ok := s.GetSession("missing"); ok {
+		t.Error("GetSession(missing) found")
+	}
+
+	sessions := []*model.Session{
+		{ID: "s2", UserID: "u1", RefreshTokenHash: "h2", CreatedAt: "2024-01-02T00:00:00Z", ExpiresAt: "2024-02-02T00:00:00Z"},
+		{ID: "s1", UserID: "u1", RefreshTokenHash: "h1", CreatedAt: "2024-01-01T00:00:00Z", ExpiresAt: "2024-02-01T00:00:00Z"},
+		{ID: "s3", UserID: "u2", RefreshTokenHash: "h3", CreatedAt: "2024-01-01T00:00:00Z", ExpiresAt: "2024-02-01T00:00:00Z"},
+	}
+	for _, session := range sessions {
+		if err := s.SaveSession(session); err != nil {
+			t.Fatalf("SaveSession(%q): %v", session.ID, err)
+		}
+	}
+
+	got, ok := s.GetSession("s1")
+	if !ok || *got != *sessions[1] {
+		t.Errorf("GetSession(s1) = %+v, %v", got, ok)
+	}
+	if list := s.ListUserSessions("u1"); len(list) != 2 || list[0].ID != "s1" || list[1].ID != "s2" {
+		t.Errorf("ListUserSessions(u1) = %v", list)
+	}
+	if list := s.ListUserSessions("missing"); len(list) != 0 {
+		t.Errorf("ListUserSessions(missing) = %v", list)
+	}
+
+	// リフレッシュトークンの更新と失効
+	revokedAt := "2024-01-03T00:00:00Z"
+	updated := *sessions[1]
+	updated.RefreshTokenHash = "h1b"
+	updated.ExpiresAt = "2024-02-03T00:00:00Z"
+	updated.RevokedAt = &revokedAt
+	if err := s.SaveSession(&updated); err != nil {
+		t.Fatalf("SaveSession(update): %v", err)
+	}
+	got, _ = s.GetSession("s1")
+	if got.RefreshTokenHash != "h1b" || got.ExpiresAt != updated.ExpiresAt || !got.Revoked() || *got.RevokedAt != revokedAt {
+		t.Errorf("GetSession(s1) after update = %+v", got)
+	}
+}
+
+func testCredentials(t *testing.T, s store.Store) {
+	saveUser(t, s, "u1", "alice")
+	if _, ok := s.GetCredential("u1"); ok {
+		t.Error("GetCredential(u1) found before saving")
+	}
+
+	c := &model.Credential{UserID: "u1", PasswordHash: "hash", UpdatedAt: "2024-01-01T00:00:00Z"}
+	if err := s.SaveCredential(c); err != nil {
+		t.Fatalf("SaveCredential: %v", err)
+	}
+	got, ok := s.GetCredential("u1")
+	if !ok || got.PasswordHash != "hash" || got.FailedAttempts != 0 || got.LockedUntil != nil {
+		t.Errorf("GetCredential(u1) = %+v, %v", got, ok)
+	}
+
+	// 失敗回数とロックの更新
+	lockedUntil := "2024-01-01T00:15:00Z"
+	updated := *c
+	updated.FailedAttempts = 3
+	updated.LockedUntil = &lockedUntil
+	updated.UpdatedAt = "2024-01-01T00:01:00Z"
+	if err := s.SaveCredential(&updated); err != nil {
+		t.Fatalf("SaveCredential(update): %v", err)
+	}
+	got, _ = s.GetCredential("u1")
+	if got.FailedAttempts != 3 || got.LockedUntil == nil || *got.LockedUntil != lockedUntil || got.UpdatedAt != updated.UpdatedAt {
+		t.Errorf("GetCredential(u1) after update = %+v", got)
+	}
+}
+
+func testSanctions(t *testing.T, s store.Store) {
+	saveUser(t, s, "u1", "alice")
+	saveUser(t, s, "mod", "moderator")
+	if _, ok := s.GetSanction("u1", model.SanctionBan); ok {
+		t.Error("GetSanction(u1, BAN) found before saving")
+	}
+
+	expiresAt := "2024-01-02T00:00:00Z"
+	ban := &model.Sanction{UserID: "u1", Kind: model.SanctionBan, Reason: "spam", CreatedBy: "mod", CreatedAt: "2024-01-01T00:00:00Z", ExpiresAt: &expiresAt}
+	if err := s.SaveSanction(ban); err != nil {
+		t.Fatalf("SaveSanction: %v", err)
+	}
+	mute := &model.Sanction{UserID: "u1", Kind: model.SanctionMute, CreatedBy: "mod", CreatedAt: "2024-01-01T00:00:00Z"}
+	if err := s.SaveSanction(mute); err != nil {
+		t.Fatalf("SaveSanction(mute): %v", err)
+	}
+	got, ok := s.GetSanction("u1", model.SanctionBan)
+	if !ok || got.Reason != "spam" || got.CreatedBy != "mod" || got.ExpiresAt == nil || *got.ExpiresAt != expiresAt {
+		t.Errorf("GetSanction(u1, BAN) = %+v, %v", got, ok)
+	}
+
+	// 同じ種類の制裁は置き換わる
+	permanent := *ban
+	permanent.ExpiresAt = nil
+	permanent.Reason = "abuse"
+	if err := s.SaveSanction(&permanent); err != nil {
+		t.Fatalf("SaveSanction(replace): %v", err)
+	}
+	got, _ = s.GetSanction("u1", model.SanctionBan)
+	if got.Reason != "abuse" || got.ExpiresAt != nil {
+		t.Errorf("GetSanction(u1, BAN) after replace = %+v", got)
+	}
+
+	if err := s.DeleteSanction("u1", model.SanctionBan); err != nil {
+		t.Fatalf("DeleteSanction: %v", err)
+	}
+	if _, ok := s.GetSanction("u1", model.SanctionBan); ok {
+		t.Error("GetSanction(u1, BAN) found after delete")
+	}
+	if _, ok := s.GetSanction("u1", model.SanctionMute); !ok {
+		t.Error("GetSanction(u1, MUTE) not found after deleting BAN")
+	}
+}
+
+func testModerationActions(t *testing.T, s store.Store) {
+	saveUser(t, s, "u1", "alice")
+	saveUser(t, s, "u2", "bob")
+	saveUser(t, s, "mod", "moderator")
+	if got := s.ListModerationActions("", 10); len(got) != 0 {
+		t.Errorf("ListModerationActions before saving = %d actions", len(got))
+	}
+
+	reason := "spam"
+	actions := []*model.ModerationAction{
+		{ID: "a1", Action: model.ModerationActionKindMute, ModeratorID: "mod", TargetUserID: "u1", Reason: &reason, CreatedAt: "2024-01-01T00:00:00Z"},
+		{ID: "a2", Action: model.ModerationActionKindBan, ModeratorID: "mod", TargetUserID: "u2", CreatedAt: "2024-01-01T00:00:00Z"},
+		{ID: "a3", Action: model.ModerationActionKindUnmute, ModeratorID: "mod", TargetUserID: "u1", CreatedAt: "2024-01-01T00:00:00Z"},
+	}
+	for _, a := range actions {
+		if err := s.SaveModerationAction(a); err != nil {
+			t.Fatalf("SaveModerationAction(%s): %v", a.ID, err)
+		}
+	}
+
+	ids := func(actions []*model.ModerationAction) []string {
+		out := make([]string, len(actions))
+		for i, a := range actions {
+			out[i] = a.ID
+		}
+		return out
+	}
+	tests := []struct {
+		name   string
+		target string
+		limit  int
+		want   []string
+	}{
+		{"all", "", 10, []string{"a3", "a2", "a1"}},
+		{"limit", "", 2, []string{"a3", "a2"}},
+		{"target", "u1", 10, []string{"a3", "a1"}},
+		{"no actions", "mod", 10, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ids(s.ListModerationActions(tt.target, tt.limit))
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("ListModerationActions(%q, %d) = %v, want %v", tt.target, tt.limit, got, tt.want)
+			}
+		})
+	}
+
+	got := s.ListModerationActions("u1", 10)
+	if len(got) == 2 && (got[1].Reason == nil || *got[1].Reason != reason || got[1].Action != model.ModerationActionKindMute) {
+		t.Errorf("ListModerationActions(u1)[1] = %+v", got[1])
+	}
+}
@@ -1,6 +1,12 @@
 import { useState, useEffect } from "react";
 import { ApolloProvider } from "@apollo/client";
-import { apolloClient, setCurrentUserId } from "@/lib/apollo";
+import {
+  apolloClient,
+  setAuthTokens,
+  setOnTokensRefreshed,
+} from "@/lib/apollo";
+import type { AuthTokens } from "@/lib/apollo";
+import { LogoutDocument } from "@/graphql/generated";
 import { Login } from "./components/Login";
 import { Chat } from "./components/Chat";
 import type { AuthUser } from "@/features/auth";
@@ -12,7 +18,14 @@ function loadUserFromStorage(): AuthUser | null {
   if (!savedUser) return null;
 
   try {
-    return JSON.parse(savedUser) as AuthUser;
+    const user = JSON.parse(savedUser) as AuthUser;
+    // 旧形式（リフレッシュトークン・ユーザー名なし）は破棄して再ログインさせる
+    // アクセストークンの期限切れは最初のリクエストで再発行される
+    if (!user.token || !user.refreshToken || !user.username) {
+      localStorage.removeItem(STORAGE_KEY);
+      return null;
+    }
+    return user;
   } catch {
     localStorage.removeItem(STORAGE_KEY);
     return null;
@@ -35,22 +48,48 @@ function App() {
     const restoredUser = loadUserFromStorage();
     if (restoredUser) {
       setUser(restoredUser);
-      setCurrentUserId(restoredUser.id);
+      setAuthTokens(restoredUser);
     }
   }, []);
 
+  // 再発行したトークンを保存し、再発行できなければ（失効・期限切れ）ログイン画面に戻す
+  useEffect(() => {
+    setOnTokensRefreshed((tokens: AuthTokens | null) => {
+      if (!tokens) {
+        setUser(null);
+        clearUserFromStorage();
+        return;
+      }
+      setUser((current) => {
+        if (!current) return current;
+        const updated = { ...current, ...tokens };
+        saveUserToStorage(updated);
+        return updated;
+      });
+    });
+    return () => setOnTokensRefreshed(null);
+  }, []);
+
   const handleLogin = (loggedInUser: AuthUser) => {
     // 認証情報を状態とストレージに保持
     setUser(loggedInUser);
-    setCurrentUserId(loggedInUser.id);
+    setAuthTokens(loggedInUser);
     saveUserToStorage(loggedInUser);
   };
 
-  const handleLogout = () => {
+  const handleLogout = async () => {
+    // サーバー側のセッションを失効させる（失敗してもローカルの認証情報は破棄する）
+    try {
+      await apolloClient.mutate({ mutation: LogoutDocument });
+    } catch {
+      // 既に失効している場合など
+    }
+
     // 認証情報をクリアし、UIをログイン画面に戻す
     setUser(null);
-    setCurrentUserId(null);
+    setAuthTokens(null);
     clearUserFromStorage();
+    await apolloClient.clearStore();
   };
 
   return (
@@ -8,18 +8,26 @@ interface LoginProps {
 }
 
 export function Login({ onLogin }: LoginProps) {
+    const [mode, setMode] = useState<"login" | "register">("login");
+    const [username, setUsername] = useState("");
+    const [password, setPassword] = useState("");
     const [nickname, setNickname] = useState("");
-    const { login, loading, error } = useLogin({
+    const { login, register, loading, error } = useLogin({
         onSuccess: onLogin,
     });
+    const isRegister = mode === "register";
 
     const handleSubmit = async (e: FormEvent) => {
         e.preventDefault();
-        if (!nickname.trim()) return;
+        if (!username.trim() || !password) return;
 
         try {
-            // 入力値を整形してログイン
-            await login(nickname.trim());
+            // 入力値を整形してログイン（登録の場合はそのままログインする）
+            if (isRegister) {
+                await register(username.trim(), password, nickname.trim());
+            } else {
+                await login(username.trim(), password);
+            }
         } catch {
             // エラーはフックで処理される
         }
@@ -29,29 +37,76 @@ export function Login({ onLogin }: LoginProps) {
         <div className={styles.container}>
             <div className={styles.card}>
                 <h1 className={styles.title}>💬 チャット</h1>
-                <p className={styles.subtitle}>ニックネームを入力して参加</p>
+                <p className={styles.subtitle}>
+                    {isRegister ? "アカウントを作成して参加" : "ユーザー名とパスワードでログイン"}
+                </p>
 
                 <form className={styles.form} onSubmit={handleSubmit}>
                     <div className={styles.inputGroup}>
-                        <label className={styles.label} htmlFor="nickname">
-                            ニックネーム
+                        <label className={styles.label} htmlFor="username">
+                            ユーザー名
                         </label>
                         <input
-                            id="nickname"
+                            id="username"
                             type="text"
                             className={styles.input}
-                            placeholder="あなたの名前"
-                            value={nickname}
-                            onChange={(e) => setNickname(e.target.value)}
+                            placeholder={isRegister ? "英小文字・数字・_（3〜32文字）" : "ユーザー名"}
+                            value={username}
+                            onChange={(e) => setUsername(e.target.value)}
                             disabled={loading}
+                            autoComplete="username"
                             autoFocus
                         />
                     </div>
 
+                    <div className={styles.inputGroup}>
+                        <label className={styles.label} htmlFor="password">
+                            パスワード
+                        </label>
+                        <input
+                            id="password"
+                            type="password"
+                            className={styles.input}
+                            placeholder={isRegister ? "8文字以上" : "パスワード"}
+                            value={password}
+                            onChange={(e) => setPassword(e.target.value)}
+                            disabled={loading}
+                            autoComplete={isRegister ? "new-password" : "current-password"}
+                        />
+                    </div>
+
+                    {isRegister && (
+                        <div className={styles.inputGroup}>
+                            <label className={styles.label} htmlFor="nickname">
+                                表示名（省略時はユーザー名）
+                            </label>
+                            <input
+                                id="nickname"
+                                type="text"
+                                className={styles.input}
+                                placeholder="あなたの名前"
+                                value={nickname}
+                                onChange={(e) => setNickname(e.target.value)}
+                                disabled={loading}
+                            />
+                        </div>
+                    )}
+
                     {error && <p className={styles.error}>{error.message}</p>}
 
                     <button type="submit" className={styles.button} disabled={loading}>
-                        {loading ? "ログイン中..." : "チャットに参加"}
+                        {loading
+                            ? isRegister ? "登録中..." : "ログイン中..."
+                            : isRegister ? "登録して参加" : "チャットに参加"}
+                    </button>
+
+                    <button
+                        type="button"
+                        className={styles.switchButton}
+                        onClick={() => setMode(isRegister ? "login" : "register")}
+                        disabled={loading}
+                    >
+                        {isRegister ? "アカウントをお持ちの方はログイン" : "アカウントを作成する"}
                     </button>
                 </form>
             </div>
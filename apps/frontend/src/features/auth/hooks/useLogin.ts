@@ -1,15 +1,34 @@
 import { useCallback } from "react";
-import { useLoginMutation } from "@/graphql/generated";
+import { useLoginMutation, useRegisterMutation } from "@/graphql/generated";
+import type { LoginMutation } from "@/graphql/generated";
 import type { AuthUser, UseLoginOptions, UseLoginReturn } from "../types";
 
+// login / register の結果をアプリ側の認証情報に変換
+const toAuthUser = ({
+  token,
+  expiresAt,
+  refreshToken,
+  user,
+}: LoginMutation["login"]): AuthUser => ({
+  id: user.id,
+  username: user.username,
+  nickname: user.nickname,
+  token,
+  tokenExpiresAt: expiresAt,
+  refreshToken,
+});
+
 export function useLogin(options?: UseLoginOptions): UseLoginReturn {
-  const [loginMutation, { loading, error }] = useLoginMutation();
+  const [loginMutation, { loading: loginLoading, error: loginError }] =
+    useLoginMutation();
+  const [registerMutation, { loading: registerLoading, error: registerError }] =
+    useRegisterMutation();
 
   const login = useCallback(
-    async (nickname: string): Promise<AuthUser> => {
-      // ニックネームでログインミューテーションを実行
+    async (username: string, password: string): Promise<AuthUser> => {
+      // ユーザー名とパスワードでログインミューテーションを実行
       const result = await loginMutation({
-        variables: { nickname },
+        variables: { username, password },
       });
 
       // GraphQLエラーを明示的に扱う
@@ -22,10 +41,7 @@ export function useLogin(options?: UseLoginOptions): UseLoginReturn {
         throw new Error("ログインに失敗しました");
       }
 
-      const user: AuthUser = {
-        id: result.data.login.id,
-        nickname: result.data.login.nickname,
-      };
+      const user = toAuthUser(result.data.login);
 
       // 呼び出し元の追加処理があれば実行
       options?.onSuccess?.(user);
@@ -34,9 +50,38 @@ export function useLogin(options?: UseLoginOptions): UseLoginReturn {
     [loginMutation, options]
   );
 
+  const register = useCallback(
+    async (
+      username: string,
+      password: string,
+      nickname?: string
+    ): Promise<AuthUser> => {
+      // 登録するとそのままログインした状態になる
+      const result = await registerMutation({
+        variables: { username, password, nickname: nickname || null },
+      });
+
+      if (result.errors && result.errors.length > 0) {
+        throw new Error(result.errors[0].message);
+      }
+
+      if (!result.data?.register) {
+        throw new Error("登録に失敗しました");
+      }
+
+      const user = toAuthUser(result.data.register);
+
+      options?.onSuccess?.(user);
+      return user;
+    },
+    [registerMutation, options]
+  );
+
+  const error = loginError ?? registerError;
   return {
     login,
-    loading,
+    register,
+    loading: loginLoading || registerLoading,
     error: error ? new Error(error.message) : null,
   };
 }
@@ -2,7 +2,14 @@ export type { User } from "@/graphql/generated";
 
 export interface AuthUser {
   id: string;
+  // ログインに使うユーザー名（変更できない）と表示名
+  username: string;
   nickname: string;
+  // アクセストークン（Authorization: Bearer で送る）と有効期限（RFC 3339）
+  token: string;
+  tokenExpiresAt: string;
+  // アクセストークンの再発行に使う（再発行のたびに置き換わる）
+  refreshToken: string;
 }
 
 export interface LoginResult {
@@ -15,7 +22,12 @@ export interface UseLoginOptions {
 }
 
 export interface UseLoginReturn {
-  login: (nickname: string) => Promise<AuthUser>;
+  login: (username: string, password: string) => Promise<AuthUser>;
+  register: (
+    username: string,
+    password: string,
+    nickname?: string
+  ) => Promise<AuthUser>;
   loading: boolean;
   error: Error | null;
 }
@@ -6,10 +6,12 @@ type MessageItem = GetMessagesQuery["messages"][number];
 // メッセージの重複を避けつつキャッシュへ追加する
 export const appendMessageToCache = (
   cache: ApolloCache<unknown>,
+  roomId: string,
   newMessage: MessageItem
 ): boolean => {
   const existingData = cache.readQuery<GetMessagesQuery>({
     query: GetMessagesDocument,
+    variables: { roomId },
   });
 
   if (!existingData) {
@@ -23,6 +25,7 @@ export const appendMessageToCache = (
 
   cache.writeQuery<GetMessagesQuery>({
     query: GetMessagesDocument,
+    variables: { roomId },
     data: {
       messages: [...existingData.messages, newMessage],
     },
@@ -1,11 +1,13 @@
 import { useApolloClient } from "@apollo/client";
 import { useOnMessageAddedSubscription } from "@/graphql/generated";
 import { appendMessageToCache } from "../cache";
+import { DEFAULT_ROOM_ID } from "../types";
 
-export function useMessageSubscription(): void {
+export function useMessageSubscription(roomId: string = DEFAULT_ROOM_ID): void {
   const client = useApolloClient();
 
   useOnMessageAddedSubscription({
+    variables: { roomId },
     fetchPolicy: "no-cache",
     onData: ({ data: subscriptionData }) => {
       if (subscriptionData.error) {
@@ -19,7 +21,7 @@ export function useMessageSubscription(): void {
       }
 
       // Apolloキャッシュを更新して新着メッセージを反映
-      appendMessageToCache(client.cache, newMessage);
+      appendMessageToCache(client.cache, roomId, newMessage);
     },
     onError: (error) => {
       // サブスクエラーはUI側の通知に任せ、ここではログのみ
@@ -1,9 +1,10 @@
 import { useMemo } from "react";
 import { useGetMessagesQuery } from "@/graphql/generated";
-import type { ChatMessage, UseMessagesReturn } from "../types";
+import { DEFAULT_ROOM_ID, type ChatMessage, type UseMessagesReturn } from "../types";
 
-export function useMessages(): UseMessagesReturn {
+export function useMessages(roomId: string = DEFAULT_ROOM_ID): UseMessagesReturn {
   const { data, loading, error, refetch } = useGetMessagesQuery({
+    variables: { roomId },
     fetchPolicy: "cache-and-network",
   });
 
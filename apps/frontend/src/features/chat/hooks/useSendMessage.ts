@@ -2,17 +2,23 @@ import { useCallback } from "react";
 import {
   useSendMessageMutation,
 } from "@/graphql/generated";
-import type { ChatMessage, UseSendMessageOptions, UseSendMessageReturn } from "../types";
+import {
+  DEFAULT_ROOM_ID,
+  type ChatMessage,
+  type UseSendMessageOptions,
+  type UseSendMessageReturn,
+} from "../types";
 import { appendMessageToCache } from "../cache";
 
 export function useSendMessage(
-  options?: UseSendMessageOptions
+  options?: UseSendMessageOptions,
+  roomId: string = DEFAULT_ROOM_ID
 ): UseSendMessageReturn {
   const [sendMessageMutation, { loading, error }] = useSendMessageMutation({
     update: (cache, { data }) => {
       if (!data?.sendMessage) return;
       // キャッシュに存在する場合のみ、重複を避けて追加
-      appendMessageToCache(cache, data.sendMessage);
+      appendMessageToCache(cache, roomId, data.sendMessage);
     },
   });
 
@@ -20,7 +26,7 @@ export function useSendMessage(
     async (content: string): Promise<ChatMessage> => {
       // ミューテーションの実行
       const result = await sendMessageMutation({
-        variables: { content },
+        variables: { roomId, content },
       });
 
       // GraphQLエラーを明示的に扱う
@@ -47,7 +53,7 @@ export function useSendMessage(
       options?.onSuccess?.(message);
       return message;
     },
-    [sendMessageMutation, options]
+    [sendMessageMutation, options, roomId]
   );
 
   return {
@@ -1,6 +1,7 @@
 export { useMessages } from "./hooks/useMessages";
 export { useSendMessage } from "./hooks/useSendMessage";
 export { useMessageSubscription } from "./hooks/useMessageSubscription";
+export { DEFAULT_ROOM_ID } from "./types";
 export type {
   ChatMessage,
   UseMessagesReturn,
@@ -1,5 +1,8 @@
 export type { Message, User } from "@/graphql/generated";
 
+// ログイン時に全ユーザーが参加する既定のルーム
+export const DEFAULT_ROOM_ID = "general";
+
 export interface ChatMessage {
   id: string;
   content: string;
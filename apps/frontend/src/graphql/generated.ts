@@ -17,48 +17,367 @@ export type Scalars = {
   Float: { input: number; output: number; }
 };
 
+export type AuthPayload = {
+  __typename?: 'AuthPayload';
+  expiresAt: Scalars['String']['output'];
+  refreshToken: Scalars['String']['output'];
+  token: Scalars['String']['output'];
+  user: User;
+};
+
+export type ChatEvent = MessageAdded | MessageDeleted | MessageEdited | UserJoined | UserLeft;
+
 export type Message = {
   __typename?: 'Message';
   content: Scalars['String']['output'];
   createdAt: Scalars['String']['output'];
+  deleted: Scalars['Boolean']['output'];
+  deletedAt?: Maybe<Scalars['String']['output']>;
+  editedAt?: Maybe<Scalars['String']['output']>;
+  history: Array<MessageEdit>;
   id: Scalars['ID']['output'];
+  room: Room;
   user: User;
 };
 
+export type MessageAdded = {
+  __typename?: 'MessageAdded';
+  message: Message;
+};
+
+export type MessageConnection = {
+  __typename?: 'MessageConnection';
+  edges: Array<MessageEdge>;
+  pageInfo: PageInfo;
+  totalCount: Scalars['Int']['output'];
+};
+
+export type MessageDeleted = {
+  __typename?: 'MessageDeleted';
+  message: Message;
+};
+
+export type MessageEdge = {
+  __typename?: 'MessageEdge';
+  cursor: Scalars['String']['output'];
+  node: Message;
+};
+
+export type MessageEdit = {
+  __typename?: 'MessageEdit';
+  content: Scalars['String']['output'];
+  editedAt: Scalars['String']['output'];
+};
+
+export type MessageEdited = {
+  __typename?: 'MessageEdited';
+  message: Message;
+};
+
+export type ModerationAction = {
+  __typename?: 'ModerationAction';
+  action: ModerationActionKind;
+  createdAt: Scalars['String']['output'];
+  expiresAt?: Maybe<Scalars['String']['output']>;
+  id: Scalars['ID']['output'];
+  message?: Maybe<Message>;
+  moderator: User;
+  reason?: Maybe<Scalars['String']['output']>;
+  target: User;
+};
+
+export enum ModerationActionKind {
+  Ban = 'BAN',
+  Mute = 'MUTE',
+  Takedown = 'TAKEDOWN',
+  Unban = 'UNBAN',
+  Unmute = 'UNMUTE'
+}
+
 export type Mutation = {
   __typename?: 'Mutation';
-  login: User;
+  archiveRoom: Room;
+  banUser: ModerationAction;
+  createDirectRoom: Room;
+  createRoom: Room;
+  deleteMessage: Message;
+  editMessage: Message;
+  joinRoom: Room;
+  leaveRoom: Scalars['Boolean']['output'];
+  login: AuthPayload;
+  logout: Scalars['Boolean']['output'];
+  logoutAllSessions: Scalars['Boolean']['output'];
+  muteUser: ModerationAction;
+  refreshToken: AuthPayload;
+  register: AuthPayload;
   sendMessage: Message;
+  setPassword: User;
+  setUserRole: User;
+  takedownMessage: ModerationAction;
+  unbanUser: ModerationAction;
+  unmuteUser: ModerationAction;
+  updateNickname: User;
+};
+
+
+export type MutationArchiveRoomArgs = {
+  roomId: Scalars['ID']['input'];
+};
+
+
+export type MutationBanUserArgs = {
+  durationSeconds?: InputMaybe<Scalars['Int']['input']>;
+  reason?: InputMaybe<Scalars['String']['input']>;
+  userId: Scalars['ID']['input'];
+};
+
+
+export type MutationCreateDirectRoomArgs = {
+  userIds: Array<Scalars['ID']['input']>;
+};
+
+
+export type MutationCreateRoomArgs = {
+  name: Scalars['String']['input'];
+};
+
+
+export type MutationDeleteMessageArgs = {
+  id: Scalars['ID']['input'];
+};
+
+
+export type MutationEditMessageArgs = {
+  content: Scalars['String']['input'];
+  id: Scalars['ID']['input'];
+};
+
+
+export type MutationJoinRoomArgs = {
+  roomId: Scalars['ID']['input'];
+};
+
+
+export type MutationLeaveRoomArgs = {
+  roomId: Scalars['ID']['input'];
 };
 
 
 export type MutationLoginArgs = {
-  nickname: Scalars['String']['input'];
+  password: Scalars['String']['input'];
+  username: Scalars['String']['input'];
+};
+
+
+export type MutationMuteUserArgs = {
+  durationSeconds?: InputMaybe<Scalars['Int']['input']>;
+  reason?: InputMaybe<Scalars['String']['input']>;
+  userId: Scalars['ID']['input'];
+};
+
+
+export type MutationRefreshTokenArgs = {
+  refreshToken: Scalars['String']['input'];
+};
+
+
+export type MutationRegisterArgs = {
+  nickname?: InputMaybe<Scalars['String']['input']>;
+  password: Scalars['String']['input'];
+  username: Scalars['String']['input'];
 };
 
 
 export type MutationSendMessageArgs = {
   content: Scalars['String']['input'];
+  roomId: Scalars['ID']['input'];
+};
+
+
+export type MutationSetPasswordArgs = {
+  password: Scalars['String']['input'];
+};
+
+
+export type MutationSetUserRoleArgs = {
+  role: Role;
+  userId: Scalars['ID']['input'];
+};
+
+
+export type MutationTakedownMessageArgs = {
+  id: Scalars['ID']['input'];
+  reason?: InputMaybe<Scalars['String']['input']>;
+};
+
+
+export type MutationUnbanUserArgs = {
+  reason?: InputMaybe<Scalars['String']['input']>;
+  userId: Scalars['ID']['input'];
+};
+
+
+export type MutationUnmuteUserArgs = {
+  reason?: InputMaybe<Scalars['String']['input']>;
+  userId: Scalars['ID']['input'];
+};
+
+
+export type MutationUpdateNicknameArgs = {
+  nickname: Scalars['String']['input'];
+};
+
+export enum OverflowPolicy {
+  Block = 'BLOCK',
+  Disconnect = 'DISCONNECT',
+  DropNewest = 'DROP_NEWEST',
+  DropOldest = 'DROP_OLDEST'
+}
+
+export type PageInfo = {
+  __typename?: 'PageInfo';
+  endCursor?: Maybe<Scalars['String']['output']>;
+  hasNextPage: Scalars['Boolean']['output'];
+  hasPreviousPage: Scalars['Boolean']['output'];
+  startCursor?: Maybe<Scalars['String']['output']>;
 };
 
 export type Query = {
   __typename?: 'Query';
+  directRooms: Array<Room>;
   me?: Maybe<User>;
+  /** @deprecated Use messagesConnection. */
   messages: Array<Message>;
+  messagesConnection: MessageConnection;
+  moderationLog: Array<ModerationAction>;
+  room?: Maybe<Room>;
+  rooms: Array<Room>;
+  subscriberStats: Array<SubscriberStats>;
+};
+
+
+export type QueryMessagesArgs = {
+  roomId: Scalars['ID']['input'];
+};
+
+
+export type QueryMessagesConnectionArgs = {
+  after?: InputMaybe<Scalars['String']['input']>;
+  before?: InputMaybe<Scalars['String']['input']>;
+  first?: InputMaybe<Scalars['Int']['input']>;
+  last?: InputMaybe<Scalars['Int']['input']>;
+  roomId: Scalars['ID']['input'];
+};
+
+
+export type QueryModerationLogArgs = {
+  limit?: InputMaybe<Scalars['Int']['input']>;
+  userId?: InputMaybe<Scalars['ID']['input']>;
+};
+
+
+export type QueryRoomArgs = {
+  id: Scalars['ID']['input'];
+};
+
+
+export type QueryRoomsArgs = {
+  includeArchived?: InputMaybe<Scalars['Boolean']['input']>;
+};
+
+export enum Role {
+  Admin = 'ADMIN',
+  Guest = 'GUEST',
+  Member = 'MEMBER',
+  Moderator = 'MODERATOR'
+}
+
+export type Room = {
+  __typename?: 'Room';
+  archived: Scalars['Boolean']['output'];
+  archivedAt?: Maybe<Scalars['String']['output']>;
+  createdAt: Scalars['String']['output'];
+  createdBy?: Maybe<User>;
+  id: Scalars['ID']['output'];
+  kind: RoomKind;
+  members: Array<User>;
+  name: Scalars['String']['output'];
+};
+
+export enum RoomKind {
+  Channel = 'CHANNEL',
+  Direct = 'DIRECT'
+}
+
+export type SubscriberStats = {
+  __typename?: 'SubscriberStats';
+  dropped: Scalars['Int']['output'];
+  pending: Scalars['Int']['output'];
+  policy: OverflowPolicy;
+  topic: Scalars['String']['output'];
 };
 
 export type Subscription = {
   __typename?: 'Subscription';
+  chatEvents: ChatEvent;
+  directMessageReceived: Message;
   messageAdded: Message;
+  messageDeleted: Message;
+  messageUpdated: Message;
+};
+
+
+export type SubscriptionChatEventsArgs = {
+  overflow?: InputMaybe<OverflowPolicy>;
+  roomId: Scalars['ID']['input'];
+};
+
+
+export type SubscriptionDirectMessageReceivedArgs = {
+  overflow?: InputMaybe<OverflowPolicy>;
+};
+
+
+export type SubscriptionMessageAddedArgs = {
+  overflow?: InputMaybe<OverflowPolicy>;
+  roomId: Scalars['ID']['input'];
+};
+
+
+export type SubscriptionMessageDeletedArgs = {
+  overflow?: InputMaybe<OverflowPolicy>;
+  roomId: Scalars['ID']['input'];
+};
+
+
+export type SubscriptionMessageUpdatedArgs = {
+  overflow?: InputMaybe<OverflowPolicy>;
+  roomId: Scalars['ID']['input'];
 };
 
 export type User = {
   __typename?: 'User';
   id: Scalars['ID']['output'];
   nickname: Scalars['String']['output'];
+  role: Role;
+  username: Scalars['String']['output'];
 };
 
-export type GetMessagesQueryVariables = Exact<{ [key: string]: never; }>;
+export type UserJoined = {
+  __typename?: 'UserJoined';
+  room: Room;
+  user: User;
+};
+
+export type UserLeft = {
+  __typename?: 'UserLeft';
+  room: Room;
+  user: User;
+};
+
+export type GetMessagesQueryVariables = Exact<{
+  roomId: Scalars['ID']['input'];
+}>;
 
 
 export type GetMessagesQuery = { __typename?: 'Query', messages: Array<{ __typename?: 'Message', id: string, content: string, createdAt: string, user: { __typename?: 'User', id: string, nickname: string } }> };
@@ -69,28 +388,53 @@ export type GetMeQueryVariables = Exact<{ [key: string]: never; }>;
 export type GetMeQuery = { __typename?: 'Query', me?: { __typename?: 'User', id: string, nickname: string } | null };
 
 export type LoginMutationVariables = Exact<{
-  nickname: Scalars['String']['input'];
+  username: Scalars['String']['input'];
+  password: Scalars['String']['input'];
 }>;
 
 
-export type LoginMutation = { __typename?: 'Mutation', login: { __typename?: 'User', id: string, nickname: string } };
+export type LoginMutation = { __typename?: 'Mutation', login: { __typename?: 'AuthPayload', token: string, expiresAt: string, refreshToken: string, user: { __typename?: 'User', id: string, username: string, nickname: string } } };
+
+export type RegisterMutationVariables = Exact<{
+  username: Scalars['String']['input'];
+  password: Scalars['String']['input'];
+  nickname?: InputMaybe<Scalars['String']['input']>;
+}>;
+
+
+export type RegisterMutation = { __typename?: 'Mutation', register: { __typename?: 'AuthPayload', token: string, expiresAt: string, refreshToken: string, user: { __typename?: 'User', id: string, username: string, nickname: string } } };
+
+export type RefreshTokenMutationVariables = Exact<{
+  refreshToken: Scalars['String']['input'];
+}>;
+
+
+export type RefreshTokenMutation = { __typename?: 'Mutation', refreshToken: { __typename?: 'AuthPayload', token: string, expiresAt: string, refreshToken: string } };
+
+export type LogoutMutationVariables = Exact<{ [key: string]: never; }>;
+
+
+export type LogoutMutation = { __typename?: 'Mutation', logout: boolean };
 
 export type SendMessageMutationVariables = Exact<{
+  roomId: Scalars['ID']['input'];
   content: Scalars['String']['input'];
 }>;
 
 
 export type SendMessageMutation = { __typename?: 'Mutation', sendMessage: { __typename?: 'Message', id: string, content: string, createdAt: string, user: { __typename?: 'User', id: string, nickname: string } } };
 
-export type OnMessageAddedSubscriptionVariables = Exact<{ [key: string]: never; }>;
+export type OnMessageAddedSubscriptionVariables = Exact<{
+  roomId: Scalars['ID']['input'];
+}>;
 
 
 export type OnMessageAddedSubscription = { __typename?: 'Subscription', messageAdded: { __typename?: 'Message', id: string, content: string, createdAt: string, user: { __typename?: 'User', id: string, nickname: string } } };
 
 
 export const GetMessagesDocument = gql`
-    query GetMessages {
-  messages {
+    query GetMessages($roomId: ID!) {
+  messages(roomId: $roomId) {
     id
     user {
       id
@@ -114,10 +458,11 @@ export const GetMessagesDocument = gql`
  * @example
  * const { data, loading, error } = useGetMessagesQuery({
  *   variables: {
+ *      roomId: // value for 'roomId'
  *   },
  * });
  */
-export function useGetMessagesQuery(baseOptions?: Apollo.QueryHookOptions<GetMessagesQuery, GetMessagesQueryVariables>) {
+export function useGetMessagesQuery(baseOptions: Apollo.QueryHookOptions<GetMessagesQuery, GetMessagesQueryVariables> & ({ variables: GetMessagesQueryVariables; skip?: boolean; } | { skip: boolean; }) ) {
         const options = {...defaultOptions, ...baseOptions}
         return Apollo.useQuery<GetMessagesQuery, GetMessagesQueryVariables>(GetMessagesDocument, options);
       }
@@ -180,10 +525,16 @@ export type GetMeLazyQueryHookResult = ReturnType<typeof useGetMeLazyQuery>;
 export type GetMeSuspenseQueryHookResult = ReturnType<typeof useGetMeSuspenseQuery>;
 export type GetMeQueryResult = Apollo.QueryResult<GetMeQuery, GetMeQueryVariables>;
 export const LoginDocument = gql`
-    mutation Login($nickname: String!) {
-  login(nickname: $nickname) {
-    id
-    nickname
+    mutation Login($username: String!, $password: String!) {
+  login(username: $username, password: $password) {
+    token
+    expiresAt
+    refreshToken
+    user {
+      id
+      username
+      nickname
+    }
   }
 }
     `;
@@ -202,7 +553,8 @@ export type LoginMutationFn = Apollo.MutationFunction<LoginMutation, LoginMutati
  * @example
  * const [loginMutation, { data, loading, error }] = useLoginMutation({
  *   variables: {
- *      nickname: // value for 'nickname'
+ *      username: // value for 'username'
+ *      password: // value for 'password'
  *   },
  * });
  */
@@ -213,9 +565,116 @@ export function useLoginMutation(baseOptions?: Apollo.MutationHookOptions<LoginM
 export type LoginMutationHookResult = ReturnType<typeof useLoginMutation>;
 export type LoginMutationResult = Apollo.MutationResult<LoginMutation>;
 export type LoginMutationOptions = Apollo.BaseMutationOptions<LoginMutation, LoginMutationVariables>;
+export const RegisterDocument = gql`
+    mutation Register($username: String!, $password: String!, $nickname: String) {
+  register(username: $username, password: $password, nickname: $nickname) {
+    token
+    expiresAt
+    refreshToken
+    user {
+      id
+      username
+      nickname
+    }
+  }
+}
+    `;
+export type RegisterMutationFn = Apollo.MutationFunction<RegisterMutation, RegisterMutationVariables>;
+
+/**
+ * __useRegisterMutation__
+ *
+ * To run a mutation, you first call `useRegisterMutation` within a React component and pass it any options that fit your needs.
+ * When your component renders, `useRegisterMutation` returns a tuple that includes:
+ * - A mutate function that you can call at any time to execute the mutation
+ * - An object with fields that represent the current status of the mutation's execution
+ *
+ * @param baseOptions options that will be passed into the mutation, supported options are listed on: https://www.apollographql.com/docs/react/api/react-hooks/#options-2;
+ *
+ * @example
+ * const [registerMutation, { data, loading, error }] = useRegisterMutation({
+ *   variables: {
+ *      username: // value for 'username'
+ *      password: // value for 'password'
+ *      nickname: // value for 'nickname'
+ *   },
+ * });
+ */
+export function useRegisterMutation(baseOptions?: Apollo.MutationHookOptions<RegisterMutation, RegisterMutationVariables>) {
+        const options = {...defaultOptions, ...baseOptions}
+        return Apollo.useMutation<RegisterMutation, RegisterMutationVariables>(RegisterDocument, options);
+      }
+export type RegisterMutationHookResult = ReturnType<typeof useRegisterMutation>;
+export type RegisterMutationResult = Apollo.MutationResult<RegisterMutation>;
+export type RegisterMutationOptions = Apollo.BaseMutationOptions<RegisterMutation, RegisterMutationVariables>;
+export const RefreshTokenDocument = gql`
+    mutation RefreshToken($refreshToken: String!) {
+  refreshToken(refreshToken: $refreshToken) {
+    token
+    expiresAt
+    refreshToken
+  }
+}
+    `;
+export type RefreshTokenMutationFn = Apollo.MutationFunction<RefreshTokenMutation, RefreshTokenMutationVariables>;
+
+/**
+ * __useRefreshTokenMutation__
+ *
+ * To run a mutation, you first call `useRefreshTokenMutation` within a React component and pass it any options that fit your needs.
+ * When your component renders, `useRefreshTokenMutation` returns a tuple that includes:
+ * - A mutate function that you can call at any time to execute the mutation
+ * - An object with fields that represent the current status of the mutation's execution
+ *
+ * @param baseOptions options that will be passed into the mutation, supported options are listed on: https://www.apollographql.com/docs/react/api/react-hooks/#options-2;
+ *
+ * @example
+ * const [refreshTokenMutation, { data, loading, error }] = useRefreshTokenMutation({
+ *   variables: {
+ *      refreshToken: // value for 'refreshToken'
+ *   },
+ * });
+ */
+export function useRefreshTokenMutation(baseOptions?: Apollo.MutationHookOptions<RefreshTokenMutation, RefreshTokenMutationVariables>) {
+        const options = {...defaultOptions, ...baseOptions}
+        return Apollo.useMutation<RefreshTokenMutation, RefreshTokenMutationVariables>(RefreshTokenDocument, options);
+      }
+export type RefreshTokenMutationHookResult = ReturnType<typeof useRefreshTokenMutation>;
+export type RefreshTokenMutationResult = Apollo.MutationResult<RefreshTokenMutation>;
+export type RefreshTokenMutationOptions = Apollo.BaseMutationOptions<RefreshTokenMutation, RefreshTokenMutationVariables>;
+export const LogoutDocument = gql`
+    mutation Logout {
+  logout
+}
+    `;
+export type LogoutMutationFn = Apollo.MutationFunction<LogoutMutation, LogoutMutationVariables>;
+
+/**
+ * __useLogoutMutation__
+ *
+ * To run a mutation, you first call `useLogoutMutation` within a React component and pass it any options that fit your needs.
+ * When your component renders, `useLogoutMutation` returns a tuple that includes:
+ * - A mutate function that you can call at any time to execute the mutation
+ * - An object with fields that represent the current status of the mutation's execution
+ *
+ * @param baseOptions options that will be passed into the mutation, supported options are listed on: https://www.apollographql.com/docs/react/api/react-hooks/#options-2;
+ *
+ * @example
+ * const [logoutMutation, { data, loading, error }] = useLogoutMutation({
+ *   variables: {
+ *   },
+ * });
+ */
+export function useLogoutMutation(baseOptions?: Apollo.MutationHookOptions<LogoutMutation, LogoutMutationVariables>) {
+        const options = {...defaultOptions, ...baseOptions}
+        return Apollo.useMutation<LogoutMutation, LogoutMutationVariables>(LogoutDocument, options);
+      }
+export type LogoutMutationHookResult = ReturnType<typeof useLogoutMutation>;
+export type LogoutMutationResult = Apollo.MutationResult<LogoutMutation>;
+export type LogoutMutationOptions = Apollo.BaseMutationOptions<LogoutMutation, LogoutMutationVariables>;
 export const SendMessageDocument = gql`
-    mutation SendMessage($content: String!) {
-  sendMessage(content: $content) {
+    mutation SendMessage($roomId: ID!, $content: String!) {
+  sendMessage(roomId: $roomId, content: $content) {
     id
     user {
       id
@@ -241,6 +700,7 @@ export type SendMessageMutationFn = Apollo.MutationFunction<SendMessageMutation,
  * @example
  * const [sendMessageMutation, { data, loading, error }] = useSendMessageMutation({
  *   variables: {
+ *      roomId: // value for 'roomId'
  *      content: // value for 'content'
  *   },
  * });
@@ -253,8 +713,8 @@ export type SendMessageMutationHookResult = ReturnType<typeof useSendMessageMuta
 export type SendMessageMutationResult = Apollo.MutationResult<SendMessageMutation>;
 export type SendMessageMutationOptions = Apollo.BaseMutationOptions<SendMessageMutation, SendMessageMutationVariables>;
 export const OnMessageAddedDocument = gql`
-    subscription OnMessageAdded {
-  messageAdded {
+    subscription OnMessageAdded($roomId: ID!) {
+  messageAdded(roomId: $roomId) {
     id
     user {
       id
@@ -278,10 +738,11 @@ export const OnMessageAddedDocument = gql`
  * @example
  * const { data, loading, error } = useOnMessageAddedSubscription({
  *   variables: {
+ *      roomId: // value for 'roomId'
  *   },
  * });
  */
-export function useOnMessageAddedSubscription(baseOptions?: Apollo.SubscriptionHookOptions<OnMessageAddedSubscription, OnMessageAddedSubscriptionVariables>) {
+export function useOnMessageAddedSubscription(baseOptions: Apollo.SubscriptionHookOptions<OnMessageAddedSubscription, OnMessageAddedSubscriptionVariables> & ({ variables: OnMessageAddedSubscriptionVariables; skip?: boolean; } | { skip: boolean; }) ) {
         const options = {...defaultOptions, ...baseOptions}
         return Apollo.useSubscription<OnMessageAddedSubscription, OnMessageAddedSubscriptionVariables>(OnMessageAddedDocument, options);
       }
@@ -7,30 +7,119 @@ import {
   Observable,
 } from "@apollo/client";
 import type { FetchResult, Operation, NextLink } from "@apollo/client";
+import { setContext } from "@apollo/client/link/context";
 import { getMainDefinition } from "@apollo/client/utilities";
 import { print } from "graphql";
 import { createClient } from "graphql-sse";
+import { RefreshTokenDocument } from "@/graphql/generated";
+import type { RefreshTokenMutation } from "@/graphql/generated";
+import persistedQueryManifest from "@/graphql/persisted-query-manifest.json";
 
 const GRAPHQL_ENDPOINT = "http://localhost:8080/graphql";
 
-// 固定の認証トークン（本番ではCognitoトークンを使用）
-const AUTH_TOKEN = "sample-auth-token-12345";
+// 登録済みの操作のハッシュ（操作名 → sha256）
+// サーバーに登録されている操作はクエリ文字列の代わりにハッシュだけを送る
+const persistedQueryIds = new Map(
+  persistedQueryManifest.operations.map((op) => [op.name, op.id])
+);
+
+const persistedQueryExtensions = (id: string) => ({
+  persistedQuery: { version: 1, sha256Hash: id },
+});
+
+// ハッシュの操作がサーバーに登録されていなかったか（クエリ文字列で送り直す）
+const isPersistedQueryNotFound = (result: FetchResult) =>
+  result.errors?.some(
+    (error) => error.extensions?.code === "PERSISTED_QUERY_NOT_FOUND"
+  ) ?? false;
 
-// ログイン済みユーザーIDの保持（リロード時はApp側で復元）
-let currentUserId: string | null = null;
+// login で発行されたトークンの保持（リロード時はApp側で復元）
+// ユーザーはサーバー側でアクセストークンの subject から決まる
+export interface AuthTokens {
+  token: string;
+  tokenExpiresAt: string;
+  refreshToken: string;
+}
+
+let authTokens: AuthTokens | null = null;
+let onTokensRefreshed: ((tokens: AuthTokens | null) => void) | null = null;
+let refreshing: Promise<AuthTokens | null> | null = null;
 
-export const setCurrentUserId = (userId: string | null) => {
-  currentUserId = userId;
+export const setAuthTokens = (tokens: AuthTokens | null) => {
+  authTokens = tokens;
 };
 
-export const getCurrentUserId = () => currentUserId;
+export const getAuthTokens = () => authTokens;
+
+// 再発行したトークン（失敗時は null）を受け取る（ストレージへの保存やログアウトに使う）
+export const setOnTokensRefreshed = (
+  callback: ((tokens: AuthTokens | null) => void) | null
+) => {
+  onTokensRefreshed = callback;
+};
+
+// 期限切れの少し前に再発行する
+const REFRESH_MARGIN_MS = 30 * 1000;
+
+const isExpiring = (tokens: AuthTokens) =>
+  Date.parse(tokens.tokenExpiresAt) - REFRESH_MARGIN_MS <= Date.now();
+
+// リフレッシュトークンで再発行する
+// 期限切れのアクセストークンは401で拒否されるため Authorization は付けない。
+// リフレッシュトークンは1回しか使えないので、同時に呼ばれても1回だけ送る
+const refreshAuthTokens = (): Promise<AuthTokens | null> => {
+  if (!refreshing) {
+    const current = authTokens;
+    refreshing = (async () => {
+      if (!current) return null;
+      const send = async (persisted: boolean) => {
+        const id = persistedQueryIds.get("RefreshToken");
+        const response = await fetch(GRAPHQL_ENDPOINT, {
+          method: "POST",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({
+            query: persisted && id ? "" : print(RefreshTokenDocument),
+            operationName: "RefreshToken",
+            variables: { refreshToken: current.refreshToken },
+            extensions: persisted && id ? persistedQueryExtensions(id) : undefined,
+          }),
+        });
+        return (await response.json()) as FetchResult<RefreshTokenMutation>;
+      };
+      try {
+        let result = await send(true);
+        if (isPersistedQueryNotFound(result)) {
+          result = await send(false);
+        }
+        const refreshed = result.data?.refreshToken;
+        authTokens = refreshed
+          ? {
+              token: refreshed.token,
+              tokenExpiresAt: refreshed.expiresAt,
+              refreshToken: refreshed.refreshToken,
+            }
+          : null;
+      } catch {
+        // 通信エラーの場合は次のリクエストで再試行する
+        return authTokens;
+      }
+      onTokensRefreshed?.(authTokens);
+      return authTokens;
+    })().finally(() => {
+      refreshing = null;
+    });
+  }
+  return refreshing;
+};
 
-const buildAuthHeader = (): Record<string, string> => {
-  const headers: Record<string, string> = {
-    Authorization: `Bearer ${AUTH_TOKEN}`,
-  };
-  if (currentUserId) {
-    headers["X-User-ID"] = currentUserId;
+const buildAuthHeader = async (): Promise<Record<string, string>> => {
+  const headers: Record<string, string> = {};
+  let tokens = authTokens;
+  if (tokens && isExpiring(tokens)) {
+    tokens = await refreshAuthTokens();
+  }
+  if (tokens) {
+    headers.Authorization = `Bearer ${tokens.token}`;
   }
   return headers;
 };
@@ -43,26 +132,69 @@ const httpLink = new HttpLink({
   },
 });
 
-// Auth Link
-const authLink = new ApolloLink((operation, forward) => {
-  operation.setContext(({ headers = {} }) => ({
-    headers: {
-      ...headers,
-      ...buildAuthHeader(),
-    },
-  }));
-  return forward(operation);
+// Persisted Query Link（登録済みの操作はハッシュだけを送る）
+// サーバーに登録されていなければクエリ文字列で送り直す
+const persistedQueryLink = new ApolloLink((operation, forward) => {
+  const id = persistedQueryIds.get(operation.operationName);
+  if (!id) return forward(operation);
+
+  return new Observable<FetchResult>((observer) => {
+    let subscription: { unsubscribe: () => void } | null = null;
+
+    const send = (persisted: boolean) => {
+      let retry = false;
+      operation.extensions.persistedQuery = persisted
+        ? persistedQueryExtensions(id).persistedQuery
+        : undefined;
+      operation.setContext({
+        http: { includeQuery: !persisted, includeExtensions: persisted },
+      });
+      subscription = forward(operation).subscribe({
+        next: (result) => {
+          if (persisted && isPersistedQueryNotFound(result)) {
+            retry = true;
+            return;
+          }
+          observer.next(result);
+        },
+        error: (err) => observer.error(err),
+        complete: () => (retry ? send(false) : observer.complete()),
+      });
+    };
+    send(true);
+
+    return () => subscription?.unsubscribe();
+  });
 });
 
+// Auth Link（必要ならアクセストークンを再発行してから送る）
+const authLink = setContext(async (_, { headers = {} }) => ({
+  headers: {
+    ...headers,
+    ...(await buildAuthHeader()),
+  },
+}));
+
 // SSE Client for Subscriptions
+// single connectionモードで全サブスクリプションを1本のストリームに多重化する
 const sseClient = createClient({
   url: GRAPHQL_ENDPOINT,
-  headers: () => ({
-    ...buildAuthHeader(),
-  }),
+  singleConnection: true,
+  headers: buildAuthHeader,
 });
 
+// 認証情報の期限切れ・失効でサーバーが購読を打ち切ったか
+const isUnauthenticated = (result: FetchResult) =>
+  result.errors?.some(
+    (error) => error.extensions?.code === "UNAUTHENTICATED"
+  ) ?? false;
+
+// 連続して再接続する回数の上限（データを受け取るとリセット）
+const MAX_REAUTH_ATTEMPTS = 3;
+
 // SSE Link for Subscriptions
+// 接続時のトークンが期限切れになると、サーバーは UNAUTHENTICATED エラーの後に購読を終了する。
+// その場合はエラーを流さず、再発行したトークンで購読し直す
 class SSELink extends ApolloLink {
   public request(
     operation: Operation,
@@ -70,22 +202,59 @@ class SSELink extends ApolloLink {
   ): Observable<FetchResult> | null {
     return new Observable<FetchResult>((observer) => {
       const { query, variables, operationName } = operation;
+      const id = persistedQueryIds.get(operationName);
+      let unsubscribe: () => void = () => {};
+      let closed = false;
+      let attempts = 0;
+      let persisted = id !== undefined;
 
-      // graphql-sse に合わせてクエリ文字列へ変換
-      const unsubscribe = sseClient.subscribe(
-        {
-          query: print(query),
-          variables: variables as Record<string, unknown>,
-          operationName: operationName ?? undefined,
-        },
-        {
-          next: (data) => observer.next(data as FetchResult),
-          error: (err) => observer.error(err),
-          complete: () => observer.complete(),
-        }
-      );
+      const subscribe = () => {
+        let reauthenticate = false;
+        let resend = false;
+
+        // graphql-sse に合わせてクエリ文字列へ変換（登録済みの操作はハッシュだけを送る）
+        unsubscribe = sseClient.subscribe(
+          {
+            query: persisted ? "" : print(query),
+            variables: variables as Record<string, unknown>,
+            operationName: operationName ?? undefined,
+            extensions: persisted && id ? persistedQueryExtensions(id) : undefined,
+          },
+          {
+            next: (data) => {
+              const result = data as FetchResult;
+              if (persisted && isPersistedQueryNotFound(result)) {
+                resend = true;
+                return;
+              }
+              if (isUnauthenticated(result) && getAuthTokens()) {
+                reauthenticate = true;
+                return;
+              }
+              attempts = 0;
+              observer.next(result);
+            },
+            error: (err) => observer.error(err),
+            complete: () => {
+              if (resend && !closed) {
+                persisted = false;
+                subscribe();
+                return;
+              }
+              if (reauthenticate && !closed && attempts < MAX_REAUTH_ATTEMPTS) {
+                attempts++;
+                subscribe();
+                return;
+              }
+              observer.complete();
+            },
+          }
+        );
+      };
+      subscribe();
 
       return () => {
+        closed = true;
         unsubscribe();
       };
     });
@@ -104,7 +273,7 @@ const splitLink = split(
     );
   },
   sseLink,
-  authLink.concat(httpLink)
+  ApolloLink.from([persistedQueryLink, authLink, httpLink])
 );
 
 export const apolloClient = new ApolloClient({